@@ -0,0 +1,142 @@
+package client
+
+import "fmt"
+import "time"
+import "bytes"
+import "strconv"
+import "net/url"
+import "net/http"
+import "io/ioutil"
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+
+// the same scheme as in app/server/auth.go:
+const (
+	signScheme          = "KS2-HMAC-SHA256"
+	signHeaderTimestamp = "X-Ks-Timestamp"
+	signHeaderNonce     = "X-Ks-Nonce"
+
+	mimeJson = "application/json"
+)
+
+// API client for the cluster administration commands
+type ApiClient struct {
+	baseUrl string
+	keyId   string
+	secret  string
+
+	http *http.Client
+}
+
+type apiResponse struct {
+	Errors []*struct {
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+func NewApiClient(baseUrl, keyId, secret string) *ApiClient {
+	return &ApiClient{
+		baseUrl: baseUrl,
+		keyId:   keyId,
+		secret:  secret,
+		http: &http.Client{
+			Timeout: 30 * time.Second},
+	}
+}
+
+// Do sends the signed request and returns the raw response body:
+func (m *ApiClient) Do(method, path string, query url.Values, payload interface{}) ([]byte, error) {
+
+	var body []byte
+	if payload != nil {
+		var e error
+		if body, e = json.Marshal(payload); e != nil {
+			return nil, e
+		}
+	}
+
+	link, e := url.Parse(m.baseUrl + path)
+	if e != nil {
+		return nil, e
+	}
+	link.RawQuery = query.Encode()
+
+	req, e := http.NewRequest(method, link.String(), bytes.NewReader(body))
+	if e != nil {
+		return nil, e
+	}
+
+	req.Header.Set("Accept", mimeJson)
+	if payload != nil {
+		req.Header.Set("Content-Type", mimeJson)
+	}
+
+	if e = m.signRequest(req, body); e != nil {
+		return nil, e
+	}
+
+	rsp, e := m.http.Do(req)
+	if e != nil {
+		return nil, e
+	}
+	defer rsp.Body.Close()
+
+	rspBody, e := ioutil.ReadAll(rsp.Body)
+	if e != nil {
+		return nil, e
+	}
+
+	if rsp.StatusCode >= http.StatusBadRequest {
+		return rspBody, m.parseErrors(rsp.StatusCode, rspBody)
+	}
+
+	return rspBody, nil
+}
+
+func (m *ApiClient) signRequest(req *http.Request, body []byte) error {
+
+	var nonce = make([]byte, 16)
+	if _, e := rand.Read(nonce); e != nil {
+		return e
+	}
+
+	var timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	var bodyHash = sha256.Sum256(body)
+	var stringToSign bytes.Buffer
+
+	for _, v := range []string{
+		signScheme,
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		timestamp,
+		hex.EncodeToString(nonce),
+	} {
+		stringToSign.WriteString(v)
+		stringToSign.WriteByte('\n')
+	}
+	stringToSign.WriteString(hex.EncodeToString(bodyHash[:]))
+
+	mac := hmac.New(sha256.New, []byte(m.secret))
+	mac.Write(stringToSign.Bytes())
+
+	req.Header.Set("Authorization", signScheme+" "+m.keyId+":"+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set(signHeaderTimestamp, timestamp)
+	req.Header.Set(signHeaderNonce, hex.EncodeToString(nonce))
+
+	return nil
+}
+
+func (m *ApiClient) parseErrors(status int, body []byte) error {
+
+	var rsp *apiResponse
+	if e := json.Unmarshal(body, &rsp); e != nil || rsp == nil || len(rsp.Errors) == 0 {
+		return fmt.Errorf("The API has responded with status %d", status)
+	}
+
+	return fmt.Errorf("The API has responded with status %d: %s - %s", status, rsp.Errors[0].Title, rsp.Errors[0].Detail)
+}
@@ -0,0 +1,284 @@
+package server
+
+import "fmt"
+import "net"
+import "sort"
+import "time"
+import "regexp"
+import "crypto/rand"
+import "encoding/hex"
+import "encoding/json"
+
+const (
+	apiScopeHostCreate   = "host:create"
+	apiScopeHostRead     = "host:read"
+	apiScopeJobRetry     = "job:retry"
+	apiScopeClusterAdmin = "cluster:admin"
+)
+
+const (
+	apiKeysBucket = "api_keys"
+
+	// the key is built from Api.SignSecret for backward compatibility:
+	apiKeyDefaultId = "default"
+)
+
+// the default key signs the requests of old clients, so it can't manage the cluster:
+var apiLegacyScopes = []string{
+	apiScopeHostCreate,
+	apiScopeHostRead,
+	apiScopeJobRetry,
+}
+
+var apiKeyIdRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{2,63}$`)
+
+var apiScopes = []string{
+	apiScopeHostCreate,
+	apiScopeHostRead,
+	apiScopeJobRetry,
+	apiScopeClusterAdmin,
+}
+
+// API keys are replicated through the raft store, so every node has the same keys
+type apiKey struct {
+	Id         string    `json:"id"`
+	Secret     string    `json:"secret"`
+	Scopes     []string  `json:"scopes"`
+	Cidrs      []string  `json:"cidrs,omitempty"`
+	Created_At time.Time `json:"created_at"`
+
+	// the previous secret stays valid during the rotation grace period:
+	Previous_Secret     string    `json:"previous_secret,omitempty"`
+	Previous_Expires_At time.Time `json:"previous_expires_at,omitempty"`
+	Rotated_At          time.Time `json:"rotated_at,omitempty"`
+
+	// the secret is shown only once - in the issue or rotate response:
+	showSecret bool
+}
+
+func newApiKey(kId string, scopes, cidrs []string) (*apiKey, *appError) {
+
+	if !apiKeyIdRegexp.MatchString(kId) || kId == apiKeyDefaultId {
+		return nil, newAppError(errApiKeyInvalid).setPointer("/data/id").log(nil, "The given key id is not valid!")
+	}
+
+	if len(scopes) == 0 {
+		return nil, newAppError(errApiKeyInvalid).setPointer("/data/attributes/scopes").log(nil, "The key must have at least one scope!")
+	}
+
+	for i, v := range scopes {
+		if !isApiScope(v) {
+			return nil, newAppError(errApiKeyInvalid).setPointer(fmt.Sprintf("/data/attributes/scopes/%d", i)).log(nil, "The given key scope is unknown!")
+		}
+	}
+
+	for i, v := range cidrs {
+		if _, _, e := net.ParseCIDR(v); e != nil {
+			return nil, newAppError(errApiKeyInvalid).setPointer(fmt.Sprintf("/data/attributes/cidrs/%d", i)).log(e, "Could not parse the given key CIDR!")
+		}
+	}
+
+	key, err := getApiKey(kId)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil {
+		return nil, newAppError(errApiKeyExists).setPointer("/data/id").log(nil, "The key with the given id already exists!")
+	}
+
+	secret, err := newApiKeySecret()
+	if err != nil {
+		return nil, err
+	}
+
+	key = &apiKey{
+		Id:         kId,
+		Secret:     secret,
+		Scopes:     scopes,
+		Cidrs:      cidrs,
+		Created_At: time.Now(),
+		showSecret: true,
+	}
+
+	return key, key.save()
+}
+
+func newApiKeySecret() (string, *appError) {
+
+	var buf = make([]byte, 32)
+	if _, e := rand.Read(buf); e != nil {
+		return "", newAppError(errInternalCommonError).log(e, "Could not generate a new key secret!")
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func getApiKeys() ([]*apiKey, *appError) {
+
+	var keys []*apiKey
+
+	for _, v := range globRaftStore.List(apiKeysBucket) {
+		var key *apiKey
+		if e := json.Unmarshal([]byte(v), &key); e != nil {
+			return keys, newAppError(errInternalCommonError).log(e, "Could not unmarshal the api key from the store!")
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Id < keys[j].Id })
+	return keys, nil
+}
+
+func getApiKey(kId string) (*apiKey, *appError) {
+
+	if kId == apiKeyDefaultId {
+		if globConfig.Base.Api.SignSecret == "" {
+			return nil, nil
+		}
+
+		return &apiKey{
+			Id:     apiKeyDefaultId,
+			Secret: globConfig.Base.Api.SignSecret,
+			Scopes: apiLegacyScopes,
+		}, nil
+	}
+
+	var buf = globRaftStore.Get(apiKeysBucket, kId)
+	if buf == "" {
+		return nil, nil
+	}
+
+	var key *apiKey
+	if e := json.Unmarshal([]byte(buf), &key); e != nil {
+		return nil, newAppError(errInternalCommonError).log(e, "Could not unmarshal the api key from the store!")
+	}
+
+	return key, nil
+}
+
+func (m *apiKey) save() *appError {
+
+	buf, e := json.Marshal(m)
+	if e != nil {
+		return newAppError(errInternalCommonError).log(e, "Could not marshal the api key!")
+	}
+
+	if e = globRaftStore.Set(apiKeysBucket, m.Id, string(buf)); e != nil {
+		return newAppError(errInternalRaftError).log(e, "Could not save the api key in the store!")
+	}
+
+	return nil
+}
+
+func (m *apiKey) rotate(grace time.Duration) *appError {
+
+	if m.Id == apiKeyDefaultId {
+		return newAppError(errApiKeyReadOnly).log(nil, "The default key could be changed in the configuration file only!")
+	}
+
+	secret, err := newApiKeySecret()
+	if err != nil {
+		return err
+	}
+
+	var now = time.Now()
+
+	m.Previous_Secret, m.Previous_Expires_At = m.Secret, now.Add(grace)
+	m.Secret, m.Rotated_At = secret, now
+	m.showSecret = true
+
+	return m.save()
+}
+
+// revocation is a single raft commit, so it's applied on every node at once:
+func (m *apiKey) revoke() *appError {
+
+	if m.Id == apiKeyDefaultId {
+		return newAppError(errApiKeyReadOnly).log(nil, "The default key could be changed in the configuration file only!")
+	}
+
+	if e := globRaftStore.Del(apiKeysBucket, m.Id); e != nil {
+		return newAppError(errInternalRaftError).log(e, "Could not delete the api key from the store!")
+	}
+
+	return nil
+}
+
+func (m *apiKey) getValidSecrets(now time.Time) []string {
+
+	var secrets = []string{m.Secret}
+	if m.Previous_Secret != "" && now.Before(m.Previous_Expires_At) {
+		secrets = append(secrets, m.Previous_Secret)
+	}
+
+	return secrets
+}
+
+// cluster:admin scope grants everything:
+func (m *apiKey) hasScope(scope string) bool {
+	for _, v := range m.Scopes {
+		if v == scope || v == apiScopeClusterAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *apiKey) isAllowedAddress(addr string) bool {
+
+	if len(m.Cidrs) == 0 {
+		return true
+	}
+
+	var ip = net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, v := range m.Cidrs {
+		if _, block, e := net.ParseCIDR(v); e == nil && block.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isApiScope(scope string) bool {
+	for _, v := range apiScopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// apiResource interface implementation:
+func (m *apiKey) getResourceType() string { return apiTypeKey }
+func (m *apiKey) getResourceId() string   { return m.Id }
+
+func (m *apiKey) getResourceAttributes() interface{} {
+
+	var attributes = &attributesKey{
+		Scopes:     m.Scopes,
+		Cidrs:      m.Cidrs,
+		Created_At: m.Created_At.Format(time.RFC3339),
+	}
+
+	if m.showSecret {
+		attributes.Secret = m.Secret
+	}
+
+	if !m.Rotated_At.IsZero() {
+		attributes.Rotated_At = m.Rotated_At.Format(time.RFC3339)
+	}
+
+	if m.Previous_Secret != "" {
+		attributes.Previous_Expires_At = m.Previous_Expires_At.Format(time.RFC3339)
+	}
+
+	return attributes
+}
+
+func (m *apiKey) getResourceRelations() []*resourceRelation { return nil }
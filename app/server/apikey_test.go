@@ -0,0 +1,46 @@
+package server
+
+import "testing"
+
+func TestApiKeyIsAllowedAddress(t *testing.T) {
+
+	var key = &apiKey{Cidrs: []string{"10.0.0.0/8", "2001:db8::/32"}}
+
+	var tests = []struct {
+		addr    string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.1", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"", false},
+		{"10.1.2.3:8080", false},
+	}
+
+	for _, tt := range tests {
+		if allowed := key.isAllowedAddress(tt.addr); allowed != tt.allowed {
+			t.Errorf("isAllowedAddress(%q) = %v, want %v", tt.addr, allowed, tt.allowed)
+		}
+	}
+
+	if !new(apiKey).isAllowedAddress("192.168.1.1") {
+		t.Errorf("the key without CIDRs must be allowed from any address")
+	}
+}
+
+func TestDefaultApiKeyScopes(t *testing.T) {
+
+	key, err := getApiKey(apiKeyDefaultId)
+	if err != nil || key == nil {
+		t.Fatal("the default key must be built from the sign secret")
+	}
+
+	if key.hasScope(apiScopeClusterAdmin) {
+		t.Errorf("the default key must not have the %s scope", apiScopeClusterAdmin)
+	}
+
+	if !key.hasScope(apiScopeHostCreate) {
+		t.Errorf("the default key must have the %s scope", apiScopeHostCreate)
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import "time"
+import "database/sql"
+import "github.com/satori/go.uuid"
+
+const (
+	auditActKeyIssued   = "key_issued"
+	auditActKeyRotated  = "key_rotated"
+	auditActKeyRevoked  = "key_revoked"
+	auditActJobRequeued = "job_requeued"
+	auditActJobTimedOut = "job_timed_out"
+
+	auditActQueuePaused       = "queue_paused"
+	auditActQueueResumed      = "queue_resumed"
+	auditActQueueActPaused    = "queue_action_paused"
+	auditActQueueActResumed   = "queue_action_resumed"
+	auditActQueueWorkersSized = "queue_workers_resized"
+
+	auditActHostStale       = "host_stale"
+	auditActHostProvisioned = "host_provisioned"
+	auditActHostInstalled   = "host_installed"
+	auditActHostInstallFail = "host_install_failed"
+)
+
+// audit events are linked with the request which has caused them:
+type auditEvent struct {
+	id         string
+	request_id string
+	action     string
+	subject    string
+	created_at time.Time
+}
+
+func newAuditEvent(reqId, action, subject string) *auditEvent {
+	return &auditEvent{
+		id:         uuid.NewV4().String(),
+		request_id: reqId,
+		action:     action,
+		subject:    subject,
+		created_at: time.Now(),
+	}
+}
+
+func getAuditEventsByRequestId(rId string) ([]*auditEvent, *appError) {
+
+	var events []*auditEvent
+
+	rws, e := globSqlDB.Query("SELECT id,request_id,action,subject,created_at FROM events WHERE request_id = ? ORDER BY created_at", rId)
+	if e != nil {
+		return events, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var event = new(auditEvent)
+		var requestId sql.NullString
+
+		if e = rws.Scan(&event.id, &requestId, &event.action, &event.subject, &event.created_at); e != nil {
+			return events, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		event.request_id = requestId.String
+		events = append(events, event)
+	}
+
+	if rws.Err() != nil {
+		return events, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return events, nil
+}
+
+func (m *auditEvent) save() *appError {
+
+	_, e := globSqlDB.Exec("INSERT INTO events (id,request_id,action,subject) VALUES (?,?,?,?)",
+		m.id, getSqlString(m.request_id), m.action, m.subject)
+	if e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not save the audit event!")
+	}
+
+	globLogger.Info().Str("action", m.action).Str("subject", m.subject).Str("request_id", m.request_id).Msg("[AUDIT]: New audit event")
+	return nil
+}
+
+// events with deterministic ids are saved once, the result is false for duplicates:
+func (m *auditEvent) saveOnce() (bool, *appError) {
+
+	rs, e := globSqlDB.Exec("INSERT IGNORE INTO events (id,request_id,action,subject) VALUES (?,?,?,?)",
+		m.id, getSqlString(m.request_id), m.action, m.subject)
+	if e != nil {
+		return false, newAppError(errInternalSqlError).log(e, "Could not save the audit event!")
+	}
+
+	if saved, _ := rs.RowsAffected(); saved == 0 {
+		return false, nil
+	}
+
+	globLogger.Info().Str("action", m.action).Str("subject", m.subject).Str("request_id", m.request_id).Msg("[AUDIT]: New audit event")
+	return true, nil
+}
+
+// apiResource interface implementation:
+func (m *auditEvent) getResourceType() string { return apiTypeEvent }
+func (m *auditEvent) getResourceId() string   { return m.id }
+
+func (m *auditEvent) getResourceAttributes() interface{} {
+	return &attributesEvent{
+		Action:     m.action,
+		Subject:    m.subject,
+		Created_At: m.created_at.Format(time.RFC3339),
+	}
+}
+
+func (m *auditEvent) getResourceRelations() []*resourceRelation {
+	return []*resourceRelation{
+		{name: "request", loader: m.loadRequestResources, linkType: apiTypeRequest, linkId: m.request_id},
+	}
+}
+
+func (m *auditEvent) loadRequestResources() ([]apiResource, *appError) {
+
+	if m.request_id == "" {
+		return nil, nil
+	}
+
+	req, err := getRequestById(m.request_id)
+	if err != nil || req == nil {
+		return nil, err
+	}
+
+	return []apiResource{req}, nil
+}
@@ -0,0 +1,256 @@
+package server
+
+import "time"
+import "bytes"
+import "strconv"
+import "strings"
+import "net/http"
+import "crypto/hmac"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/hex"
+
+// Request signature scheme (v2):
+//
+//	Authorization: KS2-HMAC-SHA256 <key id>:<hex(hmac_sha256(key secret, string_to_sign))>
+//	X-Ks-Timestamp: <unix time in seconds>
+//	X-Ks-Nonce: <random string, 16-64 chars>
+//
+// string_to_sign is the list of lines joined with "\n":
+//
+//	KS2-HMAC-SHA256, METHOD, escaped path, sorted query, timestamp, nonce, hex(sha256(body))
+//
+// The key id could be omitted for the "default" key (Api.SignSecret).
+// The legacy scheme (hmac of the body only) is accepted while Api.Signature.AllowLegacy is set.
+const (
+	apiSignSchemeV2        = "KS2-HMAC-SHA256"
+	apiSignHeaderTimestamp = "X-Ks-Timestamp"
+	apiSignHeaderNonce     = "X-Ks-Nonce"
+
+	apiSignNonceMinLen = 16
+	apiSignNonceMaxLen = 64
+
+	// internal requests of cluster nodes:
+	apiClusterKeyId = "raft"
+)
+
+// Nonces are shared by all nodes in the api_nonces table for the whole timestamp window,
+// so every request could be sent only once per cluster. The expired nonce is taken again
+// and the row is updated, so MySQL returns 2 affected rows for it and 0 for the replay.
+func storeRequestNonce(nonce string, now time.Time) (bool, *appError) {
+
+	var expiresAt = now.Add(2 * globConfig.Base.Api.Signature.ClockSkew).Format("2006-01-02 15:04:05")
+
+	rs, e := globSqlDB.Exec(`INSERT INTO api_nonces (nonce,expires_at) VALUES (?,?)
+		ON DUPLICATE KEY UPDATE expires_at = IF(expires_at < ?, VALUES(expires_at), expires_at)`,
+		nonce, expiresAt, now.Format("2006-01-02 15:04:05"))
+	if e != nil {
+		return false, newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	affected, e := rs.RowsAffected()
+	if e != nil {
+		return false, newAppError(errInternalSqlError).log(e, "Could not get affected rows!")
+	}
+
+	return affected != 0, nil
+}
+
+func (m *apiController) verifyRequestSignature(r *http.Request, body []byte) (*apiKey, uint8) {
+
+	var authHeader = strings.SplitN(strings.TrimSpace(r.Header.Get("Authorization")), " ", 2)
+	if len(authHeader) != 2 || authHeader[1] == "" {
+		globLogger.Warn().Msg("[API]: The request has no valid Authorization header!")
+		return nil, errApiNotAuthorized
+	}
+
+	var keyId, signature = apiKeyDefaultId, strings.TrimSpace(authHeader[1])
+	if authHeader[0] == apiSignSchemeV2 {
+		if buf := strings.SplitN(signature, ":", 2); len(buf) == 2 {
+			keyId, signature = buf[0], buf[1]
+		}
+	}
+
+	receivedMAC, e := hex.DecodeString(signature)
+	if e != nil {
+		globLogger.Warn().Err(e).Msg("[API]: Could not decode the request signature!")
+		return nil, errApiNotAuthorized
+	}
+
+	key, err := getApiKey(keyId)
+	if err != nil {
+		return nil, err.code
+	}
+
+	if key == nil {
+		globLogger.Warn().Str("key_id", keyId).Msg("[API]: The request is signed with unknown key!")
+		return nil, errApiNotAuthorized
+	}
+
+	if authHeader[0] != apiSignSchemeV2 {
+		if !globConfig.Base.Api.Signature.AllowLegacy {
+			globLogger.Warn().Str("scheme", authHeader[0]).Msg("[API]: The legacy request signature is disabled!")
+			return nil, errApiNotAuthorized
+		}
+
+		if !isValidSignature(key.getValidSecrets(time.Now()), body, receivedMAC) {
+			return nil, errApiNotAuthorized
+		}
+
+		return key, errNotError
+	}
+
+	if errCode := verifySignedRequest(r, body, key.getValidSecrets(time.Now()), receivedMAC); errCode != errNotError {
+		return nil, errCode
+	}
+
+	return key, errNotError
+}
+
+// the v2 signature is checked with the timestamp window and the nonce:
+func verifySignedRequest(r *http.Request, body []byte, secrets []string, receivedMAC []byte) uint8 {
+
+	timestamp, e := strconv.ParseInt(r.Header.Get(apiSignHeaderTimestamp), 10, 64)
+	if e != nil {
+		globLogger.Warn().Err(e).Msg("[API]: Could not parse the request timestamp!")
+		return errApiNotAuthorized
+	}
+
+	var now = time.Now()
+	var skew = now.Sub(time.Unix(timestamp, 0))
+	if skew > globConfig.Base.Api.Signature.ClockSkew || -skew > globConfig.Base.Api.Signature.ClockSkew {
+		globLogger.Warn().Dur("skew", skew).Msg("[API]: The request timestamp is out of the allowed window!")
+		return errApiSignatureExpired
+	}
+
+	var nonce = r.Header.Get(apiSignHeaderNonce)
+	if len(nonce) < apiSignNonceMinLen || len(nonce) > apiSignNonceMaxLen {
+		globLogger.Warn().Int("nonce_length", len(nonce)).Msg("[API]: The request nonce has abnormal length!")
+		return errApiNotAuthorized
+	}
+
+	if !isValidSignature(secrets, getStringToSign(r, timestamp, nonce, body), receivedMAC) {
+		return errApiNotAuthorized
+	}
+
+	// the nonce is stored only for valid signatures, so nobody could "burn" it:
+	fresh, err := storeRequestNonce(nonce, now)
+	if err != nil {
+		return err.code
+	}
+
+	if !fresh {
+		globLogger.Warn().Str("nonce", nonce).Msg("[API]: The request nonce has been already used!")
+		return errApiRequestReplayed
+	}
+
+	return errNotError
+}
+
+// Cluster nodes sign internal requests with Raft.ForwardSecret by the v2 scheme.
+func verifyClusterSignature(r *http.Request, body []byte) uint8 {
+
+	if globConfig.Base.Raft.ForwardSecret == "" {
+		globLogger.Warn().Msg("[API]: The internal request is rejected, raft/forward_secret is not defined!")
+		return errApiNotAuthorized
+	}
+
+	var authHeader = strings.SplitN(strings.TrimSpace(r.Header.Get("Authorization")), " ", 2)
+	if len(authHeader) != 2 || authHeader[0] != apiSignSchemeV2 || !strings.HasPrefix(authHeader[1], apiClusterKeyId+":") {
+		globLogger.Warn().Msg("[API]: The internal request has no valid Authorization header!")
+		return errApiNotAuthorized
+	}
+
+	receivedMAC, e := hex.DecodeString(strings.TrimPrefix(authHeader[1], apiClusterKeyId+":"))
+	if e != nil {
+		globLogger.Warn().Err(e).Msg("[API]: Could not decode the internal request signature!")
+		return errApiNotAuthorized
+	}
+
+	return verifySignedRequest(r, body, []string{globConfig.Base.Raft.ForwardSecret}, receivedMAC)
+}
+
+// the request is signed by the v2 scheme with a random nonce:
+func signRequest(r *http.Request, keyId, secret string, body []byte) error {
+
+	var buf = make([]byte, apiSignNonceMinLen)
+	if _, e := rand.Read(buf); e != nil {
+		return e
+	}
+
+	var timestamp, nonce = time.Now().Unix(), hex.EncodeToString(buf)
+
+	var mac = hmac.New(sha256.New, []byte(secret))
+	mac.Write(getStringToSign(r, timestamp, nonce, body))
+
+	r.Header.Set(apiSignHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(apiSignHeaderNonce, nonce)
+	r.Header.Set("Authorization", apiSignSchemeV2+" "+keyId+":"+hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+func getStringToSign(r *http.Request, timestamp int64, nonce string, body []byte) []byte {
+
+	var bodyHash = sha256.Sum256(body)
+	var stringToSign bytes.Buffer
+
+	for _, v := range []string{
+		apiSignSchemeV2,
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.Query().Encode(),
+		strconv.FormatInt(timestamp, 10),
+		nonce,
+	} {
+		stringToSign.WriteString(v)
+		stringToSign.WriteByte('\n')
+	}
+	stringToSign.WriteString(hex.EncodeToString(bodyHash[:]))
+
+	return stringToSign.Bytes()
+}
+
+// Machine agents could be authenticated with the client certificate (mTLS) instead of the
+// request signature. The verified certificate CN is mapped to the api key in Api.ClientCerts,
+// so scopes and CIDRs of the key are applied as usual. nil key without error means that
+// the request must be signed.
+func (m *apiController) verifyClientCertificate(r *http.Request) (*apiKey, uint8) {
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, errNotError
+	}
+
+	// viper keys are case insensitive, so the map has lowercased keys:
+	var subject = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	keyId, ok := globConfig.Base.Api.ClientCerts[strings.ToLower(subject)]
+	if !ok {
+		return nil, errNotError
+	}
+
+	key, err := getApiKey(keyId)
+	if err != nil {
+		return nil, err.code
+	}
+
+	if key == nil {
+		globLogger.Warn().Str("subject", subject).Str("key_id", keyId).Msg("[API]: The client certificate is mapped to unknown key!")
+		return nil, errApiNotAuthorized
+	}
+
+	return key, errNotError
+}
+
+// during the key rotation the previous secret is valid too:
+func isValidSignature(secrets []string, payload, receivedMAC []byte) bool {
+
+	for _, v := range secrets {
+		mac := hmac.New(sha256.New, []byte(v))
+		mac.Write(payload)
+
+		if hmac.Equal(mac.Sum(nil), receivedMAC) {
+			return true
+		}
+	}
+
+	return false
+}
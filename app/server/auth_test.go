@@ -0,0 +1,50 @@
+package server
+
+import "testing"
+import "encoding/hex"
+import "net/http/httptest"
+
+// the known answers are computed outside of the package, so clients could check their implementations:
+const (
+	testSignString = "KS2-HMAC-SHA256\nPOST\n/v1/host/ipmi%20a\na=1&b=2\n1531312418\n0123456789abcdef\n" +
+		"7fb9d166d1a15bce0b9f085f3818946fd9297e4513a4a034a0ceb749292b4c0d"
+	testSignature = "e9f50063850fa90d3ce870152c517e90ff007463fba402a7e8b307c101554aa7"
+)
+
+func TestGetStringToSign(t *testing.T) {
+
+	var r = httptest.NewRequest("POST", "/v1/host/ipmi%20a?b=2&a=1", nil)
+
+	if buf := string(getStringToSign(r, 1531312418, "0123456789abcdef", []byte(`{"data":{}}`))); buf != testSignString {
+		t.Errorf("getStringToSign() = %q, want %q", buf, testSignString)
+	}
+}
+
+func TestIsValidSignature(t *testing.T) {
+
+	mac, e := hex.DecodeString(testSignature)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var tests = []struct {
+		name    string
+		secrets []string
+		payload string
+		valid   bool
+	}{
+		{"current secret", []string{"secret"}, testSignString, true},
+		{"previous secret", []string{"rotated", "secret"}, testSignString, true},
+		{"wrong secret", []string{"rotated"}, testSignString, false},
+		{"changed payload", []string{"secret"}, testSignString + " ", false},
+		{"no secrets", nil, testSignString, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if valid := isValidSignature(tt.secrets, []byte(tt.payload), mac); valid != tt.valid {
+				t.Errorf("isValidSignature() = %v, want %v", valid, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,516 @@
+package server
+
+import "sort"
+import "time"
+import "strings"
+import "encoding/json"
+import "github.com/satori/go.uuid"
+
+const (
+	raftBucketJobs      = "jobs"
+	raftBucketJobLeases = "job_leases"
+	raftBucketNodes     = "nodes"
+)
+
+// the leader assigns jobs and renews leases, every node claims its own leases:
+const jobLeaserInterval = time.Second
+
+type (
+	// job records are replicated through raft, so any node could run the job:
+	clusterJob struct {
+		Id           string          `json:"id"`
+		Requested_By string          `json:"requested_by"`
+		Action       uint8           `json:"action"`
+		Priority     uint8           `json:"priority"`
+		Payload      json.RawMessage `json:"payload"`
+		Created_At   time.Time       `json:"created_at"`
+	}
+	// node heartbeats are replicated next to the leases, so liveness doesn't depend on MySQL:
+	clusterNode struct {
+		Id           string    `json:"id"`
+		Heartbeat_At time.Time `json:"heartbeat_at"`
+	}
+	jobLease struct {
+		Id         string    `json:"id"`
+		Node       string    `json:"node"`
+		Expires_At time.Time `json:"expires_at"`
+	}
+
+	jobLeaser struct {
+		// job id => lease id of jobs which have been given to the local dispatcher:
+		claimed map[string]string
+		done    chan struct{}
+	}
+)
+
+func newJobLeaser() *jobLeaser {
+	return &jobLeaser{
+		claimed: make(map[string]string),
+		done:    make(chan struct{}, 1),
+	}
+}
+
+func (m *jobLeaser) run() {
+
+	var ticker = time.NewTicker(jobLeaserInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.heartbeat()
+
+			globQueue.applyControl(getQueueControl())
+
+			if globRaftStore.IsLeader() {
+				m.renewLeases()
+				m.assignJobs()
+			}
+
+			m.claimJobs()
+		}
+	}
+}
+
+func (m *jobLeaser) destruct() {
+	close(m.done)
+}
+
+// the node is removed after the queue drain, so the leader gives its leases away at once:
+func (m *jobLeaser) deregister() *appError {
+
+	if e := globRaftStore.Del(raftBucketNodes, globRaftStore.LocalId()); e != nil {
+		return newAppError(errInternalRaftError).log(e, "Could not delete the node heartbeat!")
+	}
+
+	return nil
+}
+
+// Nodes are alive while their heartbeats are fresh, the leader gives jobs only to alive nodes.
+// Every heartbeat is a raft commit (forwarded to the leader by followers), so the heartbeat
+// is renewed when a third of the lease TTL has passed only.
+func (m *jobLeaser) heartbeat() *appError {
+
+	var now = time.Now()
+	if node := getClusterNode(globRaftStore.LocalId()); node != nil && now.Sub(node.Heartbeat_At) < globConfig.Base.Queue.JobLeaseTTL/3 {
+		return nil
+	}
+
+	buf, e := json.Marshal(&clusterNode{
+		Id:           globRaftStore.LocalId(),
+		Heartbeat_At: now,
+	})
+	if e != nil {
+		return newAppError(errInternalCommonError).log(e, "Could not marshal the node heartbeat!")
+	}
+
+	if e = globRaftStore.Set(raftBucketNodes, globRaftStore.LocalId(), string(buf)); e != nil {
+		return newAppError(errInternalRaftError).log(e, "Could not replicate the node heartbeat!")
+	}
+
+	return nil
+}
+
+func getClusterNode(id string) *clusterNode {
+
+	var node *clusterNode
+	if buf := globRaftStore.Get(raftBucketNodes, id); buf != "" {
+		if e := json.Unmarshal([]byte(buf), &node); e != nil {
+			return nil
+		}
+	}
+
+	return node
+}
+
+func getAliveNodes() []string {
+	return filterAliveNodes(globRaftStore.List(raftBucketNodes), time.Now())
+}
+
+func filterAliveNodes(records map[string]string, now time.Time) []string {
+
+	var nodes []string
+	for id, buf := range records {
+
+		var node *clusterNode
+		if e := json.Unmarshal([]byte(buf), &node); e != nil || node == nil {
+			globLogger.Warn().Str("node", id).Msg("Could not unmarshal the node heartbeat!")
+			continue
+		}
+
+		if now.Sub(node.Heartbeat_At) < globConfig.Base.Queue.JobLeaseTTL {
+			nodes = append(nodes, id)
+		}
+	}
+
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Leader methods:
+// Leases of finished jobs are dropped with their job records. Leases of nodes which
+// have gone away are dropped too, so their jobs will be assigned again.
+func (m *jobLeaser) renewLeases() {
+
+	var leases = getJobLeases()
+	if len(leases) == 0 {
+		return
+	}
+
+	var nodes = getAliveNodes()
+
+	states, err := getJobLeaseStates(leases)
+	if err != nil {
+		return
+	}
+
+	var ttl = globConfig.Base.Queue.JobLeaseTTL
+	for jobId, lease := range leases {
+
+		state, ok := states[jobId]
+		if !ok || state.is_failed || state.state == jobStatusDone || state.state == jobStatusFailed || state.leaseId != lease.Id {
+			deleteJobLease(jobId, true)
+			continue
+		}
+
+		if !isNodeAlive(nodes, lease.Node) || time.Now().After(lease.Expires_At) {
+			globLogger.Warn().Str("job_id", jobId).Str("node", lease.Node).
+				Msg("The job lease has been expired, the job will be assigned to another node!")
+
+			deleteJobLease(jobId, false)
+			continue
+		}
+
+		if time.Until(lease.Expires_At) < ttl/2 {
+			lease.Expires_At = time.Now().Add(ttl)
+			setJobLease(jobId, lease)
+		}
+	}
+}
+
+// Unfinished jobs without leases are given to the least loaded alive node.
+// Jobs are assigned only when all of their dependencies are done, because
+// the dependencies could be run by another node.
+func (m *jobLeaser) assignJobs() {
+
+	jbs, deps, err := getUnfinishedJobStates()
+	if err != nil {
+		return
+	}
+
+	var nodes = getAliveNodes()
+	if len(nodes) == 0 {
+		return
+	}
+
+	// paused jobs are not assigned, so they are not leased by nodes meanwhile:
+	var ctl = getQueueControl()
+
+	var leases = getJobLeases()
+	var load = make(map[string]int)
+	for _, v := range leases {
+		load[v.Node]++
+	}
+
+	// only released jobs and jobs with failed dependencies are loaded with their payloads:
+	var ids []string
+	var failed = make(map[string]bool)
+
+	for _, jb := range jbs {
+		if _, ok := leases[jb.id]; ok || ctl.isActionPaused(jb.action) {
+			continue
+		}
+
+		switch getDependenciesState(jb, deps[jb.id]) {
+		case jobStatusFailed:
+			failed[jb.id] = true
+		case jobStatusBlocked:
+			continue
+		}
+
+		ids = append(ids, jb.id)
+	}
+
+	if jbs, err = getJobsByIds(ids); err != nil {
+		return
+	}
+
+	for _, jb := range jbs {
+		if len(jb.errors) != 0 {
+			globLogger.Error().Str("job_id", jb.id).Msg("The job has unsupported payload and could not be assigned!")
+
+			jb.setFailed()
+			for _, aErr := range jb.errors {
+				aErr.save()
+			}
+			continue
+		}
+
+		if failed[jb.id] {
+			jb.appendAppError(newAppError(errJobsDependencyFailed).log(nil, "Could not run the job because of the failed dependency!"))
+			continue
+		}
+
+		// the job could be partially done, but all job handlers are idempotent:
+		if jb.state == jobStatusPending {
+			globLogger.Warn().Str("job_id", jb.id).Str("job_state", jb.getHumanStateDetails()).
+				Msg("The job has been interrupted and will be retried!")
+
+			if err = jb.setInterrupted(); err != nil {
+				continue
+			}
+		}
+
+		var node = getLeastLoadedNode(nodes, load)
+		if err = jb.assign(node); err != nil {
+			continue
+		}
+
+		load[node]++
+	}
+}
+
+func (m *queueJob) assign(node string) *appError {
+
+	buf, err := m.payload.marshal()
+	if err != nil {
+		return err
+	}
+
+	record, e := json.Marshal(&clusterJob{
+		Id:           m.id,
+		Requested_By: m.requested_by,
+		Action:       m.action,
+		Priority:     m.priority,
+		Payload:      buf,
+		Created_At:   m.created_at,
+	})
+	if e != nil {
+		return newAppError(errInternalCommonError).log(e, "Could not marshal the job record!")
+	}
+
+	if e = globRaftStore.Set(raftBucketJobs, m.id, string(record)); e != nil {
+		return newAppError(errInternalRaftError).log(e, "Could not replicate the job record!")
+	}
+
+	var lease = &jobLease{
+		Id:         uuid.NewV4().String(),
+		Node:       node,
+		Expires_At: time.Now().Add(globConfig.Base.Queue.JobLeaseTTL),
+	}
+
+	// the lease id in DB marks the actual lease, old leases are dropped by the leader:
+	if _, e = globSqlDB.Exec("UPDATE jobs SET lease_id = ? WHERE id = ?", lease.Id, m.id); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	if err = setJobLease(m.id, lease); err != nil {
+		return err
+	}
+
+	globLogger.Debug().Str("job_id", m.id).Str("node", node).Msg("The job has been assigned")
+	return nil
+}
+
+// The job is released when all of its dependencies are done (jobStatusDone), failed
+// dependencies fail the job at once (jobStatusFailed), otherwise it's blocked:
+func getDependenciesState(jb *queueJob, deps []*queueJob) uint8 {
+
+	var state = jobStatusDone
+	for _, v := range deps {
+		if v.is_failed || v.state == jobStatusFailed {
+			globLogger.Warn().Str("job_id", jb.id).Str("dependency_id", v.id).Msg("The job dependency has failed!")
+			return jobStatusFailed
+		}
+
+		if v.state != jobStatusDone {
+			state = jobStatusBlocked
+		}
+	}
+
+	return state
+}
+
+func isNodeAlive(nodes []string, node string) bool {
+
+	for _, v := range nodes {
+		if v == node {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getLeastLoadedNode(nodes []string, load map[string]int) string {
+
+	var sorted = append([]string(nil), nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return load[sorted[i]] < load[sorted[j]]
+	})
+
+	return sorted[0]
+}
+
+type jobLeaseState struct {
+	state     uint8
+	is_failed bool
+	leaseId   string
+}
+
+func getJobLeaseStates(leases map[string]*jobLease) (map[string]*jobLeaseState, *appError) {
+
+	var states = make(map[string]*jobLeaseState)
+	var args []interface{}
+	for jobId := range leases {
+		args = append(args, jobId)
+	}
+
+	rws, e := globSqlDB.Query("SELECT id,state,is_failed,IFNULL(lease_id, '') FROM jobs WHERE id IN (?"+strings.Repeat(",?", len(args)-1)+")", args...)
+	if e != nil {
+		return states, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var id string
+		var state = new(jobLeaseState)
+		if e = rws.Scan(&id, &state.state, &state.is_failed, &state.leaseId); e != nil {
+			return states, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		states[id] = state
+	}
+
+	if rws.Err() != nil {
+		return states, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return states, nil
+}
+
+func setJobLease(jobId string, lease *jobLease) *appError {
+
+	buf, e := json.Marshal(lease)
+	if e != nil {
+		return newAppError(errInternalCommonError).log(e, "Could not marshal the job lease!")
+	}
+
+	if e = globRaftStore.Set(raftBucketJobLeases, jobId, string(buf)); e != nil {
+		return newAppError(errInternalRaftError).log(e, "Could not replicate the job lease!")
+	}
+
+	return nil
+}
+
+func deleteJobLease(jobId string, withRecord bool) *appError {
+
+	if e := globRaftStore.Del(raftBucketJobLeases, jobId); e != nil {
+		return newAppError(errInternalRaftError).log(e, "Could not delete the job lease!")
+	}
+
+	if !withRecord {
+		return nil
+	}
+
+	if e := globRaftStore.Del(raftBucketJobs, jobId); e != nil {
+		return newAppError(errInternalRaftError).log(e, "Could not delete the job record!")
+	}
+
+	return nil
+}
+
+// Node methods:
+func getJobLeases() map[string]*jobLease {
+
+	var leases = make(map[string]*jobLease)
+	for jobId, buf := range globRaftStore.List(raftBucketJobLeases) {
+
+		var lease *jobLease
+		if e := json.Unmarshal([]byte(buf), &lease); e != nil || lease == nil {
+			globLogger.Warn().Str("job_id", jobId).Msg("Could not unmarshal the job lease!")
+			continue
+		}
+
+		leases[jobId] = lease
+	}
+
+	return leases
+}
+
+func getJobLease(jobId string) *jobLease {
+
+	var lease *jobLease
+	if buf := globRaftStore.Get(raftBucketJobLeases, jobId); buf != "" {
+		if e := json.Unmarshal([]byte(buf), &lease); e != nil {
+			return nil
+		}
+	}
+
+	return lease
+}
+
+// The local node runs only jobs which have been leased to it. Every lease is
+// claimed once, expired leases are forgotten and could be given to another node.
+func (m *jobLeaser) claimJobs() {
+
+	var leases = getJobLeases()
+	for jobId, leaseId := range m.claimed {
+		if lease, ok := leases[jobId]; !ok || lease.Id != leaseId {
+			delete(m.claimed, jobId)
+		}
+	}
+
+	for jobId, lease := range leases {
+		if lease.Node != globRaftStore.LocalId() || time.Now().After(lease.Expires_At) {
+			continue
+		}
+
+		if _, ok := m.claimed[jobId]; ok {
+			continue
+		}
+
+		jb, err := getClusterJob(jobId)
+		if err != nil {
+			continue
+		}
+
+		jb.lease_id = lease.Id
+		m.claimed[jobId] = lease.Id
+
+		go jb.addToQueue()
+	}
+}
+
+func getClusterJob(jobId string) (*queueJob, *appError) {
+
+	var record *clusterJob
+	if e := json.Unmarshal([]byte(globRaftStore.Get(raftBucketJobs, jobId)), &record); e != nil || record == nil {
+		return nil, newAppError(errInternalRaftError).log(e, "Could not unmarshal the replicated job record!")
+	}
+
+	payload, err := parseJobPayload(record.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &queueJob{
+		id:           record.Id,
+		requested_by: record.Requested_By,
+		action:       record.Action,
+		priority:     record.Priority,
+		payload:      payload,
+		state:        jobStatusCreated,
+		created_at:   record.Created_At,
+	}, nil
+}
+
+// workers check the lease before every run, because it could be expired in the queue:
+func (m *queueJob) hasLease() bool {
+
+	var lease = getJobLease(m.id)
+	return lease != nil && lease.Id == m.lease_id && lease.Node == globRaftStore.LocalId() && time.Now().Before(lease.Expires_At)
+}
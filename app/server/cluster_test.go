@@ -0,0 +1,88 @@
+package server
+
+import "time"
+import "strings"
+import "testing"
+import "encoding/json"
+
+func TestFilterAliveNodes(t *testing.T) {
+
+	var now = time.Now()
+	var records = make(map[string]string)
+
+	for id, age := range map[string]time.Duration{
+		"node1": time.Second,
+		"node2": globConfig.Base.Queue.JobLeaseTTL + time.Second,
+		"node3": globConfig.Base.Queue.JobLeaseTTL / 2,
+	} {
+		buf, _ := json.Marshal(&clusterNode{Id: id, Heartbeat_At: now.Add(-age)})
+		records[id] = string(buf)
+	}
+	records["node4"] = "broken"
+
+	var nodes = filterAliveNodes(records, now)
+	if len(nodes) != 2 || nodes[0] != "node1" || nodes[1] != "node3" {
+		t.Errorf("filterAliveNodes() = %v, want [node1 node3]", nodes)
+	}
+}
+
+func TestGetLeastLoadedNode(t *testing.T) {
+
+	var nodes = []string{"node1", "node2", "node3"}
+
+	if node := getLeastLoadedNode(nodes, map[string]int{"node1": 2, "node2": 1, "node3": 1}); node != "node2" {
+		t.Errorf("getLeastLoadedNode() = %s, want node2", node)
+	}
+
+	if node := getLeastLoadedNode(nodes, nil); node != "node1" {
+		t.Errorf("getLeastLoadedNode() = %s, want node1", node)
+	}
+
+	if nodes[0] != "node1" {
+		t.Errorf("getLeastLoadedNode() must not sort the given nodes")
+	}
+}
+
+func TestGetDependenciesState(t *testing.T) {
+
+	var done = &queueJob{id: "done", state: jobStatusDone}
+	var running = &queueJob{id: "running", state: jobStatusPending}
+	var failed = &queueJob{id: "failed", state: jobStatusFailed}
+	var retried = &queueJob{id: "retried", state: jobStatusCreated, is_failed: true}
+
+	var tests = []struct {
+		name  string
+		deps  []*queueJob
+		state uint8
+	}{
+		{"without dependencies", nil, jobStatusDone},
+		{"done", []*queueJob{done, done}, jobStatusDone},
+		{"running", []*queueJob{done, running}, jobStatusBlocked},
+		{"failed after running", []*queueJob{running, failed}, jobStatusFailed},
+		{"marked as failed", []*queueJob{done, retried}, jobStatusFailed},
+	}
+
+	for _, tt := range tests {
+		if state := getDependenciesState(&queueJob{id: "job"}, tt.deps); state != tt.state {
+			t.Errorf("%s: getDependenciesState() = %d, want %d", tt.name, state, tt.state)
+		}
+	}
+}
+
+// the leader loads the job states every second, so the payloads are loaded for the assigned jobs only:
+func TestGetUnfinishedJobStates(t *testing.T) {
+
+	var db = newSqlStubDB(t)
+
+	if _, _, err := getUnfinishedJobStates(); err != nil {
+		t.Fatalf("getUnfinishedJobStates() has failed with the code %d", err.code)
+	}
+
+	if jbs, err := getJobsByIds(nil); err != nil || len(jbs) != 0 {
+		t.Fatal("getJobsByIds() must not query DB without ids")
+	}
+
+	if len(db.queries) != 1 || strings.Contains(db.queries[0], "payload") || !strings.Contains(db.queries[0], "JOIN job_dependencies") {
+		t.Errorf("the job states have been loaded by %d queries: %q", len(db.queries), db.queries)
+	}
+}
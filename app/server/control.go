@@ -0,0 +1,178 @@
+package server
+
+import "sort"
+import "sync"
+import "encoding/json"
+
+const (
+	queueControlBucket = "queue_control"
+	queueControlKey    = "state"
+
+	// the worker pool could not be resized beyond the limit:
+	queueMaxWorkers = 256
+)
+
+// The queue control state is replicated through the raft store, so the dispatching could be
+// paused on the whole cluster. The worker pool is resized for every node separately.
+type queueControl struct {
+	Paused         bool           `json:"paused"`
+	Paused_Actions []string       `json:"paused_actions"`
+	Workers        map[string]int `json:"workers"`
+}
+
+func getQueueControl() *queueControl {
+
+	var ctl = &queueControl{
+		Workers: make(map[string]int),
+	}
+
+	if buf := globRaftStore.Get(queueControlBucket, queueControlKey); buf != "" {
+		if e := json.Unmarshal([]byte(buf), ctl); e != nil {
+			globLogger.Warn().Err(e).Msg("Could not unmarshal the queue control state, the defaults are used!")
+			return &queueControl{Workers: make(map[string]int)}
+		}
+	}
+
+	if ctl.Workers == nil {
+		ctl.Workers = make(map[string]int)
+	}
+
+	return ctl
+}
+
+func (m *queueControl) save() *appError {
+
+	sort.Strings(m.Paused_Actions)
+
+	buf, e := json.Marshal(m)
+	if e != nil {
+		return newAppError(errInternalCommonError).log(e, "Could not marshal the queue control state!")
+	}
+
+	if e = globRaftStore.Set(queueControlBucket, queueControlKey, string(buf)); e != nil {
+		return newAppError(errInternalRaftError).log(e, "Could not save the queue control state in the store!")
+	}
+
+	// the local dispatcher gets the state at once, other nodes get it with the next leaser tick:
+	globQueue.applyControl(m)
+	return nil
+}
+
+func (m *queueControl) isActionPaused(action uint8) bool {
+
+	if m.Paused {
+		return true
+	}
+
+	var name = getJobHandlerName(action)
+	for _, v := range m.Paused_Actions {
+		if v == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *queueControl) setActionPaused(name string, paused bool) {
+
+	var actions []string
+	for _, v := range m.Paused_Actions {
+		if v != name {
+			actions = append(actions, v)
+		}
+	}
+
+	if paused {
+		actions = append(actions, name)
+	}
+
+	m.Paused_Actions = actions
+}
+
+// nodes without the override have Queue.Workers from the configuration:
+func (m *queueControl) getWorkers(node string) int {
+
+	if workers, ok := m.Workers[node]; ok {
+		return workers
+	}
+
+	return globConfig.Base.Queue.Workers
+}
+
+func (m *queueControl) isEqual(ctl *queueControl) bool {
+
+	if ctl == nil {
+		return false
+	}
+
+	a, _ := json.Marshal(m)
+	b, _ := json.Marshal(ctl)
+	return string(a) == string(b)
+}
+
+// apiResource interface implementation:
+func (m *queueControl) getResourceType() string { return apiTypeQueueControl }
+func (m *queueControl) getResourceId() string   { return "cluster" }
+
+func (m *queueControl) getResourceAttributes() interface{} {
+
+	var attrs = &attributesQueueControl{
+		Paused:         m.Paused,
+		Paused_Actions: m.Paused_Actions,
+		Workers:        make(map[string]int),
+	}
+
+	for _, node := range getQueueControlNodes() {
+		attrs.Workers[node] = m.getWorkers(node)
+	}
+
+	return attrs
+}
+
+func (m *queueControl) getResourceRelations() []*resourceRelation { return nil }
+
+// raft nodes are taken from the configuration, the local node has an empty id there:
+func getQueueControlNodes() []string {
+
+	var nodes []string
+	for id := range globConfig.Base.Raft.Nodes {
+		if id == "" {
+			id = globRaftStore.LocalId()
+		}
+		nodes = append(nodes, id)
+	}
+
+	if len(nodes) == 0 {
+		nodes = append(nodes, globRaftStore.LocalId())
+	}
+
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Queue metrics:
+// state changes are counted by the node which has made them
+var queueControlChanges = struct {
+	sync.Mutex
+	m map[string]int
+}{m: make(map[string]int)}
+
+func countQueueControlChange(change string) {
+	queueControlChanges.Lock()
+	defer queueControlChanges.Unlock()
+	queueControlChanges.m[change]++
+}
+
+func getQueueControlChanges() map[string]int {
+
+	queueControlChanges.Lock()
+	defer queueControlChanges.Unlock()
+
+	var changes = make(map[string]int)
+	for k, v := range queueControlChanges.m {
+		changes[k] = v
+	}
+
+	return changes
+}
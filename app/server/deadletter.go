@@ -0,0 +1,198 @@
+package server
+
+import "time"
+import "strings"
+import "encoding/json"
+
+type (
+	// failed jobs are kept in the dead-letter table until they are requeued:
+	deadJob struct {
+		job_id     string
+		action     uint8
+		error_code uint8
+		payload    []byte
+		attempts   []*jobAttempt
+		failed_at  time.Time
+	}
+	jobAttempt struct {
+		Error_Id   string    `json:"error_id"`
+		Code       uint8     `json:"code"`
+		Title      string    `json:"title"`
+		Started_At time.Time `json:"started_at"`
+		Failed_At  time.Time `json:"failed_at"`
+	}
+	deadJobFilter struct {
+		action, code  *uint8
+		job_id        string
+		limit, offset int
+	}
+)
+
+func newJobAttempt(startedAt time.Time, aErr *appError) *jobAttempt {
+
+	var now = time.Now()
+	if startedAt.IsZero() {
+		startedAt = now
+	}
+
+	return &jobAttempt{
+		Error_Id:   aErr.id,
+		Code:       aErr.code,
+		Title:      aErr.getErrorTitle(),
+		Started_At: startedAt,
+		Failed_At:  now,
+	}
+}
+
+func newDeadJobFilter() *deadJobFilter {
+	return &deadJobFilter{
+		limit: 100,
+	}
+}
+
+func getDeadJobsByFilter(f *deadJobFilter) ([]*deadJob, *appError) {
+
+	var djs []*deadJob
+	var where, args = []string{"requeued_at IS NULL"}, []interface{}{}
+
+	if f.action != nil {
+		where, args = append(where, "action = ?"), append(args, *f.action)
+	}
+	if f.code != nil {
+		where, args = append(where, "error_code = ?"), append(args, *f.code)
+	}
+	if f.job_id != "" {
+		where, args = append(where, "job_id = ?"), append(args, f.job_id)
+	}
+
+	var query = "SELECT job_id,action,error_code,payload,attempts,failed_at FROM dead_jobs WHERE " + strings.Join(where, " AND ")
+	query += " ORDER BY failed_at DESC LIMIT ? OFFSET ?"
+	args = append(args, f.limit, f.offset)
+
+	rws, e := globSqlDB.Query(query, args...)
+	if e != nil {
+		return djs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var dj = new(deadJob)
+		var attempts []byte
+
+		if e = rws.Scan(&dj.job_id, &dj.action, &dj.error_code, &dj.payload, &attempts, &dj.failed_at); e != nil {
+			return djs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		if e = json.Unmarshal(attempts, &dj.attempts); e != nil {
+			return djs, newAppError(errInternalCommonError).log(e, "Could not unmarshal the job attempts!")
+		}
+
+		djs = append(djs, dj)
+	}
+
+	if rws.Err() != nil {
+		return djs, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return djs, nil
+}
+
+// Matched jobs are reset and assigned again by the raft leader. Dependent jobs which have
+// been dead-lettered together wait for their dependencies instead of failing again.
+func requeueDeadJobs(f *deadJobFilter, reqId string) ([]*deadJob, *appError) {
+
+	djs, err := getDeadJobsByFilter(f)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range djs {
+
+		jb, err := getJobById(v.job_id)
+		if err != nil {
+			return nil, err
+		}
+
+		if jb.payload, err = parseJobPayload(v.payload); err != nil {
+			return nil, err.setJobId(jb.id)
+		}
+
+		// the lease is dropped, so the raft leader will assign the job again with the full retry policy:
+		if _, e := globSqlDB.Exec("UPDATE jobs SET state = ?, is_failed = 0, fails = 0, lease_id = NULL WHERE id = ?", jobStatusCreated, jb.id); e != nil {
+			return nil, newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+		}
+
+		if _, e := globSqlDB.Exec("UPDATE dead_jobs SET requeued_at = CURRENT_TIMESTAMP, requeued_by = ? WHERE job_id = ?", reqId, jb.id); e != nil {
+			return nil, newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+		}
+
+		if err = newAuditEvent(reqId, auditActJobRequeued, jb.id).save(); err != nil {
+			return nil, err
+		}
+
+		var from = jb.state
+		if err = jb.saveTransition(&from, jobStatusCreated); err != nil {
+			return nil, err
+		}
+
+		jb.state, jb.is_failed = jobStatusCreated, false
+	}
+
+	return djs, nil
+}
+
+func (m *queueJob) saveDeadLetter(aErr *appError) *appError {
+
+	payload, err := m.payload.marshal()
+	if err != nil {
+		return err
+	}
+
+	attempts, e := json.Marshal(m.attempts)
+	if e != nil {
+		return newAppError(errInternalCommonError).log(e, "Could not marshal the job attempts!")
+	}
+
+	// the job could be dead-lettered again after the requeue:
+	_, e = globSqlDB.Exec(`INSERT INTO dead_jobs (job_id,action,error_code,payload,attempts) VALUES (?,?,?,?,?)
+		ON DUPLICATE KEY UPDATE error_code = VALUES(error_code), payload = VALUES(payload), attempts = VALUES(attempts),
+		failed_at = CURRENT_TIMESTAMP, requeued_at = NULL, requeued_by = NULL`,
+		m.id, m.action, aErr.code, payload, attempts)
+	if e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not save the job in the dead-letter queue!")
+	}
+
+	return nil
+}
+
+// apiResource interface implementation:
+func (m *deadJob) getResourceType() string { return apiTypeDeadJob }
+func (m *deadJob) getResourceId() string   { return m.job_id }
+
+func (m *deadJob) getResourceAttributes() interface{} {
+	return &attributesDeadJob{
+		Action:      jobActHumanDetail[m.action],
+		Error_Code:  m.error_code,
+		Error_Title: apiErrorsTitle[m.error_code],
+		Payload:     json.RawMessage(m.payload),
+		Attempts:    m.attempts,
+		Failed_At:   m.failed_at.Format(time.RFC3339),
+	}
+}
+
+func (m *deadJob) getResourceRelations() []*resourceRelation {
+	return []*resourceRelation{
+		{name: "job", loader: m.loadJobResources, linkType: apiTypeJob, linkId: m.job_id},
+	}
+}
+
+func (m *deadJob) loadJobResources() ([]apiResource, *appError) {
+
+	jb, err := getJobById(m.job_id)
+	if err != nil {
+		return nil, err
+	}
+
+	return []apiResource{jb}, nil
+}
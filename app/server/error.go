@@ -35,6 +35,36 @@ const (
 	errRsviewLLDPMismatch
 	errRsviewMacNotFound
 	errHostsNotFound
+	errApiUnknownInclude
+	errApiUnsupportedMedia
+	errApiNotAcceptable
+	errApiInvalidFilter
+	errApiSignatureExpired
+	errApiRequestReplayed
+	errApiScopeForbidden
+	errApiAddressForbidden
+	errInternalRaftError
+	errApiKeyInvalid
+	errApiKeyExists
+	errApiKeyReadOnly
+	errApiNotLeader
+	errApiKeyNotFound
+	errTasksTokenInvalid
+	errTasksNotFound
+	errTasksStatusInvalid
+	errJobsPayloadInvalid
+	errJobsDependencyFailed
+	errJobsUnknownAction
+	errJobsInvalidPriority
+	errJobsTimedOut
+	errJobsStuck
+	errQueueControlInvalid
+	errIcqSendFailed
+	errNotifySendFailed
+	errNotifyUnknownChannel
+	errHostsPtrNotMatched
+	errHostsNamingMismatch
+	errHostsUnreachable
 )
 
 var (
@@ -71,6 +101,36 @@ var (
 		errRsviewLLDPMismatch:     "Rsview comparison failure",
 		errRsviewMacNotFound:      "Rsview parse generic error",
 		errHostsNotFound:          "Unknown host",
+		errApiUnknownInclude:      "Unknown relationship path",
+		errApiUnsupportedMedia:    "Unsupported media type",
+		errApiNotAcceptable:       "Not acceptable",
+		errApiInvalidFilter:       "Invalid filter",
+		errApiSignatureExpired:    "Authorization failed",
+		errApiRequestReplayed:     "Authorization failed",
+		errApiScopeForbidden:      "Insufficient key scope",
+		errApiAddressForbidden:    "Forbidden source address",
+		errInternalRaftError:      "Internal cluster error",
+		errApiKeyInvalid:          "Invalid API key attributes",
+		errApiKeyExists:           "API key already exists",
+		errApiKeyReadOnly:         "API key is read-only",
+		errApiNotLeader:           "Not a cluster leader",
+		errApiKeyNotFound:         "API key not found",
+		errTasksTokenInvalid:      "Invalid enrollment token",
+		errTasksNotFound:          "Install task not found",
+		errTasksStatusInvalid:     "Invalid install status",
+		errJobsPayloadInvalid:     "Invalid job payload",
+		errJobsDependencyFailed:   "Job dependency failed",
+		errJobsUnknownAction:      "Unknown job action",
+		errJobsInvalidPriority:    "Invalid job priority",
+		errJobsTimedOut:           "Job timed out",
+		errJobsStuck:              "Job is stuck",
+		errQueueControlInvalid:    "Invalid queue control",
+		errIcqSendFailed:          "ICQ message sending failed",
+		errNotifySendFailed:       "Notification sending failed",
+		errNotifyUnknownChannel:   "Unknown notification channel",
+		errHostsPtrNotMatched:     "No matched PTR record",
+		errHostsNamingMismatch:    "Hostname naming mismatch",
+		errHostsUnreachable:       "Host is unreachable",
 	}
 	apiErrorsDetail = map[uint8]string{
 		errNotError:               "",
@@ -98,6 +158,36 @@ var (
 		errRsviewLLDPMismatch:     "The job failed because of a failure to compare the lldp and ipmi hostname!",
 		errRsviewMacNotFound:      "The requested MAC address was not found in the database!",
 		errHostsNotFound:          "The requested Host was not found in the database!",
+		errApiUnknownInclude:      "The requested relationship path could not be included! Check the \"include\" parameter and try again.",
+		errApiUnsupportedMedia:    "The request Content-Type is not supported! Use \"application/vnd.api+json\" or \"application/json\".",
+		errApiNotAcceptable:       "The requested Accept media types are not supported! Use \"application/vnd.api+json\" or \"application/json\".",
+		errApiInvalidFilter:       "Could not parse the request filters or pagination! Please read the documentation and try again!",
+		errApiSignatureExpired:    "The request timestamp is out of the allowed window! Check the clock of your system and try again.",
+		errApiRequestReplayed:     "The request nonce has been already used! Every signed request must have a unique nonce.",
+		errApiScopeForbidden:      "The key which has signed the request does not have the required scope!",
+		errApiAddressForbidden:    "The key which has signed the request is not allowed from this source address!",
+		errInternalRaftError:      "The current request could not processed due to a cluster store error. Please, try again later.",
+		errApiKeyInvalid:          "The given API key id, scopes or CIDRs are not valid! Check the request and try again.",
+		errApiKeyExists:           "The API key with the given id already exists! Choose another id or rotate the existing key.",
+		errApiKeyReadOnly:         "The default API key is defined in the configuration file and could not be changed through the API!",
+		errApiNotLeader:           "The cluster store could be changed on the cluster leader only! Send the request to the leader node.",
+		errApiKeyNotFound:         "Could not find the API key with the given id!",
+		errTasksTokenInvalid:      "The enrollment token is unknown, expired or belongs to another host! The host must be installed with a new kickstart.",
+		errTasksNotFound:          "The host has no active install task! The install task is created by the rsview_parse job.",
+		errTasksStatusInvalid:     "The given install status is unknown! Use started, done or failed.",
+		errJobsPayloadInvalid:     "The job payload could not be restored. The job should be created again.",
+		errJobsDependencyFailed:   "The job could not be run because one of the jobs it depends on has failed!",
+		errJobsUnknownAction:      "The job action has no registered handler!",
+		errJobsInvalidPriority:    "The given job priority is unknown! Use low, normal or high.",
+		errJobsTimedOut:           "The job has been cancelled because it has exceeded the execution timeout!",
+		errJobsStuck:              "The job has not been finished in time and has been marked as timed out by the watchdog!",
+		errQueueControlInvalid:    "The given queue control parameters are invalid! Check the action name and the workers count.",
+		errIcqSendFailed:          "The ICQ bot API has not accepted the message!",
+		errNotifySendFailed:       "The notification channel has not accepted the message!",
+		errNotifyUnknownChannel:   "The notification channel is not defined in the configuration file!",
+		errHostsPtrNotMatched:     "The PTR records of the given ip address are not accepted by the resolver policy! Check the job resolution and fix DNS records.",
+		errHostsNamingMismatch:    "The resolved hostname does not match any project naming regexp (base/puppet/projects)! Check the job resolution and fix DNS records.",
+		errHostsUnreachable:       "Some hosts have not answered on the IPMI port (base/ipmi/ping)! Check the job logs and the hosts BMC.",
 	}
 	apiErrorsStatus = map[uint8]int{ // TODO: try to use 4XX instead of 5XX
 		errNotError:               http.StatusOK,
@@ -125,6 +215,51 @@ var (
 		errRsviewLLDPMismatch:     http.StatusInternalServerError,
 		errRsviewMacNotFound:      http.StatusNotFound,
 		errHostsNotFound:          http.StatusNotFound,
+		errApiUnknownInclude:      http.StatusBadRequest,
+		errApiUnsupportedMedia:    http.StatusUnsupportedMediaType,
+		errApiNotAcceptable:       http.StatusNotAcceptable,
+		errApiInvalidFilter:       http.StatusBadRequest,
+		errApiSignatureExpired:    http.StatusUnauthorized,
+		errApiRequestReplayed:     http.StatusUnauthorized,
+		errApiScopeForbidden:      http.StatusForbidden,
+		errApiAddressForbidden:    http.StatusForbidden,
+		errInternalRaftError:      http.StatusInternalServerError,
+		errApiKeyInvalid:          http.StatusBadRequest,
+		errApiKeyExists:           http.StatusConflict,
+		errApiKeyReadOnly:         http.StatusForbidden,
+		errApiNotLeader:           http.StatusMisdirectedRequest,
+		errApiKeyNotFound:         http.StatusNotFound,
+		errTasksTokenInvalid:      http.StatusUnauthorized,
+		errTasksNotFound:          http.StatusNotFound,
+		errTasksStatusInvalid:     http.StatusBadRequest,
+		errJobsPayloadInvalid:     http.StatusInternalServerError,
+		errJobsDependencyFailed:   http.StatusFailedDependency,
+		errJobsUnknownAction:      http.StatusInternalServerError,
+		errJobsInvalidPriority:    http.StatusBadRequest,
+		errJobsTimedOut:           http.StatusGatewayTimeout,
+		errJobsStuck:              http.StatusGatewayTimeout,
+		errQueueControlInvalid:    http.StatusBadRequest,
+		errIcqSendFailed:          http.StatusBadGateway,
+		errNotifySendFailed:       http.StatusBadGateway,
+		errNotifyUnknownChannel:   http.StatusInternalServerError,
+		errHostsPtrNotMatched:     http.StatusBadRequest,
+		errHostsNamingMismatch:    http.StatusBadRequest,
+		errHostsUnreachable:       http.StatusBadGateway,
+	}
+
+	// job errors which could disappear on the next attempt (timeouts, deadlocks, etc.);
+	// all other errors fail the job immediately:
+	apiErrorsRetryable = map[uint8]bool{
+		errInternalCommonError: true,
+		errInternalSqlError:    true,
+		errRsviewGenericError:  true,
+		errRsviewAuthError:     true,
+		errRsviewAuthTestFail:  true,
+		errInternalRaftError:   true,
+		errJobsTimedOut:        true,
+		errIcqSendFailed:       true,
+		errNotifySendFailed:    true,
+		errHostsUnreachable:    true,
 	}
 )
 
@@ -140,6 +275,10 @@ type appError struct {
 	prefix    string
 	jobId     string
 	requestId string
+
+	// JSON:API error source (pointer to the request document or query parameter):
+	srcPointer string
+	srcParam   string
 }
 
 func newAppError(e uint8) *appError {
@@ -162,6 +301,45 @@ func newAppError(e uint8) *appError {
 	}
 }
 
+func getAppErrorsByJobId(jId string) ([]*appError, *appError) {
+	return getAppErrorsByQuery("SELECT id,job_id,request_id,internal_code,source_pointer,source_parameter FROM errors WHERE job_id = ?", jId)
+}
+
+func getAppErrorsByRequestId(rId string) ([]*appError, *appError) {
+	return getAppErrorsByQuery("SELECT id,job_id,request_id,internal_code,source_pointer,source_parameter FROM errors WHERE request_id = ?", rId)
+}
+
+func getAppErrorsByQuery(query string, args ...interface{}) ([]*appError, *appError) {
+
+	var aErrs []*appError
+
+	rws, e := globSqlDB.Query(query, args...)
+	if e != nil {
+		return aErrs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var aErr = new(appError)
+		var jobId, requestId, srcPointer, srcParam sql.NullString
+
+		if e = rws.Scan(&aErr.id, &jobId, &requestId, &aErr.code, &srcPointer, &srcParam); e != nil {
+			return aErrs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		aErr.jobId, aErr.requestId = jobId.String, requestId.String
+		aErr.srcPointer, aErr.srcParam = srcPointer.String, srcParam.String
+		aErrs = append(aErrs, aErr)
+	}
+
+	if rws.Err() != nil {
+		return aErrs, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return aErrs, nil
+}
+
 func (m *appError) setJobId(jId string) *appError {
 	m.jobId = jId
 	return m
@@ -172,11 +350,14 @@ func (m *appError) setRequestId(rId string) *appError {
 	return m
 }
 
+func (m *appError) setPointer(p string) *appError   { m.srcPointer = p; return m }
+func (m *appError) setParameter(p string) *appError { m.srcParam = p; return m }
+
 func (m *appError) save() bool {
 
 	_, e := globSqlDB.Exec(
-		"INSERT INTO errors (id,job_id,request_id,internal_code,displayed_title,displayed_detail) VALUES (?,?,?,?,?,?)",
-		m.id, getSqlString(m.jobId), getSqlString(m.requestId), m.code, m.getErrorTitle(), m.getHumanDetails())
+		"INSERT INTO errors (id,job_id,request_id,internal_code,source_pointer,source_parameter,displayed_title,displayed_detail) VALUES (?,?,?,?,?,?,?,?)",
+		m.id, getSqlString(m.jobId), getSqlString(m.requestId), m.code, getSqlString(m.srcPointer), getSqlString(m.srcParam), m.getErrorTitle(), m.getHumanDetails())
 
 	if e != nil {
 		globLogger.Error().Err(e).Uint8("errCode", m.code).Str("errTitle", m.getErrorTitle()).Msg("Could not save the error!!!")
@@ -206,10 +387,26 @@ func (m *appError) log(e error, msg string, ctx ...zerolog.Context) *appError {
 	return m
 }
 
+func (m *appError) getResponseSource() *errorSource {
+
+	if m.srcPointer == "" && m.srcParam == "" {
+		return nil
+	}
+
+	return &errorSource{
+		Pointer:   m.srcPointer,
+		Parameter: m.srcParam,
+	}
+}
+
 func (m *appError) getHttpStatusCode() int {
 	return apiErrorsStatus[m.code]
 }
 
+func (m *appError) isRetryable() bool {
+	return apiErrorsRetryable[m.code]
+}
+
 func (m *appError) getErrorTitle() string {
 	return apiErrorsTitle[m.code]
 }
@@ -218,6 +415,54 @@ func (m *appError) getHumanDetails() string {
 	return apiErrorsDetail[m.code]
 }
 
+// apiResource interface implementation:
+func (m *appError) getResourceType() string { return apiTypeError }
+func (m *appError) getResourceId() string   { return m.id }
+
+func (m *appError) getResourceAttributes() interface{} {
+	return &attributesError{
+		Code:   m.code,
+		Title:  m.getErrorTitle(),
+		Detail: m.getHumanDetails(),
+		Source: m.getResponseSource(),
+	}
+}
+
+func (m *appError) getResourceRelations() []*resourceRelation {
+	return []*resourceRelation{
+		{name: "job", loader: m.loadJobResources, linkType: apiTypeJob, linkId: m.jobId},
+		{name: "request", loader: m.loadRequestResources, linkType: apiTypeRequest, linkId: m.requestId},
+	}
+}
+
+func (m *appError) loadJobResources() ([]apiResource, *appError) {
+
+	if m.jobId == "" {
+		return nil, nil
+	}
+
+	jb, err := getJobById(m.jobId)
+	if err != nil {
+		return nil, err
+	}
+
+	return []apiResource{jb}, nil
+}
+
+func (m *appError) loadRequestResources() ([]apiResource, *appError) {
+
+	if m.requestId == "" {
+		return nil, nil
+	}
+
+	req, err := getRequestById(m.requestId)
+	if err != nil || req == nil {
+		return nil, err
+	}
+
+	return []apiResource{req}, nil
+}
+
 func newApiError2(e uint8) *apiError {
 	return &apiError{
 		e: e,
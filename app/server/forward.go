@@ -0,0 +1,92 @@
+package server
+
+import "io"
+import "bytes"
+import "errors"
+import "strings"
+import "net/http"
+import "io/ioutil"
+
+const (
+	apiRaftApplyPath = "/internal/raft/apply"
+
+	// raft commands are small, bigger bodies are rejected:
+	apiRaftApplyMaxBody = 1 << 20
+)
+
+// Raft commands could be applied on the leader only, so followers send them to the leader API.
+// Leader-only changes (api keys, queue control, node heartbeats) work on every node this way.
+type raftForwarder struct {
+	httpClient *http.Client
+}
+
+func newRaftForwarder() (*raftForwarder, error) {
+
+	if len(globConfig.Base.Raft.ApiUrls) != 0 && globConfig.Base.Raft.ForwardSecret == "" {
+		return nil, errors.New("The raft api_urls are defined, but raft/forward_secret is not defined in the configuration file!")
+	}
+
+	return &raftForwarder{
+		httpClient: &http.Client{Timeout: globConfig.Base.Raft.Timeouts.Commit},
+	}, nil
+}
+
+func (m *raftForwarder) forward(leaderId string, cmd []byte) error {
+
+	var url, ok = globConfig.Base.Raft.ApiUrls[leaderId]
+	if !ok {
+		return errors.New("the leader " + leaderId + " has no api url in raft/api_urls")
+	}
+
+	rq, e := http.NewRequest("POST", strings.TrimSuffix(url, "/")+apiRaftApplyPath, bytes.NewReader(cmd))
+	if e != nil {
+		return e
+	}
+	rq.Header.Set("Content-Type", "application/json")
+
+	if e = signRequest(rq, apiClusterKeyId, globConfig.Base.Raft.ForwardSecret, cmd); e != nil {
+		return e
+	}
+
+	rsp, e := m.httpClient.Do(rq)
+	if e != nil {
+		return e
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusNoContent {
+		buf, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 1024))
+		return errors.New("the leader " + leaderId + " has rejected the command: " + rsp.Status + " " + strings.TrimSpace(string(buf)))
+	}
+
+	globLogger.Debug().Str("leader", leaderId).Msg("[RAFT]: The command has been forwarded to the leader")
+	return nil
+}
+
+// the command is applied by the leader, the response has no body on success:
+func (m *apiController) httpHandlerRaftApply(w http.ResponseWriter, r *http.Request) {
+
+	body, e := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, apiRaftApplyMaxBody))
+	if e != nil {
+		http.Error(w, "Could not read the request body", http.StatusBadRequest)
+		return
+	}
+
+	if errCode := verifyClusterSignature(r, body); errCode != errNotError {
+		http.Error(w, apiErrorsTitle[errCode], apiErrorsStatus[errCode])
+		return
+	}
+
+	if !globRaftStore.IsLeader() {
+		http.Error(w, apiErrorsTitle[errApiNotLeader], apiErrorsStatus[errApiNotLeader])
+		return
+	}
+
+	if e = globRaftStore.Apply(body); e != nil {
+		globLogger.Error().Err(e).Msg("[RAFT]: Could not apply the forwarded command!")
+		http.Error(w, e.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,62 @@
+package server
+
+import "strconv"
+import "testing"
+import "net/http"
+import "io/ioutil"
+import "encoding/hex"
+import "net/http/httptest"
+
+func TestRaftForwarder(t *testing.T) {
+
+	var cmd = []byte(`{"Act":0,"Bucket":"api_keys","Key":"test","Value":"{}"}`)
+	var status = http.StatusNoContent
+
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(apiSignHeaderTimestamp), 10, 64)
+		mac, _ := hex.DecodeString(r.Header.Get("Authorization")[len(apiSignSchemeV2+" "+apiClusterKeyId+":"):])
+
+		if r.URL.Path != apiRaftApplyPath || string(body) != string(cmd) ||
+			!isValidSignature([]string{"cluster"}, getStringToSign(r, timestamp, r.Header.Get(apiSignHeaderNonce), body), mac) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	globConfig.Base.Raft.ApiUrls = map[string]string{"node1": srv.URL + "/"}
+	globConfig.Base.Raft.ForwardSecret = "cluster"
+	defer func() { globConfig.Base.Raft.ApiUrls, globConfig.Base.Raft.ForwardSecret = map[string]string{}, "" }()
+
+	fwd, e := newRaftForwarder()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if e = fwd.forward("node1", cmd); e != nil {
+		t.Errorf("forward() has failed: %v", e)
+	}
+
+	if e = fwd.forward("node2", cmd); e == nil {
+		t.Errorf("forward() must fail for the leader without api url")
+	}
+
+	status = http.StatusMisdirectedRequest
+	if e = fwd.forward("node1", cmd); e == nil {
+		t.Errorf("forward() must fail if the leader rejects the command")
+	}
+}
+
+func TestRaftForwarderWithoutSecret(t *testing.T) {
+
+	globConfig.Base.Raft.ApiUrls = map[string]string{"node1": "http://127.0.0.1:8080"}
+	defer func() { globConfig.Base.Raft.ApiUrls = map[string]string{} }()
+
+	if _, e := newRaftForwarder(); e == nil {
+		t.Errorf("newRaftForwarder() must fail without the forward secret")
+	}
+}
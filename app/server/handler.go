@@ -0,0 +1,600 @@
+package server
+
+import "net"
+import "sync"
+import "time"
+import "context"
+import "strconv"
+import "strings"
+import "math/rand"
+
+type (
+	// every job action must be registered with its handler:
+	jobHandler struct {
+		action uint8
+		name   string
+
+		// typed payload decoder, the result is given to the run function:
+		decode func(*jobPayload) (interface{}, *appError)
+		run    func(context.Context, *queueJob, interface{}) *appError
+
+		timeout time.Duration
+		retry   *jobRetryPolicy
+
+		// the dispatcher runs no more jobs of the action at once (0 - unlimited):
+		concurrency int
+	}
+	jobRetryPolicy struct {
+		maxFails    int
+		interval    time.Duration
+		maxInterval time.Duration
+	}
+)
+
+var jobHandlers = make(map[uint8]*jobHandler)
+
+// finished jobs are purged by requests in batches, so the IN list stays small:
+const requestsPurgeBatch = 100
+
+func registerJobHandler(h *jobHandler) {
+
+	if h.retry == nil {
+		h.retry = newDefaultRetryPolicy()
+	}
+
+	if policy, ok := globConfig.Base.Queue.ActionRetries[h.name]; ok {
+		h.retry = &jobRetryPolicy{maxFails: policy.MaxFails, interval: policy.Interval, maxInterval: policy.MaxInterval}
+	}
+
+	if limit, ok := globConfig.Base.Queue.ActionConcurrency[h.name]; ok {
+		h.concurrency = limit
+	}
+
+	if timeout, ok := globConfig.Base.Queue.ActionTimeouts[h.name]; ok {
+		h.timeout = timeout
+	}
+
+	jobHandlers[h.action] = h
+}
+
+func registerJobHandlers() {
+
+	registerJobHandler(&jobHandler{
+		action:  jobActServerPing,
+		name:    "server_ping",
+		decode:  decodeEmptyPayload,
+		run:     runServerPing,
+		timeout: time.Minute,
+		retry:   &jobRetryPolicy{maxFails: 2, interval: 30 * time.Second, maxInterval: time.Minute},
+	})
+
+	registerJobHandler(&jobHandler{
+		action:  jobActHostCreate,
+		name:    "host_create",
+		decode:  decodeHostPayload,
+		run:     runHostCreate,
+		timeout: 30 * time.Second,
+		retry:   &jobRetryPolicy{maxFails: 3, interval: 5 * time.Second, maxInterval: time.Minute},
+	})
+
+	// rsview is an external service, so it must not be flooded:
+	registerJobHandler(&jobHandler{
+		action:      jobActRsviewParse,
+		name:        "rsview_parse",
+		decode:      decodePortPayload,
+		run:         runRsviewParse,
+		timeout:     2 * time.Minute,
+		retry:       &jobRetryPolicy{maxFails: 5, interval: 30 * time.Second, maxInterval: 10 * time.Minute},
+		concurrency: 2,
+	})
+
+	// scheduled jobs:
+	registerJobHandler(&jobHandler{
+		action:      jobActRsviewRescan,
+		name:        "rsview_rescan",
+		decode:      decodePortPayload,
+		run:         runRsviewRescan,
+		timeout:     2 * time.Minute,
+		retry:       &jobRetryPolicy{maxFails: 3, interval: time.Minute, maxInterval: 10 * time.Minute},
+		concurrency: 2,
+	})
+
+	registerJobHandler(&jobHandler{
+		action:  jobActRequestsPurge,
+		name:    "requests_purge",
+		decode:  decodeEmptyPayload,
+		run:     runRequestsPurge,
+		timeout: 5 * time.Minute,
+		retry:   &jobRetryPolicy{maxFails: 2, interval: time.Minute, maxInterval: 5 * time.Minute},
+	})
+
+	registerJobHandler(&jobHandler{
+		action:  jobActHostsReport,
+		name:    "stale_hosts_report",
+		decode:  decodeEmptyPayload,
+		run:     runStaleHostsReport,
+		timeout: time.Minute,
+		retry:   &jobRetryPolicy{maxFails: 2, interval: time.Minute, maxInterval: 5 * time.Minute},
+	})
+
+	// notifications:
+	registerJobHandler(&jobHandler{
+		action:      jobActIcqSendMess,
+		name:        "icq_send_message",
+		decode:      decodeMessagePayload,
+		run:         runIcqSendMessage,
+		timeout:     30 * time.Second,
+		retry:       &jobRetryPolicy{maxFails: 5, interval: 10 * time.Second, maxInterval: 5 * time.Minute},
+		concurrency: 2,
+	})
+
+	registerJobHandler(&jobHandler{
+		action:      jobActNotifySend,
+		name:        "notify_send",
+		decode:      decodeNotificationPayload,
+		run:         runNotifySend,
+		timeout:     30 * time.Second,
+		retry:       &jobRetryPolicy{maxFails: 5, interval: 10 * time.Second, maxInterval: 5 * time.Minute},
+		concurrency: 2,
+	})
+
+	registerJobHandler(&jobHandler{
+		action:  jobActNotifyDigest,
+		name:    "notify_digest",
+		decode:  decodeEmptyPayload,
+		run:     runNotifyDigest,
+		timeout: 5 * time.Minute,
+		retry:   &jobRetryPolicy{maxFails: 3, interval: time.Minute, maxInterval: 10 * time.Minute},
+	})
+}
+
+func getJobHandler(action uint8) (*jobHandler, *appError) {
+
+	h, ok := jobHandlers[action]
+	if !ok {
+		return nil, newAppError(errJobsUnknownAction).log(nil, "Could not find a handler for the job action!")
+	}
+
+	return h, nil
+}
+
+func newDefaultRetryPolicy() *jobRetryPolicy {
+	return &jobRetryPolicy{
+		maxFails:    globConfig.Base.Queue.JobRetryMaxFails,
+		interval:    globConfig.Base.Queue.JobRetryInterval,
+		maxInterval: globConfig.Base.Queue.JobRetryMaxInterval,
+	}
+}
+
+func getJobRetryPolicy(action uint8) *jobRetryPolicy {
+
+	if h, ok := jobHandlers[action]; ok {
+		return h.retry
+	}
+
+	return newDefaultRetryPolicy()
+}
+
+// permanent errors are never retried, the fails include the given one:
+func (m *jobRetryPolicy) shouldRetry(aErr *appError, fails int) bool {
+	return aErr.isRetryable() && fails < m.maxFails
+}
+
+// exponential backoff with jitter - the delay is in [interval*2^(n-1) / 2, interval*2^(n-1)]:
+func (m *jobRetryPolicy) getRetryDelay(fails int) time.Duration {
+
+	var delay = m.interval
+	for i := 1; i < fails && delay < m.maxInterval; i++ {
+		delay *= 2
+	}
+
+	if delay > m.maxInterval {
+		delay = m.maxInterval
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func getJobActionByName(name string) (uint8, bool) {
+
+	for k, v := range jobHandlers {
+		if v.name == name {
+			return k, true
+		}
+	}
+
+	return 0, false
+}
+
+func getJobHandlerName(action uint8) string {
+
+	if h, ok := jobHandlers[action]; ok {
+		return h.name
+	}
+
+	return strconv.Itoa(int(action))
+}
+
+func getJobConcurrency(action uint8) int {
+
+	if h, ok := jobHandlers[action]; ok {
+		return h.concurrency
+	}
+
+	return 0
+}
+
+// the parent context is cancelled by the queue drain, when the shutdown grace period has expired:
+func (m *jobHandler) exec(parent context.Context, jb *queueJob) *appError {
+
+	payload, err := m.decode(jb.payload)
+	if err != nil {
+		return err
+	}
+
+	var ctx, cancel = context.WithTimeout(parent, m.timeout)
+	defer cancel()
+
+	// Every handler honours the context, so the handler has returned when the timeout is reported
+	// and the job is never retried while its previous run is still working:
+	err = m.run(ctx, jb, payload)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		globLogger.Warn().Str("job_id", jb.id).Dur("timeout", m.timeout).Msg("The job has exceeded the execution timeout!")
+		return newAppError(errJobsTimedOut).log(ctx.Err(), "The job has been cancelled by the execution timeout!")
+	}
+
+	return err
+}
+
+// handlers check the context between their steps, so nothing is saved after the timeout:
+func checkJobContext(ctx context.Context) *appError {
+
+	if e := ctx.Err(); e != nil {
+		return newAppError(errJobsTimedOut).log(e, "The job has been cancelled by the execution timeout!")
+	}
+
+	return nil
+}
+
+// payload decoders:
+func decodeEmptyPayload(*jobPayload) (interface{}, *appError)           { return nil, nil }
+func decodeHostPayload(pl *jobPayload) (interface{}, *appError)         { return pl.getHost() }
+func decodePortPayload(pl *jobPayload) (interface{}, *appError)         { return pl.getPort() }
+func decodeMessagePayload(pl *jobPayload) (interface{}, *appError)      { return pl.getMessage() }
+func decodeNotificationPayload(pl *jobPayload) (interface{}, *appError) { return pl.getNotification() }
+
+// job handlers:
+// every created host must answer on its IPMI address, unreachable hosts fail the job:
+func runServerPing(ctx context.Context, jb *queueJob, _ interface{}) *appError {
+
+	hosts, err := getHostsIpmiAddresses(ctx)
+	if err != nil {
+		return err
+	}
+
+	var addrs []string
+	for k := range hosts {
+		addrs = append(addrs, k)
+	}
+
+	var cfg = globConfig.Base.Ipmi.Ping
+	var dialer = &net.Dialer{Timeout: cfg.Timeout}
+
+	var unreachable = pingHosts(ctx, addrs, cfg.Concurrency, func(ctx context.Context, addr string) error {
+		conn, e := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr, strconv.Itoa(cfg.Port)))
+		if e != nil {
+			return e
+		}
+
+		return conn.Close()
+	})
+
+	if err = checkJobContext(ctx); err != nil {
+		return err
+	}
+
+	for addr, e := range unreachable {
+		globLogger.Warn().Err(e).Str("hostname", hosts[addr]).Str("ipmi_address", addr).Msg("The host is unreachable")
+	}
+
+	globLogger.Info().Int("hosts", len(hosts)).Int("unreachable", len(unreachable)).Msg("The hosts have been pinged")
+
+	if len(unreachable) != 0 {
+		return newAppError(errHostsUnreachable).log(nil, strconv.Itoa(len(unreachable))+" host(s) have not answered on the IPMI port!")
+	}
+
+	return nil
+}
+
+// addresses are dialed by the limited number of goroutines, the failed ones are returned:
+func pingHosts(ctx context.Context, addrs []string, concurrency int, dial func(context.Context, string) error) map[string]error {
+
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var slots = make(chan struct{}, concurrency)
+	var failed = make(map[string]error)
+
+	for _, v := range addrs {
+		slots <- struct{}{}
+		wg.Add(1)
+
+		go func(addr string) {
+			defer func() { <-slots; wg.Done() }()
+
+			if e := dial(ctx, addr); e != nil {
+				mu.Lock()
+				failed[addr] = e
+				mu.Unlock()
+			}
+		}(v)
+	}
+
+	wg.Wait()
+	return failed
+}
+
+func runHostCreate(ctx context.Context, jb *queueJob, payload interface{}) *appError {
+
+	var host = payload.(*baseHost)
+
+	res, e := host.resolveIpmiHostname(ctx)
+	if res != nil {
+		if err := jb.saveResolution(res); err != nil {
+			return err
+		}
+	}
+
+	if e != nil {
+		return e
+	}
+
+	if e = checkJobContext(ctx); e != nil {
+		return e
+	}
+
+	return host.updateOrCreate(jb.id)
+}
+
+func runRsviewParse(ctx context.Context, jb *queueJob, payload interface{}) *appError {
+
+	var port = payload.(*basePort)
+
+	if e := port.parseRsviewProperties(ctx); e != nil {
+		return e
+	}
+
+	if e := checkJobContext(ctx); e != nil {
+		return e
+	}
+
+	reqHostJob, e := getTinyJobByReqId(jb.requested_by, jobActHostCreate)
+	if e != nil {
+		return e
+	}
+
+	host, e := getTinyHostByJobId(reqHostJob.id)
+	if e != nil {
+		return e
+	}
+
+	if host == nil {
+		return newAppError(errHostsNotFound).log(nil, "Couldn't find a host from current request!")
+	}
+
+	if !port.compareLLDPWithHost(host.hostname) {
+		return newAppError(errRsviewLLDPMismatch)
+	}
+
+	if e = port.linkWithHost(host.id); e != nil {
+		return e
+	}
+
+	if e = checkJobContext(ctx); e != nil {
+		return e
+	}
+
+	// the install task gives the host kickstart with the enrollment token:
+	if host, e = getHostById(host.id); e != nil {
+		return e
+	}
+
+	if host == nil {
+		return newAppError(errHostsNotFound).log(nil, "The host has been deleted before the install task creation!")
+	}
+
+	tsk, e := newInstallTask(jb.requested_by, host, port.mac.String())
+	if e != nil {
+		return e
+	}
+
+	globLogger.Info().Str("task_id", tsk.id).Str("hostname", host.hostname).Time("expires_at", tsk.expires_at).
+		Msg("The install task has been created")
+	return nil
+}
+
+// cabling could be changed after the install, so ports of known hosts are compared again:
+func runRsviewRescan(ctx context.Context, jb *queueJob, payload interface{}) *appError {
+
+	var port = payload.(*basePort)
+
+	host, e := getHostByMac(port.mac.String())
+	if e != nil {
+		return e
+	}
+
+	// the port has been unlinked since the job creation:
+	if host == nil {
+		return nil
+	}
+
+	if e = port.parseRsviewProperties(ctx); e != nil {
+		return e
+	}
+
+	if !port.compareLLDPWithHost(host.hostname) {
+		globLogger.Warn().Str("mac", port.mac.String()).Str("hostname", host.hostname).Str("lldp_host", port.lldp_host).
+			Msg("The port is connected to another host now!")
+		return newAppError(errRsviewLLDPMismatch).log(nil, "The port LLDP neighbour has been changed after the install!")
+	}
+
+	return nil
+}
+
+func runIcqSendMessage(ctx context.Context, jb *queueJob, payload interface{}) *appError {
+
+	var msg = payload.(*jobPayloadMessage)
+	return globIcq.sendText(ctx, msg.Chat_Id, msg.Text)
+}
+
+func runNotifySend(ctx context.Context, jb *queueJob, payload interface{}) *appError {
+	return globNotifier.send(ctx, payload.(*jobPayloadNotification).Id)
+}
+
+func runNotifyDigest(ctx context.Context, jb *queueJob, _ interface{}) *appError {
+	return globNotifier.sendDigests(ctx)
+}
+
+// Requests which have created hosts are the install history, they are kept with their jobs.
+// Jobs of other requests are purged with the requests when all of them have finished, so
+// no unfinished job loses its dependencies. Errors, transitions and dead letters are
+// deleted with the jobs by the foreign keys.
+func runRequestsPurge(ctx context.Context, jb *queueJob, _ interface{}) *appError {
+
+	var retention = time.Now().Add(-globConfig.Base.Scheduler.RequestsRetention).Format("2006-01-02 15:04:05")
+
+	reqIds, err := getFinishedRequests(ctx, retention)
+	if err != nil {
+		return err
+	}
+
+	var jobs int64
+	for len(reqIds) != 0 {
+
+		var batch = reqIds
+		if len(batch) > requestsPurgeBatch {
+			batch = batch[:requestsPurgeBatch]
+		}
+		reqIds = reqIds[len(batch):]
+
+		var args []interface{}
+		for _, v := range batch {
+			args = append(args, v)
+		}
+
+		rs, e := globSqlDB.ExecContext(ctx, "DELETE FROM jobs WHERE requested_by IN (?"+strings.Repeat(",?", len(args)-1)+")", args...)
+		if e != nil {
+			return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+		}
+
+		purged, _ := rs.RowsAffected()
+		jobs += purged
+	}
+
+	globLogger.Info().Int64("jobs", jobs).Msg("Old finished jobs have been purged")
+
+	rs, e := globSqlDB.ExecContext(ctx, `DELETE FROM requests
+		WHERE requested_at < ? AND NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.requested_by = requests.id)`, retention)
+	if e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	purged, _ := rs.RowsAffected()
+	globLogger.Info().Int64("requests", purged).Msg("Old requests have been purged")
+
+	// sent notifications are kept for the rate limits and the digests only:
+	if _, e = globSqlDB.ExecContext(ctx, "DELETE FROM notifications WHERE sent_at < ?", retention); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	// expired nonces could be used again, so the rows are kept for the replay window only:
+	if _, e = globSqlDB.ExecContext(ctx, "DELETE FROM api_nonces WHERE expires_at < ?",
+		time.Now().Format("2006-01-02 15:04:05")); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	return nil
+}
+
+// old requests whose jobs have finished (done or failed) and have created no hosts:
+func getFinishedRequests(ctx context.Context, retention string) ([]string, *appError) {
+
+	var ids []string
+
+	rws, e := globSqlDB.QueryContext(ctx, `SELECT requests.id FROM requests
+		WHERE requests.requested_at < ?
+			AND EXISTS (SELECT 1 FROM jobs WHERE jobs.requested_by = requests.id)
+			AND NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.requested_by = requests.id AND jobs.is_failed = 0 AND jobs.state NOT IN (?,?))
+			AND NOT EXISTS (SELECT 1 FROM hosts JOIN jobs ON hosts.created_by = jobs.id WHERE jobs.requested_by = requests.id)`,
+		retention, jobStatusDone, jobStatusFailed)
+	if e != nil {
+		return ids, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var id string
+		if e = rws.Scan(&id); e != nil {
+			return ids, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		ids = append(ids, id)
+	}
+
+	if rws.Err() != nil {
+		return ids, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return ids, nil
+}
+
+// hosts which have not been updated for the configured age are reported in the audit log:
+func runStaleHostsReport(ctx context.Context, jb *queueJob, _ interface{}) *appError {
+
+	rws, e := globSqlDB.QueryContext(ctx, "SELECT hostname,updated_at FROM hosts WHERE updated_at < ? ORDER BY updated_at",
+		time.Now().Add(-globConfig.Base.Scheduler.StaleHostAge).Format("2006-01-02 15:04:05"))
+	if e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	var hostnames []string
+	for rws.Next() {
+
+		var hostname string
+		var updatedAt time.Time
+
+		if e = rws.Scan(&hostname, &updatedAt); e != nil {
+			return newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		globLogger.Warn().Str("hostname", hostname).Time("updated_at", updatedAt).Msg("The host is stale")
+		hostnames = append(hostnames, hostname)
+	}
+
+	if rws.Err() != nil {
+		return newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	for _, v := range hostnames {
+		if err := checkJobContext(ctx); err != nil {
+			return err
+		}
+
+		if err := newAuditEvent(jb.requested_by, auditActHostStale, v).save(); err != nil {
+			return err
+		}
+	}
+
+	globLogger.Info().Int("hosts", len(hostnames)).Msg("The stale hosts report has been done")
+	return nil
+}
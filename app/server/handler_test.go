@@ -0,0 +1,164 @@
+package server
+
+import "net"
+import "time"
+import "context"
+import "testing"
+import "github.com/MindHunter86/ks-installer/core/config"
+
+func TestJobHandlerExecTimeout(t *testing.T) {
+
+	var returned bool
+	var h = &jobHandler{
+		decode:  decodeEmptyPayload,
+		timeout: 10 * time.Millisecond,
+		run: func(ctx context.Context, jb *queueJob, _ interface{}) *appError {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			returned = true
+			return checkJobContext(ctx)
+		},
+	}
+
+	err := h.exec(context.Background(), &queueJob{id: "test"})
+	if err == nil || err.code != errJobsTimedOut {
+		t.Fatalf("exec() must fail with errJobsTimedOut")
+	}
+
+	if !returned {
+		t.Errorf("exec() has reported the timeout before the handler has returned")
+	}
+}
+
+func TestJobHandlerExec(t *testing.T) {
+
+	var h = &jobHandler{
+		decode:  decodeEmptyPayload,
+		timeout: time.Second,
+		run: func(ctx context.Context, jb *queueJob, _ interface{}) *appError {
+			return checkJobContext(ctx)
+		},
+	}
+
+	if err := h.exec(context.Background(), &queueJob{id: "test"}); err != nil {
+		t.Errorf("exec() has failed with the code %d", err.code)
+	}
+}
+
+func TestJobRetryPolicyDelay(t *testing.T) {
+
+	var policy = &jobRetryPolicy{maxFails: 5, interval: time.Second, maxInterval: 10 * time.Second}
+
+	var tests = []struct {
+		fails int
+		max   time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if delay := policy.getRetryDelay(tt.fails); delay < tt.max/2 || delay > tt.max {
+				t.Fatalf("getRetryDelay(%d) = %s, want [%s, %s]", tt.fails, delay, tt.max/2, tt.max)
+			}
+		}
+	}
+
+	if delay := new(jobRetryPolicy).getRetryDelay(1); delay != 0 {
+		t.Errorf("getRetryDelay() of the empty policy = %s, want 0", delay)
+	}
+}
+
+func TestJobRetryPolicies(t *testing.T) {
+
+	var saved = globConfig.Base.Queue.ActionRetries
+	globConfig.Base.Queue.ActionRetries = map[string]config.RetryPolicy{
+		"notify_send": {MaxFails: 10, Interval: time.Second, MaxInterval: time.Minute},
+	}
+	defer func() { globConfig.Base.Queue.ActionRetries = saved }()
+
+	registerJobHandlers()
+
+	if ping, parse := getJobRetryPolicy(jobActServerPing), getJobRetryPolicy(jobActRsviewParse); *ping == *parse {
+		t.Errorf("server_ping and rsview_parse have the same retry policy %+v", *ping)
+	}
+
+	var policy = getJobRetryPolicy(jobActNotifySend)
+	if policy.maxFails != 10 || policy.interval != time.Second || policy.maxInterval != time.Minute {
+		t.Errorf("the configured notify_send retry policy has not been applied, got %+v", *policy)
+	}
+
+	if policy = getJobRetryPolicy(255); policy.maxFails != globConfig.Base.Queue.JobRetryMaxFails {
+		t.Errorf("unknown actions must have the default retry policy, got %+v", *policy)
+	}
+}
+
+func TestPingHosts(t *testing.T) {
+
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, e := ln.Accept()
+			if e != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	// the closed listener gives a free port which refuses connections:
+	closed, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	closed.Close()
+
+	var ports = map[string]string{"10.0.0.1": ln.Addr().String(), "10.0.0.2": closed.Addr().String(), "10.0.0.3": ln.Addr().String()}
+	var dialer = new(net.Dialer)
+
+	var failed = pingHosts(context.Background(), []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, 2,
+		func(ctx context.Context, addr string) error {
+			conn, e := dialer.DialContext(ctx, "tcp", ports[addr])
+			if e != nil {
+				return e
+			}
+			return conn.Close()
+		})
+
+	if _, ok := failed["10.0.0.2"]; len(failed) != 1 || !ok {
+		t.Errorf("pingHosts() has failed %v, want 10.0.0.2 only", failed)
+	}
+}
+
+func TestJobRetryPolicyShouldRetry(t *testing.T) {
+
+	var policy = &jobRetryPolicy{maxFails: 3}
+
+	var tests = []struct {
+		code  uint8
+		fails int
+		retry bool
+	}{
+		{errInternalSqlError, 1, true},
+		{errInternalSqlError, 2, true},
+		{errInternalSqlError, 3, false},
+		{errInternalSqlError, 7, false},
+		{errJobsPayloadInvalid, 1, false},
+	}
+
+	for _, tt := range tests {
+		if retry := policy.shouldRetry(&appError{code: tt.code}, tt.fails); retry != tt.retry {
+			t.Errorf("shouldRetry() of the code %d with %d fails = %v, want %v", tt.code, tt.fails, retry, tt.retry)
+		}
+	}
+}
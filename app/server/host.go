@@ -4,6 +4,7 @@ import "net"
 import "context"
 import "time"
 import "strings"
+import "database/sql"
 import "github.com/satori/go.uuid"
 
 type (
@@ -13,6 +14,14 @@ type (
 		ipmi_address *net.IP
 		created_by   string
 		updated_at   time.Time
+		created_at   time.Time
+
+		// the kickstart url of the active install task (for the signed requests only):
+		kickstart_url string
+
+		// preloaded relations (used before the host row is linked with them):
+		ports []*basePort
+		jobs  []*queueJob
 	}
 )
 
@@ -53,6 +62,89 @@ func getTinyHostByJobId(jbId string) (*baseHost, *appError) {
 	return host, nil
 }
 
+// ipmi address => hostname of every created host:
+func getHostsIpmiAddresses(ctx context.Context) (map[string]string, *appError) {
+
+	var addrs = make(map[string]string)
+
+	rws, e := globSqlDB.QueryContext(ctx, "SELECT hostname,ipmi_address FROM hosts")
+	if e != nil {
+		return addrs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var hostname, addr string
+		if e = rws.Scan(&hostname, &addr); e != nil {
+			return addrs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		addrs[addr] = hostname
+	}
+
+	if rws.Err() != nil {
+		return addrs, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return addrs, nil
+}
+
+func getHostById(hId string) (*baseHost, *appError) {
+	return getHostByQuery("SELECT id,hostname,ipmi_address,created_by,updated_at,created_at FROM hosts WHERE id = ? LIMIT 2", hId)
+}
+
+func getHostByMac(mac string) (*baseHost, *appError) {
+
+	hwAddr, e := net.ParseMAC(mac)
+	if e != nil {
+		err := newAppError(errPortsAbnormalMac)
+		return nil, err.log(e, "Could not parse the given MAC address!", err.glCtx().Str("mac", mac))
+	}
+
+	return getHostByQuery(`SELECT hosts.id,hosts.hostname,hosts.ipmi_address,hosts.created_by,hosts.updated_at,hosts.created_at
+		FROM hosts
+		INNER JOIN macs
+		ON hosts.id = macs.host
+		WHERE macs.mac = ? LIMIT 2`, hwAddr.String())
+}
+
+func getHostByQuery(query string, args ...interface{}) (*baseHost, *appError) {
+
+	rws, e := globSqlDB.Query(query, args...)
+	if e != nil {
+		return nil, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	if !rws.Next() {
+		if rws.Err() != nil {
+			return nil, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+		}
+
+		return nil, nil
+	}
+
+	var host = new(baseHost)
+	var ipmiAddr, createdBy sql.NullString
+
+	if e = rws.Scan(&host.id, &host.hostname, &ipmiAddr, &createdBy, &host.updated_at, &host.created_at); e != nil {
+		return nil, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+	}
+
+	if rws.Next() {
+		return nil, newAppError(errInternalSqlError).log(nil, "Rows is not equal to 1. The DB has broken!")
+	}
+
+	if ipmiAddr.Valid {
+		var ip = net.ParseIP(ipmiAddr.String)
+		host.ipmi_address = &ip
+	}
+
+	host.created_by = createdBy.String
+	return host, nil
+}
+
 func (m *baseHost) parseIpmiAddress(ipmiIp *string) *appError {
 
 	var ipmiAddr = net.ParseIP(*ipmiIp)
@@ -70,7 +162,7 @@ func (m *baseHost) parseIpmiAddress(ipmiIp *string) *appError {
 	}
 
 	m.ipmi_address = &ipmiAddr
-	return m.getOrCreate()
+	return nil
 }
 
 func (m *baseHost) getOrCreate() *appError {
@@ -86,7 +178,8 @@ func (m *baseHost) getOrCreate() *appError {
 			return newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
 		}
 
-		if _, e = globSqlDB.Exec("INSERT INTO hosts (id, ipmi_address) VALUES (?, ?)", m.id, m.ipmi_address.String()); e != nil {
+		// the hostname is resolved by resolveIpmiHostname() before the insert:
+		if _, e = globSqlDB.Exec("INSERT INTO hosts (id, hostname, ipmi_address) VALUES (?, ?, ?)", m.id, m.hostname, m.ipmi_address.String()); e != nil {
 			return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
 		}
 
@@ -104,27 +197,31 @@ func (m *baseHost) getOrCreate() *appError {
 	return nil
 }
 
-func (m *baseHost) resolveIpmiHostname() *appError {
-
-	var resolver = new(net.Resolver)
+// The PTR record is chosen by the resolver policy, the hostname is its first label.
+// Both are validated by the create request before the host is saved and again by the job,
+// so a mis-typed PTR can't create a bogus host.
+func (m *baseHost) resolveIpmiHostname(ctx context.Context) (*hostResolution, *appError) {
 
-	if globConfig.Base.DNSResolver != "" {
-		resolver.Dial = func(ctx context.Context, network, server string) (net.Conn, error) {
-			return new(net.Dialer).DialContext(ctx, network, globConfig.Base.DNSResolver)
-		}
+	res, err := globResolver.resolve(ctx, *m.ipmi_address)
+	if err != nil {
+		return res, err
 	}
 
-	hostnames, e := resolver.LookupAddr(context.Background(), m.ipmi_address.String())
-	if e != nil {
-		return newAppError(errInternalCommonError).log(e, "Net lookup error!")
+	m.hostname = strings.SplitN(res.Ptr, ".", 2)[0]
+	res.Hostname = m.hostname
+
+	if !isIpmiDomainName(res.Ptr) {
+		ae := newAppError(errHostsIpmiTldMismatch)
+		return res, ae.log(nil, "Top-level domain of the resolved IPMI hostname does not match the configuration!",
+			ae.glCtx().Str("ptr", res.Ptr).Str("tld", globConfig.Base.Ipmi.HostnameTLD))
 	}
 
-	if len(hostnames) != 1 {
-		return newAppError(errHostsAmbiguousResolver).log(nil, "The resolver returned two or more hostnames!")
+	if res.Project = globPuppet.getProjectByHostname(m.hostname); res.Project == "" {
+		ae := newAppError(errHostsNamingMismatch)
+		return res, ae.log(nil, "The hostname does not match any project naming regexp!", ae.glCtx().Str("hostname", m.hostname))
 	}
 
-	m.hostname = strings.SplitN(hostnames[0], ".", 2)[0]
-	return nil
+	return res, nil
 }
 
 func (m *baseHost) updateOrCreate(jobId string) *appError {
@@ -175,6 +272,10 @@ func (m *baseHost) findProperties() (bool, *appError) {
 		return false, err
 	}
 
+	if err := m.getOrCreate(); err != nil {
+		return false, err
+	}
+
 	if rws.Next() {
 		return false, newAppError(errInternalCommonError).log(nil, "Rows is not equal to 1. The DB has broken!")
 	}
@@ -205,3 +306,78 @@ func (m *baseHost) createProperties() *appError {
 
 	return nil
 }
+
+// apiResource interface implementation:
+func (m *baseHost) getResourceType() string { return apiTypeHost }
+func (m *baseHost) getResourceId() string   { return m.id }
+
+func (m *baseHost) getResourceAttributes() interface{} {
+
+	var attributes = &attributesHost{
+		Hostname: m.hostname,
+	}
+
+	if m.ipmi_address != nil {
+		attributes.Ipmi_Address = m.ipmi_address.String()
+	}
+
+	attributes.Kickstart_Url = m.kickstart_url
+
+	if !m.updated_at.IsZero() {
+		attributes.Updated_At = m.updated_at.Format(time.RFC3339)
+	}
+
+	if !m.created_at.IsZero() {
+		attributes.Created_At = m.created_at.Format(time.RFC3339)
+	}
+
+	return attributes
+}
+
+func (m *baseHost) getResourceRelations() []*resourceRelation {
+	return []*resourceRelation{
+		{name: "ports", toMany: true, loader: m.loadPortResources},
+		{name: "jobs", toMany: true, loader: m.loadJobResources},
+	}
+}
+
+func (m *baseHost) loadPortResources() ([]apiResource, *appError) {
+
+	var ports = m.ports
+	if ports == nil {
+		var err *appError
+		if ports, err = getPortsByHostId(m.id); err != nil {
+			return nil, err
+		}
+	}
+
+	var rs []apiResource
+	for _, v := range ports {
+		rs = append(rs, v)
+	}
+
+	return rs, nil
+}
+
+// host jobs are all jobs of the request which has created the host:
+func (m *baseHost) loadJobResources() ([]apiResource, *appError) {
+
+	var jobs = m.jobs
+	if jobs == nil && m.created_by != "" {
+		jb, err := getJobById(m.created_by)
+		if err != nil {
+			return nil, err
+		}
+
+		if jobs, err = getJobsByRequestId(jb.requested_by); err != nil {
+			return nil, err
+		}
+	}
+
+	var rs []apiResource
+	for _, v := range jobs {
+		rs = append(rs, v)
+	}
+
+	return rs, nil
+}
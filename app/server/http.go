@@ -1,16 +1,19 @@
 package server
 
-import "time"
-import "encoding/hex"
-import "crypto/sha256"
-import "crypto/hmac"
 import "bytes"
+import "fmt"
+import "mime"
+import "net"
+import "time"
+import "strconv"
 import "strings"
 import "io/ioutil"
 import "net/http"
+import "crypto/subtle"
 import "encoding/json"
 import "github.com/gorilla/mux"
 import "github.com/gorilla/context"
+import "github.com/satori/go.uuid"
 
 // JSON response structs:
 // Recomendations are taken from jsonapi.org:
@@ -20,34 +23,41 @@ type (
 
 	// JSON response structs:
 	apiResponse struct {
-		Data    *responseData    `json:"data,omitempty"`
-		Errors  []*responseError `json:"errors,omitempty"`
-		Meta    *responseMeta    `json:"meta,omitempty"`
-		JsonApi *responseJsonApi `json:"jsonapi,omitempty"`
-		Links   *responseLinks   `json:"links,omitempty"`
+		Data     interface{}      `json:"data,omitempty"`
+		Included []*responseData  `json:"included,omitempty"`
+		Errors   []*responseError `json:"errors,omitempty"`
+		Meta     *responseMeta    `json:"meta,omitempty"`
+		JsonApi  *responseJsonApi `json:"jsonapi,omitempty"`
+		Links    *responseLinks   `json:"links,omitempty"`
+	}
+
+	// simplified response for plain JSON clients:
+	apiFlatResponse struct {
+		Data   interface{}      `json:"data,omitempty"`
+		Errors []*responseError `json:"errors,omitempty"`
 	}
 
 	responseData struct {
-		Type       string          `json:"type,omitempty"`
-		Id         string          `json:"id,omitempty"`
-		Attributes *dataAttributes `json:"attributes,omitempty"`
+		Type          string                       `json:"type,omitempty"`
+		Id            string                       `json:"id,omitempty"`
+		Attributes    interface{}                  `json:"attributes,omitempty"`
+		Relationships map[string]*dataRelationship `json:"relationships,omitempty"`
 	}
-	dataAttributes struct {
-		Host *attributesHost  `json:"host,omitempty"`
-		Jobs []*attributesJob `json:"jobs,omitempty"`
+	dataRelationship struct {
+		Data interface{} `json:"data"`
 	}
-	attributesHost struct {
-		Host       *hostsHost   `json:"host,omitempty"`
-		Ports      []*hostsPort `json:"ports,omitempty"`
-		Updated_At string       `json:"updated_at,omitempty"`
-		Created_At string       `json:"created_at,omitempty"`
+	resourceIdentifier struct {
+		Type string `json:"type"`
+		Id   string `json:"id"`
 	}
-	hostsHost struct {
-		Id           string `json:",omitempty"`
-		Ipmi_Address string `json:",omitempty"`
-		Ipmi_Ptr     string `json:",omitempty"`
+	attributesHost struct {
+		Hostname      string `json:"hostname,omitempty"`
+		Ipmi_Address  string `json:"ipmi_address,omitempty"`
+		Kickstart_Url string `json:"kickstart_url,omitempty"`
+		Updated_At    string `json:"updated_at,omitempty"`
+		Created_At    string `json:"created_at,omitempty"`
 	}
-	hostsPort struct {
+	attributesPort struct {
 		Mac           string `json:"mac,omitempty"`
 		Jun_Name      string `json:"jun_name,omitempty"`
 		Jun_Port_Name string `json:"jun_port_name,omitempty"`
@@ -56,18 +66,112 @@ type (
 		Created_At    string `json:"created_at,omitempty"`
 	}
 	attributesJob struct {
-		Id         string        `json:"id,omitempty"`
-		Action     string        `json:"action,omitempty"`
-		State      string        `json:"state,omitempty"`
-		Errors     []*jobsErrors `json:"errors,omitempty"`
-		Updated_At string        `json:"updated_at,omitempty"`
-		Created_At string        `json:"created_at,omitempty"`
-	}
-	jobsErrors struct {
-		Id      string `json:"id,omitempty"`
-		Code    uint8  `json:"code,omitempty"`
-		Title   string `json:"title,omitempty"`
-		Details string `json:"details,omitempty"`
+		Action     string          `json:"action,omitempty"`
+		Priority   string          `json:"priority,omitempty"`
+		State      string          `json:"state,omitempty"`
+		Is_Failed  bool            `json:"is_failed"`
+		Resolution *hostResolution `json:"resolution,omitempty"`
+		Updated_At string          `json:"updated_at,omitempty"`
+		Created_At string          `json:"created_at,omitempty"`
+	}
+	attributesError struct {
+		Code   uint8        `json:"code,omitempty"`
+		Title  string       `json:"title,omitempty"`
+		Detail string       `json:"detail,omitempty"`
+		Source *errorSource `json:"source,omitempty"`
+	}
+	attributesRequest struct {
+		Api_Key      string `json:"api_key,omitempty"`
+		Srcip        string `json:"srcip,omitempty"`
+		Method       string `json:"method,omitempty"`
+		Size         int64  `json:"size"`
+		Url          string `json:"url,omitempty"`
+		Status       int    `json:"status,omitempty"`
+		User_Agent   string `json:"user_agent,omitempty"`
+		Requested_At string `json:"requested_at,omitempty"`
+	}
+	attributesKey struct {
+		Scopes              []string `json:"scopes,omitempty"`
+		Cidrs               []string `json:"cidrs,omitempty"`
+		Secret              string   `json:"secret,omitempty"`
+		Created_At          string   `json:"created_at,omitempty"`
+		Rotated_At          string   `json:"rotated_at,omitempty"`
+		Previous_Expires_At string   `json:"previous_expires_at,omitempty"`
+	}
+	attributesEvent struct {
+		Action     string `json:"action,omitempty"`
+		Subject    string `json:"subject,omitempty"`
+		Created_At string `json:"created_at,omitempty"`
+	}
+	attributesTransition struct {
+		From       string `json:"from,omitempty"`
+		To         string `json:"to"`
+		Node       string `json:"node,omitempty"`
+		Worker     int    `json:"worker,omitempty"`
+		Attempt    int    `json:"attempt"`
+		Created_At string `json:"created_at"`
+	}
+	attributesJobTiming struct {
+		Action      string `json:"action"`
+		Jobs        int    `json:"jobs"`
+		Runs        int    `json:"runs"`
+		Wait_P50_Ms int64  `json:"wait_p50_ms"`
+		Wait_P95_Ms int64  `json:"wait_p95_ms"`
+		Run_P50_Ms  int64  `json:"run_p50_ms"`
+		Run_P95_Ms  int64  `json:"run_p95_ms"`
+	}
+	attributesDeadJob struct {
+		Action      string          `json:"action,omitempty"`
+		Error_Code  uint8           `json:"error_code"`
+		Error_Title string          `json:"error_title,omitempty"`
+		Payload     json.RawMessage `json:"payload,omitempty"`
+		Attempts    []*jobAttempt   `json:"attempts"`
+		Failed_At   string          `json:"failed_at,omitempty"`
+	}
+	attributesQueue struct {
+		Workers        int                  `json:"workers"`
+		Idle_Workers   int                  `json:"idle_workers"`
+		Paused         bool                 `json:"paused"`
+		Paused_Actions []string             `json:"paused_actions"`
+		Actions        []*queueActionStatus `json:"actions"`
+		Waiting        []*queueWaitingJob   `json:"waiting"`
+		Watchdog       *attributesWatchdog  `json:"watchdog"`
+	}
+	queueActionStatus struct {
+		Action  string `json:"action"`
+		Limit   int    `json:"limit"`
+		Running int    `json:"running"`
+		Waiting int    `json:"waiting"`
+		Blocked int    `json:"blocked"`
+
+		action uint8
+	}
+	queueWaitingJob struct {
+		Job_Id     string `json:"job_id"`
+		Action     string `json:"action"`
+		Priority   string `json:"priority"`
+		Reason     string `json:"reason"`
+		Created_At string `json:"created_at"`
+
+		jb *queueJob
+	}
+	attributesQueueControl struct {
+		Paused         bool           `json:"paused"`
+		Paused_Actions []string       `json:"paused_actions"`
+		Workers        map[string]int `json:"workers"`
+	}
+	attributesWatchdog struct {
+		Last_Run  string           `json:"last_run,omitempty"`
+		Threshold string           `json:"threshold"`
+		Timed_Out int              `json:"timed_out"`
+		Stuck     []*queueStuckJob `json:"stuck"`
+	}
+	queueStuckJob struct {
+		Job_Id     string `json:"job_id"`
+		Action     string `json:"action"`
+		State      string `json:"state"`
+		Stuck_For  string `json:"stuck_for"`
+		Updated_At string `json:"updated_at"`
 	}
 	responseError struct {
 		Id     string       `json:"id,omitempty"`
@@ -78,6 +182,7 @@ type (
 		Source *errorSource `json:"source,omitempty"`
 	}
 	errorSource struct {
+		Pointer   string `json:"pointer,omitempty"`
 		Parameter string `json:"parameter,omitempty"`
 	}
 
@@ -86,8 +191,44 @@ type (
 		Data *hostRequestData `json:"data"`
 	}
 	hostRequestData struct {
-		Type       string          `json:"type"`
-		Attributes *attributesHost `json:"attributes"`
+		Type       string                 `json:"type"`
+		Attributes *hostRequestAttributes `json:"attributes"`
+	}
+	hostRequestAttributes struct {
+		Host     *hostRequestHost   `json:"host"`
+		Ports    []*hostRequestPort `json:"ports"`
+		Priority string             `json:"priority"`
+	}
+	hostRequestHost struct {
+		Ipmi_Address string `json:"ipmi_address"`
+	}
+	hostRequestPort struct {
+		Mac string `json:"mac"`
+	}
+
+	apiKeyPostRequest struct {
+		Data *keyRequestData `json:"data"`
+	}
+	keyRequestData struct {
+		Type       string                `json:"type"`
+		Id         string                `json:"id"`
+		Attributes *keyRequestAttributes `json:"attributes"`
+	}
+	keyRequestAttributes struct {
+		Scopes []string `json:"scopes"`
+		Cidrs  []string `json:"cidrs"`
+	}
+
+	// plain JSON request structs:
+	hostFlatRequest struct {
+		Ipmi_Address string             `json:"ipmi_address"`
+		Ports        []*hostRequestPort `json:"ports"`
+		Priority     string             `json:"priority"`
+	}
+	keyFlatRequest struct {
+		Id     string   `json:"id"`
+		Scopes []string `json:"scopes"`
+		Cidrs  []string `json:"cidrs"`
 	}
 
 	// JSON meta information:
@@ -114,17 +255,52 @@ func NewApiController() *mux.Router {
 
 	var r = mux.NewRouter()
 	r.Host(globConfig.Base.Http.Host)
-	r.Use(globApi.httpMiddlewareRequestLog)
 
-	s := r.PathPrefix("/v1").Headers("Content-Type", "application/vnd.api+json").Subrouter()
+	// internal requests of cluster nodes are not logged, they are too frequent:
+	r.HandleFunc(apiRaftApplyPath, globApi.httpHandlerRaftApply).Methods("POST")
+	r.HandleFunc("/metrics", globApi.httpHandlerMetricsGet).Methods("GET")
+
+	// install agents have no API keys, they are authorized by the kickstart nonces and the enrollment
+	// tokens of their install tasks, so the routes are matched before the signed /v1 subrouter:
+	r.HandleFunc("/v1/install/{mac:(?:[0-9A-Fa-f]{2}[:-]){5}(?:[0-9A-Fa-f]{2})}/kickstart/{nonce:[0-9a-f]{64}}",
+		globApi.httpHandlerKickstartGet).Methods("GET")
+	r.HandleFunc("/v1/install/{mac:(?:[0-9A-Fa-f]{2}[:-]){5}(?:[0-9A-Fa-f]{2})}/status",
+		globApi.httpHandlerInstallStatus).Methods("POST")
+
+	s := r.PathPrefix("/v1").Subrouter()
+	s.Use(globApi.httpMiddlewareRequestLog)
+	s.Use(globApi.httpMiddlewareContentNegotiation)
 	s.Use(globApi.httpMiddlewareAPIAuthentication)
 
 	s.HandleFunc("/", globApi.httpHandlerRootV1).Methods("GET")
 
-	s.HandleFunc("/host/{mac:(?:[0-9A-Fa-f]{2}[:-]){5}(?:[0-9A-Fa-f]{2})}", globApi.httpHandlerHostGet).Methods("GET")
-	s.HandleFunc("/host", globApi.httpHandlerHostCreate).Methods("POST")
+	s.HandleFunc("/host/{mac:(?:[0-9A-Fa-f]{2}[:-]){5}(?:[0-9A-Fa-f]{2})}",
+		globApi.httpMiddlewareScope(apiScopeHostRead, globApi.httpHandlerHostGet)).Methods("GET")
+	s.HandleFunc("/host", globApi.httpMiddlewareScope(apiScopeHostCreate, globApi.httpHandlerHostCreate)).Methods("POST")
+
+	s.HandleFunc("/requests", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerRequestsGet)).Methods("GET")
+
+	s.HandleFunc("/queue", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerQueueGet)).Methods("GET")
+	s.HandleFunc("/queue/control", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerQueueControlGet)).Methods("GET")
+	s.HandleFunc("/queue/pause", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerQueuePause)).Methods("POST")
+	s.HandleFunc("/queue/resume", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerQueueResume)).Methods("POST")
+	s.HandleFunc("/queue/actions/{action:[a-z_]+}/pause",
+		globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerQueueActionPause)).Methods("POST")
+	s.HandleFunc("/queue/actions/{action:[a-z_]+}/resume",
+		globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerQueueActionResume)).Methods("POST")
+	s.HandleFunc("/queue/workers", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerQueueResize)).Methods("POST")
+
+	s.HandleFunc("/dead-jobs", globApi.httpMiddlewareScope(apiScopeJobRetry, globApi.httpHandlerDeadJobsGet)).Methods("GET")
+	s.HandleFunc("/dead-jobs/requeue", globApi.httpMiddlewareScope(apiScopeJobRetry, globApi.httpHandlerDeadJobsRequeue)).Methods("POST")
+
+	s.HandleFunc("/jobs/timings", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerJobTimingsGet)).Methods("GET")
+	s.HandleFunc("/job/{id:(?:[0-9a-f]{8}-)(?:[0-9a-f]{4}-){3}(?:[0-9a-f]{12})}",
+		globApi.httpMiddlewareScope(apiScopeHostRead, globApi.httpHandlerJobGet)).Methods("GET")
 
-	s.HandleFunc("/job/{id:(?:[0-9a-f]{8}-)(?:[0-9a-f]{4}-){3}(?:[0-9a-f]{12})}", globApi.httpHandlerJobGet).Methods("GET")
+	s.HandleFunc("/keys", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerKeysGet)).Methods("GET")
+	s.HandleFunc("/keys", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerKeyCreate)).Methods("POST")
+	s.HandleFunc("/keys/{id}/rotate", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerKeyRotate)).Methods("POST")
+	s.HandleFunc("/keys/{id}", globApi.httpMiddlewareScope(apiScopeClusterAdmin, globApi.httpHandlerKeyDelete)).Methods("DELETE")
 
 	s.HandleFunc("/test", globApi.httpHandlerTest).Methods("GET")
 
@@ -154,46 +330,77 @@ func (m *apiController) httpMiddlewareRequestLog(h http.Handler) http.Handler {
 	})
 }
 
-func (m *apiController) httpMiddlewareAPIAuthentication(h http.Handler) http.Handler {
+func (m *apiController) httpMiddlewareContentNegotiation(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		var req = context.Get(r, "internal_request").(*httpRequest)
 
-		var bodyBuf bytes.Buffer
-		bufSize, e := bodyBuf.ReadFrom(r.Body)
-		if !m.errorHandler(w, e, req) {
+		format, errCode := negotiateApiFormat(r)
+		if errCode != errNotError {
+			req.newError(errCode)
+			m.respondJSON(w, req, nil, 0)
 			return
 		}
-		r.Body.Close()
 
-		mac := hmac.New(sha256.New, []byte(globConfig.Base.Api.SignSecret))
-		macSize, e := mac.Write(bodyBuf.Bytes())
+		req.format = format
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (m *apiController) httpMiddlewareAPIAuthentication(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var req = context.Get(r, "internal_request").(*httpRequest)
+
+		var bodyBuf bytes.Buffer
+		_, e := bodyBuf.ReadFrom(r.Body)
 		if !m.errorHandler(w, e, req) {
 			return
 		}
+		r.Body.Close()
 
-		if r.ContentLength != bufSize || r.ContentLength != int64(macSize) {
-			globLogger.Warn().Msg("[API]: Different sizes in request, buffer and mac!")
+		key, errCode := m.verifyClientCertificate(r)
+		if key == nil && errCode == errNotError {
+			key, errCode = m.verifyRequestSignature(r, bodyBuf.Bytes())
 		}
 
-		expectedMAC := mac.Sum(nil)
-		receivedMAC, e := hex.DecodeString(strings.Split(
-			r.Header.Get("Authorization"), " ")[1])
-		if !m.errorHandler(w, e, req) {
+		if errCode != errNotError {
+			req.newError(errCode)
+			m.respondJSON(w, req, nil, 0)
 			return
 		}
 
-		if !hmac.Equal(expectedMAC, receivedMAC) {
-			req.newError(errApiNotAuthorized)
+		req.api_key = key.Id
+
+		if !key.isAllowedAddress(req.srcip) {
+			globLogger.Warn().Str("key_id", key.Id).Str("srcip", req.srcip).Msg("[API]: The key is not allowed from this address!")
+			req.newError(errApiAddressForbidden)
 			m.respondJSON(w, req, nil, 0)
 			return
 		}
 
+		context.Set(r, "api_key", key)
+
 		r.Body = ioutil.NopCloser(bytes.NewReader(bodyBuf.Bytes()))
 		h.ServeHTTP(w, r)
 	})
 }
 
+func (m *apiController) httpMiddlewareScope(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var req = context.Get(r, "internal_request").(*httpRequest)
+
+		if key, ok := context.Get(r, "api_key").(*apiKey); !ok || !key.hasScope(scope) {
+			req.newError(errApiScopeForbidden)
+			m.respondJSON(w, req, nil, 0)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
 func (m *apiController) httpHandlerRootV1(w http.ResponseWriter, r *http.Request) {}
 
 func (m *apiController) httpHandlerJobGet(w http.ResponseWriter, r *http.Request) {
@@ -214,206 +421,971 @@ func (m *apiController) httpHandlerJobGet(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	jbErrs, err := jb.getResponseErrors()
+	m.respondJSON(w, req, newApiDocument(r, "errors", "transitions").setPrimary(jb), http.StatusOK)
+}
+
+// timings are aggregated by the job action for jobs created in the period (24h by default):
+func (m *apiController) httpHandlerJobTimingsGet(w http.ResponseWriter, r *http.Request) {
+
+	var req = context.Get(r, "internal_request").(*httpRequest)
+
+	var period = 24 * time.Hour
+	if v := r.URL.Query().Get("filter[period]"); v != "" {
+		var e error
+		if period, e = time.ParseDuration(v); e != nil || period <= 0 {
+			req.appendAppError(newAppError(errApiInvalidFilter).setParameter("filter[period]").log(e, "Could not parse the period filter!"))
+			m.respondJSON(w, req, nil, 0)
+			return
+		}
+	}
+
+	timings, err := getJobTimings(time.Now().Add(-period))
 	if err != nil {
 		req.appendAppError(err)
 		m.respondJSON(w, req, nil, 0)
 		return
 	}
 
-	jbs := append([]*attributesJob{}, &attributesJob{
-		Id:         jb.id,
-		Action:     jb.getHumanAction(),
-		State:      jb.getHumanStateDetails(),
-		Errors:     jbErrs,
-		Updated_At: jb.updated_at.Format(time.RFC3339),
-		Created_At: jb.created_at.Format(time.RFC3339),
-	})
+	var rs = []apiResource{}
+	for _, v := range timings {
+		rs = append(rs, v)
+	}
 
-	m.respondJSON(w, req, &responseData{
-		Type: "job",
-		Id:   req.id,
-		Attributes: &dataAttributes{
-			Jobs: jbs,
-		},
-	}, http.StatusOK)
+	m.respondJSON(w, req, newApiDocument(r).setCollection(rs), http.StatusOK)
 }
 
-func (m *apiController) httpHandlerHostGet(w http.ResponseWriter, r *http.Request) {
+func (m *apiController) httpHandlerRequestsGet(w http.ResponseWriter, r *http.Request) {
+
 	var req = context.Get(r, "internal_request").(*httpRequest)
-	vars := mux.Vars(r)
 
-	if vars["mac"] == "" {
-		req.newError(errApiUnknownApiFormat)
+	filter, err := parseRequestFilter(r)
+	if err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	reqs, err := getRequestsByFilter(filter)
+	if err != nil {
+		req.appendAppError(err)
 		m.respondJSON(w, req, nil, 0)
 		return
 	}
 
-	// TODO: get host by MAC from host.go
+	var rs = []apiResource{}
+	for _, v := range reqs {
+		rs = append(rs, v)
+	}
+
+	m.respondJSON(w, req, newApiDocument(r, "errors").setCollection(rs), http.StatusOK)
 }
 
-func (m *apiController) httpHandlerHostCreate(w http.ResponseWriter, r *http.Request) {
+func (m *apiController) httpHandlerQueueGet(w http.ResponseWriter, r *http.Request) {
 
 	var req = context.Get(r, "internal_request").(*httpRequest)
 
-	var postRequest *apiHostPostRequest
-	rspBody, e := ioutil.ReadAll(r.Body)
-	if !m.errorHandler(w, e, req) {
+	status, err := globQueue.requestStatus()
+	if err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
 		return
 	}
-	e = json.Unmarshal(rspBody, &postRequest)
-	if !m.errorHandler(w, e, req) {
-		return
+
+	m.respondJSON(w, req, newApiDocument(r).setPrimary(status), http.StatusOK)
+}
+
+func (m *apiController) httpHandlerQueueControlGet(w http.ResponseWriter, r *http.Request) {
+
+	var req = context.Get(r, "internal_request").(*httpRequest)
+	m.respondJSON(w, req, newApiDocument(r).setPrimary(getQueueControl()), http.StatusOK)
+}
+
+func (m *apiController) httpHandlerQueuePause(w http.ResponseWriter, r *http.Request) {
+	m.changeQueueControl(w, r, auditActQueuePaused, "queue", func(ctl *queueControl) *appError {
+		ctl.Paused = true
+		return nil
+	})
+}
+
+func (m *apiController) httpHandlerQueueResume(w http.ResponseWriter, r *http.Request) {
+	m.changeQueueControl(w, r, auditActQueueResumed, "queue", func(ctl *queueControl) *appError {
+		ctl.Paused = false
+		return nil
+	})
+}
+
+func (m *apiController) httpHandlerQueueActionPause(w http.ResponseWriter, r *http.Request) {
+	var action = mux.Vars(r)["action"]
+	m.changeQueueControl(w, r, auditActQueueActPaused, action, func(ctl *queueControl) *appError {
+		if _, ok := getJobActionByName(action); !ok {
+			return newAppError(errQueueControlInvalid).setPointer("/action").log(nil, "Could not find the job action!")
+		}
+		ctl.setActionPaused(action, true)
+		return nil
+	})
+}
+
+func (m *apiController) httpHandlerQueueActionResume(w http.ResponseWriter, r *http.Request) {
+	var action = mux.Vars(r)["action"]
+	m.changeQueueControl(w, r, auditActQueueActResumed, action, func(ctl *queueControl) *appError {
+		if _, ok := getJobActionByName(action); !ok {
+			return newAppError(errQueueControlInvalid).setPointer("/action").log(nil, "Could not find the job action!")
+		}
+		ctl.setActionPaused(action, false)
+		return nil
+	})
+}
+
+// the pool of the given node (the serving node by default) is resized to ?count=:
+func (m *apiController) httpHandlerQueueResize(w http.ResponseWriter, r *http.Request) {
+
+	var node = r.URL.Query().Get("node")
+	if node == "" {
+		node = globRaftStore.LocalId()
 	}
 
-	switch {
-	case postRequest.Data == nil:
-		fallthrough
-	case postRequest.Data.Type == "":
-		fallthrough
-	case postRequest.Data.Attributes == nil:
-		fallthrough
-	case postRequest.Data.Attributes.Host == nil:
-		fallthrough
-	case postRequest.Data.Attributes.Host.Ipmi_Address == "":
-		fallthrough
-	case postRequest.Data.Attributes.Ports == nil:
-		fallthrough
-	case len(postRequest.Data.Attributes.Ports) == 0:
-		fallthrough
-	case false: // something impossible
-		req.newError(errApiUnknownApiFormat)
+	m.changeQueueControl(w, r, auditActQueueWorkersSized, node, func(ctl *queueControl) *appError {
+		count, e := strconv.Atoi(r.URL.Query().Get("count"))
+		if e != nil || count < 0 || count > queueMaxWorkers {
+			return newAppError(errQueueControlInvalid).setParameter("count").log(e, "The workers count must be in range 0..256!")
+		}
+
+		var known bool
+		for _, v := range getQueueControlNodes() {
+			known = known || v == node
+		}
+
+		if !known {
+			return newAppError(errQueueControlInvalid).setParameter("node").log(nil, "Could not find the given raft node!")
+		}
+
+		ctl.Workers[node] = count
+		return nil
+	})
+}
+
+// every change is saved in the raft store and in the audit log of the request:
+func (m *apiController) changeQueueControl(w http.ResponseWriter, r *http.Request, action, subject string, change func(*queueControl) *appError) {
+
+	var req = context.Get(r, "internal_request").(*httpRequest)
+
+	var ctl = getQueueControl()
+	if err := change(ctl); err != nil {
+		req.appendAppError(err)
 		m.respondJSON(w, req, nil, 0)
 		return
-	case postRequest.Data.Type != "host":
-		req.newError(errApiUnknownType)
+	}
+
+	if err := ctl.save(); err != nil {
+		req.appendAppError(err)
 		m.respondJSON(w, req, nil, 0)
 		return
 	}
 
-	// test given ipmi && mac addresses:
-	var ipmiAddr *string = &postRequest.Data.Attributes.Host.Ipmi_Address
-	var macAddrs []*string
+	countQueueControlChange(action)
+	globLogger.Warn().Str("change", action).Str("subject", subject).Str("request_id", req.id).Msg("The queue control state has been changed")
 
-	for _, v := range postRequest.Data.Attributes.Ports {
-		if v.Mac == "" {
-			req.appendAppError(newAppError(errPortsAbnormalMac))
-			m.respondJSON(w, req, nil, 0)
-			return
-		}
-		macAddrs = append(macAddrs, &v.Mac)
+	if err := newAuditEvent(req.id, action, subject).save(); err != nil {
+		req.appendAppError(err)
 	}
 
-	// parse given ipmi && mac addresses:
+	m.respondJSON(w, req, newApiDocument(r).setPrimary(ctl), http.StatusOK)
+}
 
-	var host = newHost()
-	if e := host.parseIpmiAddress(ipmiAddr); e != nil {
-		req.appendAppError(e)
-		m.respondJSON(w, req, nil, 0)
+// Metrics are given in the prometheus text format. Scrapers can't sign requests,
+// so the route is out of /v1 and is authorized by Api.Metrics only.
+func (m *apiController) httpHandlerMetricsGet(w http.ResponseWriter, r *http.Request) {
+
+	if !isMetricsAllowed(r) {
+		globLogger.Warn().Str("srcip", getRemoteAddress(r)).Msg("[API]: The metrics request is not authorized!")
+		http.Error(w, apiErrorsTitle[errApiNotAuthorized], http.StatusUnauthorized)
 		return
 	}
 
-	var ports []*basePort
-	for _, v := range macAddrs {
-		if port, e := newPortWithMAC(v); e != nil {
-			req.appendAppError(e)
-		} else {
-			ports = append(ports, port)
+	status, err := globQueue.requestStatus()
+	if err != nil {
+		http.Error(w, apiErrorsTitle[err.code], err.getHttpStatusCode())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write(status.getMetrics())
+}
+
+func isMetricsAllowed(r *http.Request) bool {
+
+	var cfg = globConfig.Base.Api.Metrics
+
+	if cfg.Token != "" {
+		if subtle.ConstantTimeCompare([]byte(getBearerToken(r)), []byte(cfg.Token)) == 1 {
+			return true
 		}
 	}
 
-	if len(ports) == 0 {
+	return len(cfg.Cidrs) != 0 && (&apiKey{Cidrs: cfg.Cidrs}).isAllowedAddress(getRemoteAddress(r))
+}
+
+func (m *apiController) httpHandlerDeadJobsGet(w http.ResponseWriter, r *http.Request) {
+
+	var req = context.Get(r, "internal_request").(*httpRequest)
+
+	filter, err := parseDeadJobFilter(r)
+	if err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	djs, err := getDeadJobsByFilter(filter)
+	if err != nil {
+		req.appendAppError(err)
 		m.respondJSON(w, req, nil, 0)
 		return
 	}
 
-	// add jobs and respond:
-	var reqJobs []*queueJob
+	var rs = []apiResource{}
+	for _, v := range djs {
+		rs = append(rs, v)
+	}
+
+	m.respondJSON(w, req, newApiDocument(r).setCollection(rs), http.StatusOK)
+}
+
+// bulk requeue uses the same filters as the dead-letter list:
+func (m *apiController) httpHandlerDeadJobsRequeue(w http.ResponseWriter, r *http.Request) {
+
+	var req = context.Get(r, "internal_request").(*httpRequest)
 
-	if job, err := newQueueJob(&req.id, jobActHostCreate); err != nil {
+	filter, err := parseDeadJobFilter(r)
+	if err != nil {
 		req.appendAppError(err)
 		m.respondJSON(w, req, nil, 0)
 		return
-	} else {
-		job.setPayload(&map[string]interface{}{
-			"job_payload_host": host})
-		reqJobs = append(reqJobs, job)
 	}
 
-	for _, v := range ports {
-		if job, err := newQueueJob(&req.id, jobActRsviewParse); err != nil {
-			req.appendAppError(err)
-			m.respondJSON(w, req, nil, 0)
-			return
-		} else {
-			job.setPayload(&map[string]interface{}{
-				"job_payload_port": v,
-			})
-			reqJobs = append(reqJobs, job)
-		}
-	}
-
-	var jbResps []*attributesJob
-	for _, v := range reqJobs {
-		jbResps = append(jbResps, &attributesJob{
-			Id:         v.id,
-			Action:     v.getHumanAction(),
-			Created_At: v.created_at.Format(time.RFC3339),
-		})
-
-		v.addToQueue()
-	}
-
-	m.respondJSON(w, req, &responseData{
-		Type: "job",
-		Id:   req.id,
-		Attributes: &dataAttributes{
-			Host: &attributesHost{
-				Host: &hostsHost{ // XXX WTF?! Rename it!
-					Id: host.id,
-				},
-			},
-			Jobs: jbResps,
-		},
-	}, http.StatusCreated)
+	djs, err := requeueDeadJobs(filter, req.id)
+	if err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	var rs = []apiResource{}
+	for _, v := range djs {
+		rs = append(rs, v)
+	}
+
+	m.respondJSON(w, req, newApiDocument(r).setCollection(rs), http.StatusOK)
 }
 
-func (m *apiController) errorHandler(w http.ResponseWriter, e error, req *httpRequest) bool {
-	if e == nil {
-		return true
+func (m *apiController) httpHandlerHostGet(w http.ResponseWriter, r *http.Request) {
+	var req = context.Get(r, "internal_request").(*httpRequest)
+	vars := mux.Vars(r)
+
+	if vars["mac"] == "" {
+		req.newError(errApiUnknownApiFormat)
+		m.respondJSON(w, req, nil, 0)
+		return
 	}
 
-	req.newError(errInternalCommonError)
-	globLogger.Error().Err(e).Msg("[API]: Abnormal function result!")
+	host, err := getHostByMac(vars["mac"])
+	if err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
 
-	m.respondJSON(w, req, nil, 0)
-	return false
+	if host == nil {
+		req.appendAppError(newAppError(errHostsNotFound).log(nil, "Couldn't find a host with the requested mac!"))
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	// the kickstart url is given to the signed requests only:
+	tsk, err := getTaskByHost(host.id)
+	if err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	if tsk != nil && tsk.isActive(time.Now()) {
+		host.kickstart_url = tsk.getKickstartUrl()
+	}
+
+	m.respondJSON(w, req, newApiDocument(r).setPrimary(host), http.StatusOK)
 }
 
-func (m *apiController) respondJSON(w http.ResponseWriter, req *httpRequest, payloadData *responseData, status int) {
-	//
-	var rspPayload = &apiResponse{
-		Data: payloadData,
-		Meta: &responseMeta{
-			ApiVersion: appVersion,
-			Authors: []string{
-				"vadimka_kom"},
-			Copyright: "Copyright 2018 Mindhunter and CO."},
-		Links: &responseLinks{
-			Self: req.link},
-		JsonApi: &responseJsonApi{
-			Version: "1.0"},
+func (m *apiController) httpHandlerHostCreate(w http.ResponseWriter, r *http.Request) {
+
+	var req = context.Get(r, "internal_request").(*httpRequest)
+
+	var postRequest *apiHostPostRequest
+	rspBody, e := ioutil.ReadAll(r.Body)
+	if !m.errorHandler(w, e, req) {
+		return
+	}
+
+	if req.format == apiFormatJson {
+		var flatRequest *hostFlatRequest
+		e = json.Unmarshal(rspBody, &flatRequest)
+		postRequest = flatRequest.toPostRequest()
+	} else {
+		e = json.Unmarshal(rspBody, &postRequest)
 	}
 
-	if rspPayload.Errors = req.saveErrors().respondApiErrors(); req.status > status {
-		status = req.status
-		rspPayload.Data = nil
+	if !m.errorHandler(w, e, req) {
+		return
 	}
 
-	req.status = status // TODO: refactor
+	// validate the request document and report every problem at once:
+	host, ports, ok := m.validateHostRequest(req, postRequest)
+	if !ok {
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/vnd.api+json")
-	w.WriteHeader(status)
+	// the PTR, TLD and naming checks go before the insert, so a mis-typed PTR can't create a bogus host:
+	if _, err := host.resolveIpmiHostname(r.Context()); err != nil {
+		if err.getHttpStatusCode() < http.StatusInternalServerError {
+			err.setPointer(requestPointer(req.format, "/data/attributes/host/ipmi_address"))
+		}
 
-	json.NewEncoder(w).Encode(rspPayload)
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	// the request is valid, so the host and its ports could be saved:
+	if err := host.getOrCreate(); err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	for _, v := range ports {
+		if err := v.getOrCreate(); err != nil {
+			req.appendAppError(err)
+			m.respondJSON(w, req, nil, 0)
+			return
+		}
+	}
+
+	// operator reinstalls could be prioritized over bulk imports:
+	priority, _ := parseJobPriority(postRequest.Data.Attributes.Priority)
+
+	reqJobs, err := newHostCreateJobs(&req.id, priority, host, ports)
+	if err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	// the jobs will be assigned to cluster nodes by the raft leader:
+	host.ports, host.jobs = ports, reqJobs
+	m.respondJSON(w, req, newApiDocument(r, "jobs").setPrimary(host), http.StatusCreated)
+}
+
+func (m *apiController) httpHandlerKeysGet(w http.ResponseWriter, r *http.Request) {
+
+	var req = context.Get(r, "internal_request").(*httpRequest)
+
+	keys, err := getApiKeys()
+	if err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	var rs = []apiResource{}
+	for _, v := range keys {
+		rs = append(rs, v)
+	}
+
+	m.respondJSON(w, req, newApiDocument(r).setCollection(rs), http.StatusOK)
+}
+
+func (m *apiController) httpHandlerKeyCreate(w http.ResponseWriter, r *http.Request) {
+
+	var req = context.Get(r, "internal_request").(*httpRequest)
+
+	var postRequest *apiKeyPostRequest
+	rspBody, e := ioutil.ReadAll(r.Body)
+	if !m.errorHandler(w, e, req) {
+		return
+	}
+
+	if req.format == apiFormatJson {
+		var flatRequest *keyFlatRequest
+		e = json.Unmarshal(rspBody, &flatRequest)
+		postRequest = flatRequest.toPostRequest()
+	} else {
+		e = json.Unmarshal(rspBody, &postRequest)
+	}
+
+	if !m.errorHandler(w, e, req) {
+		return
+	}
+
+	var invalid = func(e uint8, pointer string) {
+		req.appendAppError(newAppError(e).setPointer(requestPointer(req.format, pointer)))
+		m.respondJSON(w, req, nil, 0)
+	}
+
+	switch {
+	case postRequest == nil || postRequest.Data == nil:
+		invalid(errApiUnknownApiFormat, "/data")
+		return
+	case postRequest.Data.Type != apiTypeKey:
+		invalid(errApiUnknownType, "/data/type")
+		return
+	case postRequest.Data.Attributes == nil:
+		invalid(errApiUnknownApiFormat, "/data/attributes")
+		return
+	}
+
+	var attributes = postRequest.Data.Attributes
+
+	key, err := newApiKey(postRequest.Data.Id, attributes.Scopes, attributes.Cidrs)
+	if err != nil {
+		req.appendAppError(err.setPointer(requestPointer(req.format, err.srcPointer)))
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	if err = newAuditEvent(req.id, auditActKeyIssued, key.Id).save(); err != nil {
+		req.appendAppError(err)
+	}
+
+	m.respondJSON(w, req, newApiDocument(r).setPrimary(key), http.StatusCreated)
+}
+
+// the rotation grace period could be redefined with ?grace=1h30m:
+func (m *apiController) httpHandlerKeyRotate(w http.ResponseWriter, r *http.Request) {
+
+	var req = context.Get(r, "internal_request").(*httpRequest)
+
+	var grace = globConfig.Base.Api.KeyRotationGrace
+	if v := r.URL.Query().Get("grace"); v != "" {
+		var e error
+		if grace, e = time.ParseDuration(v); e != nil || grace < 0 {
+			req.appendAppError(newAppError(errApiKeyInvalid).setParameter("grace").log(e, "Could not parse the given grace period!"))
+			m.respondJSON(w, req, nil, 0)
+			return
+		}
+	}
+
+	key := m.getRequestedKey(w, r, req)
+	if key == nil {
+		return
+	}
+
+	if err := key.rotate(grace); err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	if err := newAuditEvent(req.id, auditActKeyRotated, key.Id).save(); err != nil {
+		req.appendAppError(err)
+	}
+
+	m.respondJSON(w, req, newApiDocument(r).setPrimary(key), http.StatusOK)
+}
+
+func (m *apiController) httpHandlerKeyDelete(w http.ResponseWriter, r *http.Request) {
+
+	var req = context.Get(r, "internal_request").(*httpRequest)
+
+	key := m.getRequestedKey(w, r, req)
+	if key == nil {
+		return
+	}
+
+	if err := key.revoke(); err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return
+	}
+
+	if err := newAuditEvent(req.id, auditActKeyRevoked, key.Id).save(); err != nil {
+		req.appendAppError(err)
+	}
+
+	m.respondJSON(w, req, newApiDocument(r).setPrimary(key), http.StatusOK)
+}
+
+func getBearerToken(r *http.Request) string {
+
+	var header = r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// The kickstart is given to the booting host by the secret url of the active install task. The url
+// is given to the API clients in the host attributes, so it could be put into the boot config.
+// The kickstart has the enrollment token of the task instead of the sign secret.
+func (m *apiController) httpHandlerKickstartGet(w http.ResponseWriter, r *http.Request) {
+
+	mac, e := net.ParseMAC(mux.Vars(r)["mac"])
+	if e != nil {
+		http.Error(w, apiErrorsTitle[errPortsAbnormalMac], apiErrorsStatus[errPortsAbnormalMac])
+		return
+	}
+
+	tsk, err := getTaskByMac(mac.String())
+	if err != nil {
+		http.Error(w, apiErrorsTitle[err.code], err.getHttpStatusCode())
+		return
+	}
+
+	// wrong nonces are answered as the missing task, so the active tasks could not be found by MACs:
+	if tsk == nil || !tsk.isActive(time.Now()) || !tsk.isKickstartNonce(mux.Vars(r)["nonce"]) {
+		if tsk != nil && tsk.isActive(time.Now()) {
+			globLogger.Warn().Str("mac", mac.String()).Str("srcip", getRemoteAddress(r)).
+				Msg("[API]: The kickstart has been requested with an invalid nonce!")
+		}
+
+		http.Error(w, apiErrorsTitle[errTasksNotFound], apiErrorsStatus[errTasksNotFound])
+		return
+	}
+
+	globLogger.Info().Str("task_id", tsk.id).Str("mac", tsk.mac).Str("srcip", getRemoteAddress(r)).
+		Msg("[API]: The kickstart has been given to the host")
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(tsk.kickstart))
+}
+
+// the token is accepted for the host of its task only, the final statuses expire the token:
+func (m *apiController) httpHandlerInstallStatus(w http.ResponseWriter, r *http.Request) {
+
+	mac, e := net.ParseMAC(mux.Vars(r)["mac"])
+	if e != nil {
+		http.Error(w, apiErrorsTitle[errPortsAbnormalMac], apiErrorsStatus[errPortsAbnormalMac])
+		return
+	}
+
+	var token = getBearerToken(r)
+	if token == "" {
+		http.Error(w, apiErrorsTitle[errTasksTokenInvalid], apiErrorsStatus[errTasksTokenInvalid])
+		return
+	}
+
+	tsk, err := getTaskByToken(token)
+	if err != nil {
+		http.Error(w, apiErrorsTitle[err.code], err.getHttpStatusCode())
+		return
+	}
+
+	if tsk == nil || !tsk.isActive(time.Now()) || tsk.mac != mac.String() {
+		globLogger.Warn().Str("mac", mac.String()).Str("srcip", getRemoteAddress(r)).
+			Msg("[API]: The install status has been reported with an invalid enrollment token!")
+		http.Error(w, apiErrorsTitle[errTasksTokenInvalid], apiErrorsStatus[errTasksTokenInvalid])
+		return
+	}
+
+	status, err := parseInstallStatus(r)
+	if err != nil {
+		http.Error(w, apiErrorsTitle[err.code], err.getHttpStatusCode())
+		return
+	}
+
+	// The audit event is saved before the final status, so the host could report the status again
+	// with the same token if the event has not been saved. Repeated reports have the same event id.
+	if action, ok := installStatusAuditActs[status.Status]; ok {
+		var event = newAuditEvent(tsk.requested_by, action, tsk.mac)
+		event.id = uuid.NewV5(uuid.NamespaceOID, tsk.id+":"+action).String()
+
+		if _, err = event.saveOnce(); err != nil {
+			http.Error(w, apiErrorsTitle[err.code], err.getHttpStatusCode())
+			return
+		}
+	}
+
+	if err = tsk.setStatus(status.Status); err != nil {
+		http.Error(w, apiErrorsTitle[err.code], err.getHttpStatusCode())
+		return
+	}
+
+	globLogger.Info().Str("task_id", tsk.id).Str("mac", tsk.mac).Str("status", status.Status).Str("message", status.Message).
+		Msg("[API]: The install status has been reported")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *apiController) getRequestedKey(w http.ResponseWriter, r *http.Request, req *httpRequest) *apiKey {
+
+	key, err := getApiKey(mux.Vars(r)["id"])
+	if err != nil {
+		req.appendAppError(err)
+		m.respondJSON(w, req, nil, 0)
+		return nil
+	}
+
+	if key == nil {
+		req.appendAppError(newAppError(errApiKeyNotFound).log(nil, "Couldn't find an api key with the requested id!"))
+		m.respondJSON(w, req, nil, 0)
+		return nil
+	}
+
+	return key
+}
+
+// The document is validated without side effects, so every problem is reported at once.
+// The parsed host and ports are returned for the valid document.
+func (m *apiController) validateHostRequest(req *httpRequest, postRequest *apiHostPostRequest) (*baseHost, []*basePort, bool) {
+
+	var invalid = func(e uint8, pointer string) {
+		req.appendAppError(newAppError(e).setPointer(requestPointer(req.format, pointer)))
+	}
+
+	// internal errors have nothing to do with the request document:
+	var appendFieldError = func(err *appError, pointer string) {
+		if err.getHttpStatusCode() < http.StatusInternalServerError {
+			err.setPointer(requestPointer(req.format, pointer))
+		}
+		req.appendAppError(err)
+	}
+
+	if postRequest == nil || postRequest.Data == nil {
+		invalid(errApiUnknownApiFormat, "/data")
+		return nil, nil, false
+	}
+
+	switch postRequest.Data.Type {
+	case apiTypeHost:
+	case "":
+		invalid(errApiUnknownApiFormat, "/data/type")
+	default:
+		invalid(errApiUnknownType, "/data/type")
+	}
+
+	var attributes = postRequest.Data.Attributes
+	if attributes == nil {
+		invalid(errApiUnknownApiFormat, "/data/attributes")
+		return nil, nil, false
+	}
+
+	var host = newHost()
+
+	switch {
+	case attributes.Host == nil:
+		invalid(errApiUnknownApiFormat, "/data/attributes/host")
+	case attributes.Host.Ipmi_Address == "":
+		invalid(errApiUnknownApiFormat, "/data/attributes/host/ipmi_address")
+	default:
+		if err := host.parseIpmiAddress(&attributes.Host.Ipmi_Address); err != nil {
+			appendFieldError(err, "/data/attributes/host/ipmi_address")
+		}
+	}
+
+	if len(attributes.Ports) == 0 {
+		invalid(errApiUnknownApiFormat, "/data/attributes/ports")
+	}
+
+	if _, ok := parseJobPriority(attributes.Priority); !ok {
+		invalid(errJobsInvalidPriority, "/data/attributes/priority")
+	}
+
+	var ports []*basePort
+	for i, v := range attributes.Ports {
+		switch {
+		case v == nil:
+			invalid(errApiUnknownApiFormat, fmt.Sprintf("/data/attributes/ports/%d", i))
+		case v.Mac == "":
+			invalid(errPortsAbnormalMac, fmt.Sprintf("/data/attributes/ports/%d/mac", i))
+		default:
+			if port, err := newPortWithMAC(&v.Mac); err != nil {
+				appendFieldError(err, fmt.Sprintf("/data/attributes/ports/%d/mac", i))
+			} else {
+				ports = append(ports, port)
+			}
+		}
+	}
+
+	if len(req.errors) != 0 {
+		return nil, nil, false
+	}
+
+	return host, ports, true
+}
+
+func (m *apiController) errorHandler(w http.ResponseWriter, e error, req *httpRequest) bool {
+	if e == nil {
+		return true
+	}
+
+	req.newError(errInternalCommonError)
+	globLogger.Error().Err(e).Msg("[API]: Abnormal function result!")
+
+	m.respondJSON(w, req, nil, 0)
+	return false
+}
+
+func (m *apiController) respondJSON(w http.ResponseWriter, req *httpRequest, payload *apiDocument, status int) {
+
+	var data interface{}
+	var included []*responseData
+
+	if payload != nil {
+		var err *appError
+		if req.format == apiFormatJson {
+			data, err = payload.buildFlat()
+		} else {
+			data, included, err = payload.build()
+		}
+
+		if err != nil {
+			req.appendAppError(err)
+		}
+	}
+
+	var rspErrors = req.saveErrors().respondApiErrors()
+	if req.status > status {
+		status = req.status
+		data, included = nil, nil
+	}
+
+	req.status = status // TODO: refactor
+
+	var rspPayload interface{}
+
+	switch req.format {
+	case apiFormatJson:
+		w.Header().Set("Content-Type", mimeJson)
+		rspPayload = &apiFlatResponse{
+			Data:   data,
+			Errors: rspErrors,
+		}
+	default:
+		w.Header().Set("Content-Type", mimeJsonApi)
+		rspPayload = &apiResponse{
+			Data:     data,
+			Included: included,
+			Errors:   rspErrors,
+			Meta: &responseMeta{
+				ApiVersion: appVersion,
+				Authors: []string{
+					"vadimka_kom"},
+				Copyright: "Copyright 2018 Mindhunter and CO."},
+			Links: &responseLinks{
+				Self: req.link},
+			JsonApi: &responseJsonApi{
+				Version: "1.0"},
+		}
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rspPayload)
+}
+
+func (m *hostFlatRequest) toPostRequest() *apiHostPostRequest {
+
+	var postRequest = new(apiHostPostRequest)
+	if m == nil {
+		return postRequest
+	}
+
+	postRequest.Data = &hostRequestData{
+		Type: apiTypeHost,
+		Attributes: &hostRequestAttributes{
+			Ports:    m.Ports,
+			Priority: m.Priority,
+		},
+	}
+
+	if m.Ipmi_Address != "" {
+		postRequest.Data.Attributes.Host = &hostRequestHost{
+			Ipmi_Address: m.Ipmi_Address,
+		}
+	}
+
+	return postRequest
+}
+
+func (m *keyFlatRequest) toPostRequest() *apiKeyPostRequest {
+
+	var postRequest = new(apiKeyPostRequest)
+	if m == nil {
+		return postRequest
+	}
+
+	postRequest.Data = &keyRequestData{
+		Type: apiTypeKey,
+		Id:   m.Id,
+		Attributes: &keyRequestAttributes{
+			Scopes: m.Scopes,
+			Cidrs:  m.Cidrs,
+		},
+	}
+
+	return postRequest
+}
+
+// Pointers are written for JSON:API documents, plain JSON requests have flat attributes:
+func requestPointer(format uint8, pointer string) string {
+
+	if format != apiFormatJson {
+		return pointer
+	}
+
+	switch {
+	case pointer == "/data/id":
+		return "/id"
+	case strings.HasPrefix(pointer, "/data/attributes/host/"):
+		return "/" + strings.TrimPrefix(pointer, "/data/attributes/host/")
+	case pointer == "/data/attributes/host":
+		return "/ipmi_address"
+	case strings.HasPrefix(pointer, "/data/attributes/"):
+		return strings.TrimPrefix(pointer, "/data/attributes")
+	}
+
+	return ""
+}
+
+// Request audit filters - filter[srcip], filter[url], filter[status], filter[user_agent], filter[api_key],
+// filter[since] and filter[until] (RFC3339), page[limit] and page[offset]:
+func parseRequestFilter(r *http.Request) (*requestFilter, *appError) {
+
+	var e error
+	var query = r.URL.Query()
+	var filter = newRequestFilter()
+
+	filter.srcip = query.Get("filter[srcip]")
+	filter.url = query.Get("filter[url]")
+	filter.user_agent = query.Get("filter[user_agent]")
+	filter.api_key = query.Get("filter[api_key]")
+
+	if v := query.Get("filter[status]"); v != "" {
+		if filter.status, e = strconv.Atoi(v); e != nil {
+			return nil, newAppError(errApiInvalidFilter).setParameter("filter[status]").log(e, "Could not parse the status filter!")
+		}
+	}
+
+	if v := query.Get("filter[since]"); v != "" {
+		if filter.since, e = time.Parse(time.RFC3339, v); e != nil {
+			return nil, newAppError(errApiInvalidFilter).setParameter("filter[since]").log(e, "Could not parse the since filter!")
+		}
+	}
+
+	if v := query.Get("filter[until]"); v != "" {
+		if filter.until, e = time.Parse(time.RFC3339, v); e != nil {
+			return nil, newAppError(errApiInvalidFilter).setParameter("filter[until]").log(e, "Could not parse the until filter!")
+		}
+	}
+
+	if v := query.Get("page[limit]"); v != "" {
+		if filter.limit, e = strconv.Atoi(v); e != nil || filter.limit <= 0 || filter.limit > 1000 {
+			return nil, newAppError(errApiInvalidFilter).setParameter("page[limit]").log(e, "The page limit must be in range 1..1000!")
+		}
+	}
+
+	if v := query.Get("page[offset]"); v != "" {
+		if filter.offset, e = strconv.Atoi(v); e != nil || filter.offset < 0 {
+			return nil, newAppError(errApiInvalidFilter).setParameter("page[offset]").log(e, "Could not parse the page offset!")
+		}
+	}
+
+	return filter, nil
+}
+
+// Dead-letter filters - filter[action] (handler name), filter[code] (error code), filter[job_id],
+// page[limit] and page[offset]:
+func parseDeadJobFilter(r *http.Request) (*deadJobFilter, *appError) {
+
+	var query = r.URL.Query()
+	var filter = newDeadJobFilter()
+
+	filter.job_id = query.Get("filter[job_id]")
+
+	if v := query.Get("filter[action]"); v != "" {
+		action, ok := getJobActionByName(v)
+		if !ok {
+			return nil, newAppError(errApiInvalidFilter).setParameter("filter[action]").log(nil, "Could not find the job action!")
+		}
+		filter.action = &action
+	}
+
+	if v := query.Get("filter[code]"); v != "" {
+		code, e := strconv.ParseUint(v, 10, 8)
+		if e != nil {
+			return nil, newAppError(errApiInvalidFilter).setParameter("filter[code]").log(e, "Could not parse the error code filter!")
+		}
+		var errCode = uint8(code)
+		filter.code = &errCode
+	}
+
+	var e error
+	if v := query.Get("page[limit]"); v != "" {
+		if filter.limit, e = strconv.Atoi(v); e != nil || filter.limit <= 0 || filter.limit > 1000 {
+			return nil, newAppError(errApiInvalidFilter).setParameter("page[limit]").log(e, "The page limit must be in range 1..1000!")
+		}
+	}
+
+	if v := query.Get("page[offset]"); v != "" {
+		if filter.offset, e = strconv.Atoi(v); e != nil || filter.offset < 0 {
+			return nil, newAppError(errApiInvalidFilter).setParameter("page[offset]").log(e, "Could not parse the page offset!")
+		}
+	}
+
+	return filter, nil
+}
+
+// The request format is taken from Content-Type (if the request has it) or from Accept.
+// JSON:API is used by default for backward compatibility.
+func negotiateApiFormat(r *http.Request) (uint8, uint8) {
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		if format, ok := parseApiMediaType(contentType, true); ok {
+			return format, errNotError
+		}
+		return apiFormatJsonApi, errApiUnsupportedMedia
+	}
+
+	var accept = r.Header.Get("Accept")
+	if accept == "" {
+		return apiFormatJsonApi, errNotError
+	}
+
+	for _, v := range strings.Split(accept, ",") {
+		if format, ok := parseApiMediaType(v, false); ok {
+			return format, errNotError
+		}
+
+		if mediaType, _, e := mime.ParseMediaType(v); e == nil && (mediaType == "*/*" || mediaType == "application/*") {
+			return apiFormatJsonApi, errNotError
+		}
+	}
+
+	return apiFormatJsonApi, errApiNotAcceptable
+}
+
+func parseApiMediaType(v string, strict bool) (uint8, bool) {
+
+	mediaType, params, e := mime.ParseMediaType(v)
+	if e != nil {
+		return apiFormatJsonApi, false
+	}
+
+	// only "charset=utf-8" is allowed for request bodies:
+	for k, pv := range params {
+		if strict && (k != "charset" || !strings.EqualFold(pv, "utf-8")) {
+			return apiFormatJsonApi, false
+		}
+	}
+
+	switch mediaType {
+	case mimeJsonApi:
+		return apiFormatJsonApi, true
+	case mimeJson:
+		return apiFormatJson, true
+	}
+
+	return apiFormatJsonApi, false
 }
@@ -0,0 +1,141 @@
+package server
+
+import "regexp"
+import "strings"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "github.com/gorilla/context"
+
+func TestValidateHostRequest(t *testing.T) {
+
+	var tests = []struct {
+		name     string
+		rq       *apiHostPostRequest
+		pointers []string
+	}{
+		{
+			name: "valid",
+			rq: &apiHostPostRequest{Data: &hostRequestData{Type: apiTypeHost, Attributes: &hostRequestAttributes{
+				Host:  &hostRequestHost{Ipmi_Address: "10.1.2.3"},
+				Ports: []*hostRequestPort{{Mac: "00:11:22:33:44:55"}, {Mac: "00:11:22:33:44:56"}},
+			}}},
+		},
+		{
+			name:     "no data",
+			rq:       &apiHostPostRequest{},
+			pointers: []string{"/data"},
+		},
+		{
+			name: "every error at once",
+			rq: &apiHostPostRequest{Data: &hostRequestData{Type: "ports", Attributes: &hostRequestAttributes{
+				Host:     &hostRequestHost{Ipmi_Address: "10.1.2"},
+				Ports:    []*hostRequestPort{{Mac: "00:11:22:33:44:55"}, {Mac: "00:11:22"}, nil, {}},
+				Priority: "urgent",
+			}}},
+			pointers: []string{
+				"/data/type",
+				"/data/attributes/host/ipmi_address",
+				"/data/attributes/priority",
+				"/data/attributes/ports/1/mac",
+				"/data/attributes/ports/2",
+				"/data/attributes/ports/3/mac",
+			},
+		},
+		{
+			name: "ipmi address out of the cidr block",
+			rq: &apiHostPostRequest{Data: &hostRequestData{Type: apiTypeHost, Attributes: &hostRequestAttributes{
+				Host:  &hostRequestHost{Ipmi_Address: "192.168.1.1"},
+				Ports: []*hostRequestPort{{Mac: "00:11:22"}},
+			}}},
+			pointers: []string{"/data/attributes/host/ipmi_address", "/data/attributes/ports/0/mac"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var req = &httpRequest{format: apiFormatJsonApi}
+
+			host, ports, ok := new(apiController).validateHostRequest(req, tt.rq)
+			if ok != (len(tt.pointers) == 0) {
+				t.Fatalf("validateHostRequest() = %v with %d errors", ok, len(req.errors))
+			}
+
+			if len(req.errors) != len(tt.pointers) {
+				t.Fatalf("got %d errors, want %d", len(req.errors), len(tt.pointers))
+			}
+
+			for i, v := range req.errors {
+				if v.srcPointer != tt.pointers[i] {
+					t.Errorf("error %d has the pointer %q, want %q", i, v.srcPointer, tt.pointers[i])
+				}
+			}
+
+			if ok && (host.ipmi_address == nil || len(ports) != len(tt.rq.Data.Attributes.Ports)) {
+				t.Errorf("the parsed host or ports are incomplete")
+			}
+		})
+	}
+}
+
+// the hosts row must not be inserted for the PTR records which are rejected by the resolver:
+func TestHostCreateResolvesBeforeSave(t *testing.T) {
+
+	var savedResolver, savedPuppet = globResolver, globPuppet
+	defer func() { globResolver, globPuppet = savedResolver, savedPuppet }()
+
+	globResolver = &ipmiResolver{policy: resolverPolicySingle, lookup: &stubResolver{ptr: map[string][]string{
+		"10.1.2.3": {"web1.ipmi."},
+		"10.1.2.4": {"web1.example.com."},
+		"10.1.2.5": {"mail1.ipmi."},
+	}}}
+	globPuppet = &puppetClient{projects: map[string]*puppetProject{
+		"web": {hostRegexp: regexp.MustCompile(`^web[0-9]+$`)},
+	}}
+
+	// the raft store is needed for the jobs, so the accepted host fails on the jobs insert:
+	var tests = []struct {
+		ipmi, pointer string
+		code          uint8
+		saved         bool
+	}{
+		{"10.1.2.3", "", errInternalCommonError, true},
+		{"10.1.2.4", "/data/attributes/host/ipmi_address", errHostsIpmiTldMismatch, false},
+		{"10.1.2.5", "/data/attributes/host/ipmi_address", errHostsNamingMismatch, false},
+		{"10.1.2.6", "", errInternalCommonError, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ipmi, func(t *testing.T) {
+
+			var db = newSqlStubDB(t)
+			db.setFailing("INSERT INTO jobs")
+
+			// the jobs and the errors are linked with the saved request:
+			var req *httpRequest
+			var handler = globApi.httpMiddlewareRequestLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req = context.Get(r, "internal_request").(*httpRequest)
+				req.format = apiFormatJsonApi
+				globApi.httpHandlerHostCreate(w, r)
+			}))
+
+			var body = `{"data":{"type":"host","attributes":{"host":{"ipmi_address":"` + tt.ipmi + `"},` +
+				`"ports":[{"mac":"00:11:22:33:44:55"}]}}}`
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/host", strings.NewReader(body)))
+
+			var inserted bool
+			for _, v := range db.queries {
+				inserted = inserted || strings.HasPrefix(v, "INSERT INTO hosts")
+			}
+
+			if inserted != tt.saved {
+				t.Fatalf("the host has been saved: %v, want %v", inserted, tt.saved)
+			}
+
+			if len(req.errors) != 1 || req.errors[0].code != tt.code || req.errors[0].srcPointer != tt.pointer {
+				t.Fatalf("the request has %d errors, want the code %d with the pointer %q", len(req.errors), tt.code, tt.pointer)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import "bytes"
+import "context"
+import "errors"
+import "net/url"
+import "net/http"
+import "text/template"
+import "encoding/json"
+
+type (
+	icqClient struct {
+		httpClient *http.Client
+
+		jobFailed       *template.Template
+		hostProvisioned *template.Template
+	}
+	icqResponse struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+
+	// template data:
+	icqJobFailed struct {
+		Job_Id     string
+		Action     string
+		Error      string
+		Fails      int
+		Request_Id string
+	}
+	icqHostProvisioned struct {
+		Hostname     string
+		Host_Id      string
+		Ipmi_Address string
+		Request_Id   string
+	}
+)
+
+// templates are parsed even if the bot is disabled, so broken configs are found at once:
+func newIcqClient() (*icqClient, error) {
+
+	var e error
+	var m = &icqClient{
+		httpClient: &http.Client{Timeout: globConfig.Base.Icq.Timeout},
+	}
+
+	if m.jobFailed, e = template.New("job_failed").Parse(globConfig.Base.Icq.Templates.JobFailed); e != nil {
+		return nil, errors.New("Could not parse the ICQ job_failed template: " + e.Error())
+	}
+
+	if m.hostProvisioned, e = template.New("host_provisioned").Parse(globConfig.Base.Icq.Templates.HostProvisioned); e != nil {
+		return nil, errors.New("Could not parse the ICQ host_provisioned template: " + e.Error())
+	}
+
+	if globConfig.Base.Icq.Enabled && globConfig.Base.Icq.Token == "" {
+		return nil, errors.New("The ICQ bot is enabled, but base/icq/token is not defined in the configuration file!")
+	}
+
+	return m, nil
+}
+
+func (m *icqClient) sendText(ctx context.Context, chatId, text string) *appError {
+
+	var params = url.Values{}
+	params.Set("token", globConfig.Base.Icq.Token)
+	params.Set("chatId", chatId)
+	params.Set("text", text)
+
+	rq, e := http.NewRequest("GET", globConfig.Base.Icq.BaseUrl+"/messages/sendText?"+params.Encode(), nil)
+	if e != nil {
+		return newAppError(errInternalCommonError).log(e, "Could not create new httpRequest!")
+	}
+
+	rsp, e := m.httpClient.Do(rq.WithContext(ctx))
+	if e != nil {
+		return newAppError(errIcqSendFailed).log(e, "Could not do the request!")
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		ae := newAppError(errIcqSendFailed)
+		return ae.log(nil, "Response code is not 200!", ae.glCtx().Int("http_code", rsp.StatusCode))
+	}
+
+	var result = new(icqResponse)
+	if e = json.NewDecoder(rsp.Body).Decode(result); e != nil {
+		return newAppError(errIcqSendFailed).log(e, "Could not decode the ICQ bot API response!")
+	}
+
+	if !result.Ok {
+		ae := newAppError(errIcqSendFailed)
+		return ae.log(nil, "The message has been rejected by the ICQ bot API!", ae.glCtx().Str("description", result.Description))
+	}
+
+	globLogger.Debug().Str("chat_id", chatId).Msg("[ICQ]: The message has been sent")
+	return nil
+}
+
+// Every chat gets its own job, so a failed chat doesn't resend the message to others.
+// Notifications are not critical, so errors are logged only.
+func (m *icqClient) notify(reqId string, tmpl *template.Template, data interface{}) {
+
+	if !globConfig.Base.Icq.Enabled {
+		return
+	}
+
+	var buf bytes.Buffer
+	if e := tmpl.Execute(&buf, data); e != nil {
+		globLogger.Error().Err(e).Str("template", tmpl.Name()).Msg("Could not render the ICQ message!")
+		return
+	}
+
+	for _, v := range globConfig.Base.Icq.ChatIds {
+		if _, err := newQueueJob(&reqId, jobActIcqSendMess, jobPriorityHigh, newMessageJobPayload(v, buf.String())); err != nil {
+			globLogger.Error().Str("chat_id", v).Msg("Could not create the ICQ message job!")
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package server
+
+import "sort"
+import "strings"
+import "net/http"
+import "encoding/json"
+
+// JSON:API resource types:
+const (
+	apiTypeHost    = "host"
+	apiTypePort    = "port"
+	apiTypeJob     = "job"
+	apiTypeError   = "error"
+	apiTypeRequest = "request"
+	apiTypeKey     = "key"
+	apiTypeEvent   = "event"
+	apiTypeQueue   = "queue"
+	apiTypeDeadJob = "dead_job"
+
+	apiTypeTransition   = "transition"
+	apiTypeJobTiming    = "job_timing"
+	apiTypeQueueControl = "queue_control"
+)
+
+// API response formats:
+const (
+	apiFormatJsonApi = uint8(iota)
+	apiFormatJson
+)
+
+// supported media types:
+const (
+	mimeJsonApi = "application/vnd.api+json"
+	mimeJson    = "application/json"
+)
+
+type (
+	// every model that could be rendered in the response must implement it:
+	apiResource interface {
+		getResourceType() string
+		getResourceId() string
+		getResourceAttributes() interface{}
+		getResourceRelations() []*resourceRelation
+	}
+
+	// Relations are loaded only if they are included, otherwise to-one relations with the
+	// known id (linkType is set, an empty linkId is null) are linked without loading.
+	resourceRelation struct {
+		name   string
+		toMany bool
+		loader func() ([]apiResource, *appError)
+
+		linkType, linkId string
+	}
+
+	// compound document builder (see jsonapi.org/format/#document-compound-documents):
+	apiDocument struct {
+		primary    []apiResource
+		collection bool
+
+		includes includeTree
+		fields   map[string]map[string]bool
+
+		seen      map[string]bool
+		relations map[string][]apiResource
+		included  []*responseData
+	}
+
+	// include paths for the flat schema, where related resources are nested:
+	includeTree map[string]includeTree
+)
+
+func newApiDocument(r *http.Request, defaultIncludes ...string) *apiDocument {
+
+	var doc = &apiDocument{
+		includes:  make(includeTree),
+		fields:    make(map[string]map[string]bool),
+		seen:      make(map[string]bool),
+		relations: make(map[string][]apiResource),
+	}
+
+	var query = r.URL.Query()
+
+	var includes = defaultIncludes
+	if _, ok := query["include"]; ok {
+		includes = strings.Split(query.Get("include"), ",")
+	}
+
+	for _, v := range includes {
+		if v = strings.TrimSpace(v); v != "" {
+			doc.includes.add(strings.Split(v, "."))
+		}
+	}
+
+	// sparse fieldsets - fields[TYPE]=field1,field2:
+	for k := range query {
+		if !strings.HasPrefix(k, "fields[") || !strings.HasSuffix(k, "]") {
+			continue
+		}
+
+		var fieldset = make(map[string]bool)
+		for _, v := range strings.Split(query.Get(k), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				fieldset[v] = true
+			}
+		}
+
+		doc.fields[k[len("fields["):len(k)-1]] = fieldset
+	}
+
+	return doc
+}
+
+func (m *apiDocument) setPrimary(rs ...apiResource) *apiDocument {
+	m.primary = rs
+	return m
+}
+
+func (m *apiDocument) setCollection(rs []apiResource) *apiDocument {
+	m.primary, m.collection = rs, true
+	return m
+}
+
+func (m *apiDocument) build() (interface{}, []*responseData, *appError) {
+
+	var data = []*responseData{}
+
+	// primary resources must not be duplicated in the "included" section:
+	for _, v := range m.primary {
+		m.seen[resourceKey(v)] = true
+	}
+
+	for _, v := range m.primary {
+		rd, err := m.renderResource(v, m.includes)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = append(data, rd)
+	}
+
+	if err := m.includeTree(m.primary, m.includes); err != nil {
+		return nil, nil, err
+	}
+
+	if m.collection {
+		return data, m.included, nil
+	}
+
+	if len(data) == 0 {
+		return nil, m.included, nil
+	}
+
+	return data[0], m.included, nil
+}
+
+// included resources are rendered with the relations of their subtree only:
+func (m *apiDocument) includeTree(rs []apiResource, tree includeTree) *appError {
+
+	for _, name := range tree.names() {
+
+		var next []apiResource
+
+		for _, v := range rs {
+
+			var relation = findResourceRelation(v, name)
+			if relation == nil {
+				return newAppError(errApiUnknownInclude).setParameter("include").log(nil, "The requested relationship path is not supported!")
+			}
+
+			related, err := m.loadRelation(v, relation)
+			if err != nil {
+				return err
+			}
+
+			for _, r := range related {
+				if !m.seen[resourceKey(r)] {
+					m.seen[resourceKey(r)] = true
+
+					rd, err := m.renderResource(r, tree[name])
+					if err != nil {
+						return err
+					}
+					m.included = append(m.included, rd)
+				}
+
+				next = append(next, r)
+			}
+		}
+
+		if err := m.includeTree(next, tree[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *apiDocument) loadRelation(r apiResource, relation *resourceRelation) ([]apiResource, *appError) {
+
+	var key = resourceKey(r) + "/" + relation.name
+	if related, ok := m.relations[key]; ok {
+		return related, nil
+	}
+
+	related, err := relation.loader()
+	if err != nil {
+		return nil, err
+	}
+
+	m.relations[key] = related
+	return related, nil
+}
+
+func (m *apiDocument) isFieldRequested(rType, field string) bool {
+	fieldset, ok := m.fields[rType]
+	return !ok || fieldset[field]
+}
+
+func (m *apiDocument) renderResource(r apiResource, tree includeTree) (*responseData, *appError) {
+
+	var rd = &responseData{
+		Type: r.getResourceType(),
+		Id:   r.getResourceId(),
+	}
+
+	attributes, err := m.filterAttributes(rd.Type, r.getResourceAttributes())
+	if err != nil {
+		return nil, err
+	}
+	rd.Attributes = attributes
+
+	for _, v := range r.getResourceRelations() {
+		if !m.isFieldRequested(rd.Type, v.name) {
+			continue
+		}
+
+		var relationship *dataRelationship
+
+		switch _, ok := tree[v.name]; {
+		case ok:
+			related, err := m.loadRelation(r, v)
+			if err != nil {
+				return nil, err
+			}
+			relationship = newDataRelationship(related, v.toMany)
+		case v.linkType != "":
+			relationship = &dataRelationship{}
+			if v.linkId != "" {
+				relationship.Data = &resourceIdentifier{Type: v.linkType, Id: v.linkId}
+			}
+		default:
+			// relations which are not included and not linked are omitted:
+			continue
+		}
+
+		if rd.Relationships == nil {
+			rd.Relationships = make(map[string]*dataRelationship)
+		}
+
+		rd.Relationships[v.name] = relationship
+	}
+
+	return rd, nil
+}
+
+func (m *apiDocument) filterAttributes(rType string, attributes interface{}) (interface{}, *appError) {
+
+	fieldset, ok := m.fields[rType]
+	if !ok || attributes == nil {
+		return attributes, nil
+	}
+
+	filtered, err := attributesToMap(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	for k := range filtered {
+		if !fieldset[k] {
+			delete(filtered, k)
+		}
+	}
+
+	if len(filtered) == 0 {
+		return nil, nil
+	}
+
+	return filtered, nil
+}
+
+// the flat schema is used for plain JSON clients: attributes are merged with
+// the resource id, relationships contain ids or nested included resources
+func (m *apiDocument) buildFlat() (interface{}, *appError) {
+
+	var data = []map[string]interface{}{}
+
+	for _, v := range m.primary {
+		flat, err := m.renderFlatResource(v, m.includes)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, flat)
+	}
+
+	if m.collection {
+		return data, nil
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	return data[0], nil
+}
+
+func (m *apiDocument) renderFlatResource(r apiResource, tree includeTree) (map[string]interface{}, *appError) {
+
+	var rType = r.getResourceType()
+	var flat = make(map[string]interface{})
+
+	attributes, err := attributesToMap(r.getResourceAttributes())
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range attributes {
+		if m.isFieldRequested(rType, k) {
+			flat[k] = v
+		}
+	}
+
+	flat["id"] = r.getResourceId()
+
+	for k := range tree {
+		if findResourceRelation(r, k) == nil {
+			return nil, newAppError(errApiUnknownInclude).setParameter("include").log(nil, "The requested relationship path is not supported!")
+		}
+	}
+
+	for _, v := range r.getResourceRelations() {
+		if !m.isFieldRequested(rType, v.name) {
+			continue
+		}
+
+		subtree, ok := tree[v.name]
+		if !ok {
+			if v.linkType == "" {
+				continue
+			}
+
+			flat[v.name] = nil
+			if v.linkId != "" {
+				flat[v.name] = v.linkId
+			}
+			continue
+		}
+
+		related, err := m.loadRelation(r, v)
+		if err != nil {
+			return nil, err
+		}
+
+		var values = []interface{}{}
+		for _, rr := range related {
+			nested, err := m.renderFlatResource(rr, subtree)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, nested)
+		}
+
+		switch {
+		case v.toMany:
+			flat[v.name] = values
+		case len(values) != 0:
+			flat[v.name] = values[0]
+		default:
+			flat[v.name] = nil
+		}
+	}
+
+	return flat, nil
+}
+
+func (m includeTree) add(path []string) {
+
+	var node = m
+	for _, v := range path {
+		if node[v] == nil {
+			node[v] = make(includeTree)
+		}
+		node = node[v]
+	}
+}
+
+// relations are included in order of their names, so the documents are stable:
+func (m includeTree) names() []string {
+
+	var names []string
+	for k := range m {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func attributesToMap(attributes interface{}) (map[string]json.RawMessage, *appError) {
+
+	var buf, e = json.Marshal(attributes)
+	if e != nil {
+		return nil, newAppError(errInternalCommonError).log(e, "Could not marshal the resource attributes!")
+	}
+
+	var attributesMap map[string]json.RawMessage
+	if e = json.Unmarshal(buf, &attributesMap); e != nil {
+		return nil, newAppError(errInternalCommonError).log(e, "Could not unmarshal the resource attributes!")
+	}
+
+	return attributesMap, nil
+}
+
+func newDataRelationship(related []apiResource, toMany bool) *dataRelationship {
+
+	if !toMany {
+		if len(related) == 0 {
+			return &dataRelationship{}
+		}
+		return &dataRelationship{
+			Data: newResourceIdentifier(related[0])}
+	}
+
+	var identifiers = []*resourceIdentifier{}
+	for _, v := range related {
+		identifiers = append(identifiers, newResourceIdentifier(v))
+	}
+
+	return &dataRelationship{
+		Data: identifiers}
+}
+
+func newResourceIdentifier(r apiResource) *resourceIdentifier {
+	return &resourceIdentifier{
+		Type: r.getResourceType(),
+		Id:   r.getResourceId(),
+	}
+}
+
+func findResourceRelation(r apiResource, name string) *resourceRelation {
+	for _, v := range r.getResourceRelations() {
+		if v.name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+func resourceKey(r apiResource) string {
+	return r.getResourceType() + ":" + r.getResourceId()
+}
@@ -0,0 +1,139 @@
+package server
+
+import "testing"
+import "encoding/json"
+import "net/http/httptest"
+
+type testResource struct {
+	id      string
+	loads   *int
+	related []apiResource
+}
+
+func (m *testResource) getResourceType() string            { return "test" }
+func (m *testResource) getResourceId() string              { return m.id }
+func (m *testResource) getResourceAttributes() interface{} { return map[string]string{"name": m.id} }
+
+func (m *testResource) getResourceRelations() []*resourceRelation {
+	return []*resourceRelation{
+		{name: "children", toMany: true, loader: m.loadChildren},
+		{name: "parent", loader: m.loadChildren, linkType: "test", linkId: "parent-" + m.id},
+	}
+}
+
+func (m *testResource) loadChildren() ([]apiResource, *appError) {
+	*m.loads++
+	return m.related, nil
+}
+
+func newTestResources(loads *int) (*testResource, *testResource) {
+	var child = &testResource{id: "child", loads: loads}
+	return &testResource{id: "root", loads: loads, related: []apiResource{child}}, child
+}
+
+func renderTestDocument(t *testing.T, url string, r apiResource) map[string]interface{} {
+
+	var doc = newApiDocument(httptest.NewRequest("GET", url, nil)).setPrimary(r)
+
+	data, included, err := doc.build()
+	if err != nil {
+		t.Fatalf("build() has failed with the code %d", err.code)
+	}
+
+	buf, e := json.Marshal(map[string]interface{}{"data": data, "included": included})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var rs map[string]interface{}
+	if e = json.Unmarshal(buf, &rs); e != nil {
+		t.Fatal(e)
+	}
+
+	return rs
+}
+
+func TestApiDocumentWithoutIncludes(t *testing.T) {
+
+	var loads int
+	var root, _ = newTestResources(&loads)
+
+	var rs = renderTestDocument(t, "/v1/test", root)
+
+	if loads != 0 {
+		t.Errorf("relations have been loaded %d times without includes", loads)
+	}
+
+	var relationships = rs["data"].(map[string]interface{})["relationships"].(map[string]interface{})
+	if _, ok := relationships["children"]; ok {
+		t.Error("the to-many relation without the linkage must be omitted")
+	}
+
+	var parent = relationships["parent"].(map[string]interface{})["data"].(map[string]interface{})
+	if parent["type"] != "test" || parent["id"] != "parent-root" {
+		t.Errorf("unexpected to-one linkage %v", parent)
+	}
+
+	if rs["included"] != nil {
+		t.Errorf("unexpected included resources %v", rs["included"])
+	}
+}
+
+func TestApiDocumentWithIncludes(t *testing.T) {
+
+	var loads int
+	var root, _ = newTestResources(&loads)
+
+	var rs = renderTestDocument(t, "/v1/test?include=children", root)
+
+	// the relation is loaded once for the linkage and the included section:
+	if loads != 1 {
+		t.Errorf("relations have been loaded %d times, expected 1", loads)
+	}
+
+	var children = rs["data"].(map[string]interface{})["relationships"].(map[string]interface{})["children"].(map[string]interface{})["data"].([]interface{})
+	if len(children) != 1 || children[0].(map[string]interface{})["id"] != "child" {
+		t.Errorf("unexpected to-many linkage %v", children)
+	}
+
+	var included = rs["included"].([]interface{})
+	if len(included) != 1 {
+		t.Fatalf("unexpected included resources %v", included)
+	}
+
+	// relations of the included resource are not in the include tree:
+	if _, ok := included[0].(map[string]interface{})["relationships"].(map[string]interface{})["children"]; ok {
+		t.Error("relations of included resources must be loaded by their include path only")
+	}
+}
+
+func TestApiDocumentUnknownInclude(t *testing.T) {
+
+	var loads int
+	var root, _ = newTestResources(&loads)
+
+	var doc = newApiDocument(httptest.NewRequest("GET", "/v1/test?include=unknown", nil)).setPrimary(root)
+	if _, _, err := doc.build(); err == nil || err.code != errApiUnknownInclude {
+		t.Error("unknown includes must be rejected")
+	}
+}
+
+func TestApiDocumentFlat(t *testing.T) {
+
+	var loads int
+	var root, _ = newTestResources(&loads)
+
+	flat, err := newApiDocument(httptest.NewRequest("GET", "/v1/test", nil)).setPrimary(root).buildFlat()
+	if err != nil {
+		t.Fatalf("buildFlat() has failed with the code %d", err.code)
+	}
+
+	var rs = flat.(map[string]interface{})
+	if loads != 0 || rs["parent"] != "parent-root" {
+		t.Errorf("unexpected flat resource %v (loads: %d)", rs, loads)
+	}
+
+	if _, ok := rs["children"]; ok {
+		t.Error("the to-many relation without includes must be omitted")
+	}
+}
@@ -1,6 +1,5 @@
 package server
 
-import "bytes"
 import "net/http"
 import "github.com/gorilla/context"
 
@@ -115,60 +114,3 @@ func (m *hostModel) checkHostExistence(hostname string) bool {
 
 	return true
 }
-
-// TODO: REMOVE THIS SHIT
-func (m *hostModel) createNewHost(ipmiIp *string) *baseHost {
-
-	var host = newHost()
-
-	if err := host.parseIpmiAddress(ipmiIp); err != nil {
-		return nil
-	}
-
-	var buf bytes.Buffer
-	//if _, e := buf.WriteString(host.resolveIpmiHostname()); e != nil {
-	//m.handleError(e, errInternalCommonError, "[HOST]: Bytes buffer - could not write the given string!")
-	//return nil
-	//}
-
-	if buf.Len() == 0 {
-		return nil
-	}
-
-	var bufBytes []byte
-	bufBytes, e := buf.ReadBytes(byte('.'))
-	if e != nil {
-		m.handleError(e, errInternalCommonError, "[HOST] Bytes buffer - could not read from buffer!")
-		return nil
-	}
-
-	if !bytes.Equal([]byte(globConfig.Base.Ipmi.HostnameTLD), buf.Bytes()) {
-		m.handleError(nil, errHostsIpmiTldMismatch, "[HOST]: Top-level domain of the resolved IPMI hostname does not match the configuration!")
-		return nil
-	}
-
-	host.hostname = string(bufBytes[:len(bufBytes)-1])
-	globLogger.Info().Str("id", host.id).Str("hostname", host.hostname).Msg("[HOST]: New host has been created!")
-
-	// TODO: Host save
-
-	/*
-
-
-		save or update host in DB
-		if host is exist in DB check it's UUID in ports table
-		...
-		some logic
-	*/
-
-	return host
-}
-
-/*
-	stmt,e := globSqlDB.Prepare("INSERT INTO hosts (id,hostname,ipmi_address) VALUES (?,?,?)")
-	if e != nil {
-		req.newError(errInternalSqlError).log(e, "[HOST]: Could not prepare DB statement!"); return nil }
-	defer stmt.Close()
-
-	if _,e = stmt.Exec(nil,nil,nil) {}
-*/
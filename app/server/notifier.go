@@ -0,0 +1,224 @@
+package server
+
+import "net"
+import "bytes"
+import "errors"
+import "context"
+import "strings"
+import "time"
+import "net/http"
+import "net/smtp"
+import "crypto/tls"
+import "encoding/json"
+import "github.com/MindHunter86/ks-installer/core/config"
+
+const (
+	notifierSmtp     = "smtp"
+	notifierWebhook  = "webhook"
+	notifierTelegram = "telegram"
+
+	notifierDefaultTimeout = 10 * time.Second
+	telegramDefaultUrl     = "https://api.telegram.org"
+)
+
+// every notification channel is a notifier, the subject is used by the backends which support it:
+type notifier interface {
+	send(ctx context.Context, subject, text string) *appError
+}
+
+// the backend addresses are taken from the configuration only, so they could be pointed to local fake servers:
+func newNotifier(cfg *config.NotifyChannel) (notifier, error) {
+
+	var client = &http.Client{Timeout: cfg.Timeout}
+	if cfg.Timeout == 0 {
+		client.Timeout = notifierDefaultTimeout
+	}
+
+	switch cfg.Type {
+	case notifierSmtp:
+		if cfg.Smtp.Addr == "" || cfg.Smtp.From == "" || len(cfg.Smtp.To) == 0 {
+			return nil, errors.New("the smtp channel must have addr, from and to")
+		}
+
+		return &smtpNotifier{cfg: cfg, timeout: client.Timeout}, nil
+	case notifierWebhook:
+		if cfg.Url == "" {
+			return nil, errors.New("the webhook channel must have url")
+		}
+
+		return &webhookNotifier{url: cfg.Url, httpClient: client}, nil
+	case notifierTelegram:
+		if cfg.Token == "" || len(cfg.ChatIds) == 0 {
+			return nil, errors.New("the telegram channel must have token and chat_ids")
+		}
+
+		var m = &telegramNotifier{url: cfg.Url, token: cfg.Token, chatIds: cfg.ChatIds, httpClient: client}
+		if m.url == "" {
+			m.url = telegramDefaultUrl
+		}
+
+		return m, nil
+	}
+
+	return nil, errors.New("unknown channel type " + cfg.Type)
+}
+
+// SMTP:
+type smtpNotifier struct {
+	cfg     *config.NotifyChannel
+	timeout time.Duration
+}
+
+func (m *smtpNotifier) send(ctx context.Context, subject, text string) *appError {
+
+	var dialer = &net.Dialer{Timeout: m.timeout}
+
+	conn, e := dialer.DialContext(ctx, "tcp", m.cfg.Smtp.Addr)
+	if e != nil {
+		return newAppError(errNotifySendFailed).log(e, "Could not connect to the SMTP server!")
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host, _, _ := net.SplitHostPort(m.cfg.Smtp.Addr)
+
+	cl, e := smtp.NewClient(conn, host)
+	if e != nil {
+		conn.Close()
+		return newAppError(errNotifySendFailed).log(e, "Could not start the SMTP session!")
+	}
+	defer cl.Close()
+
+	if m.cfg.Smtp.StartTLS {
+		if e = cl.StartTLS(&tls.Config{ServerName: host}); e != nil {
+			return newAppError(errNotifySendFailed).log(e, "Could not start TLS in the SMTP session!")
+		}
+	}
+
+	if m.cfg.Smtp.Username != "" {
+		if e = cl.Auth(smtp.PlainAuth("", m.cfg.Smtp.Username, m.cfg.Smtp.Password, host)); e != nil {
+			return newAppError(errNotifySendFailed).log(e, "Could not authenticate in the SMTP server!")
+		}
+	}
+
+	if e = cl.Mail(m.cfg.Smtp.From); e != nil {
+		return newAppError(errNotifySendFailed).log(e, "The SMTP server has rejected the sender!")
+	}
+
+	for _, v := range m.cfg.Smtp.To {
+		if e = cl.Rcpt(v); e != nil {
+			return newAppError(errNotifySendFailed).log(e, "The SMTP server has rejected the recipient!")
+		}
+	}
+
+	wr, e := cl.Data()
+	if e != nil {
+		return newAppError(errNotifySendFailed).log(e, "Could not send the SMTP DATA command!")
+	}
+
+	var msg bytes.Buffer
+	msg.WriteString("From: " + m.cfg.Smtp.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(m.cfg.Smtp.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + subject + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(text, "\n", "\r\n", -1) + "\r\n")
+
+	if _, e = wr.Write(msg.Bytes()); e != nil {
+		return newAppError(errNotifySendFailed).log(e, "Could not write the message to the SMTP server!")
+	}
+
+	if e = wr.Close(); e != nil {
+		return newAppError(errNotifySendFailed).log(e, "The SMTP server has rejected the message!")
+	}
+
+	if e = cl.Quit(); e != nil {
+		globLogger.Warn().Err(e).Msg("[NOTIFY]: Could not close the SMTP session, the message has been sent")
+	}
+
+	return nil
+}
+
+// Slack and Mattermost incoming webhooks:
+type webhookNotifier struct {
+	url        string
+	httpClient *http.Client
+}
+
+func (m *webhookNotifier) send(ctx context.Context, subject, text string) *appError {
+
+	buf, e := json.Marshal(map[string]string{"text": text})
+	if e != nil {
+		return newAppError(errInternalCommonError).log(e, "Could not marshal the webhook message!")
+	}
+
+	return doNotifierRequest(ctx, m.httpClient, m.url, buf, nil)
+}
+
+// Telegram bot API:
+type telegramNotifier struct {
+	url, token string
+	chatIds    []string
+	httpClient *http.Client
+}
+
+type telegramResponse struct {
+	Ok          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+// the message is sent to all chats again on retries, it's fine for alerts:
+func (m *telegramNotifier) send(ctx context.Context, subject, text string) *appError {
+
+	for _, v := range m.chatIds {
+
+		buf, e := json.Marshal(map[string]string{"chat_id": v, "text": text})
+		if e != nil {
+			return newAppError(errInternalCommonError).log(e, "Could not marshal the telegram message!")
+		}
+
+		var rsp = new(telegramResponse)
+		if err := doNotifierRequest(ctx, m.httpClient, m.url+"/bot"+m.token+"/sendMessage", buf, rsp); err != nil {
+			return err
+		}
+
+		if !rsp.Ok {
+			ae := newAppError(errNotifySendFailed)
+			return ae.log(nil, "The message has been rejected by the telegram bot API!", ae.glCtx().Str("description", rsp.Description))
+		}
+	}
+
+	return nil
+}
+
+// the JSON body is posted and the response is decoded into rsp if it's given:
+func doNotifierRequest(ctx context.Context, client *http.Client, url string, body []byte, rsp interface{}) *appError {
+
+	rq, e := http.NewRequest("POST", url, bytes.NewReader(body))
+	if e != nil {
+		return newAppError(errInternalCommonError).log(e, "Could not create new httpRequest!")
+	}
+	rq.Header.Set("Content-Type", "application/json")
+
+	resp, e := client.Do(rq.WithContext(ctx))
+	if e != nil {
+		return newAppError(errNotifySendFailed).log(e, "Could not do the request!")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		ae := newAppError(errNotifySendFailed)
+		return ae.log(nil, "Response code is not 2xx!", ae.glCtx().Int("http_code", resp.StatusCode))
+	}
+
+	if rsp == nil {
+		return nil
+	}
+
+	if e = json.NewDecoder(resp.Body).Decode(rsp); e != nil {
+		return newAppError(errNotifySendFailed).log(e, "Could not decode the response!")
+	}
+
+	return nil
+}
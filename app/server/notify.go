@@ -0,0 +1,579 @@
+package server
+
+import "sort"
+import "time"
+import "bytes"
+import "errors"
+import "strings"
+import "strconv"
+import "context"
+import "text/template"
+import "github.com/satori/go.uuid"
+
+const (
+	notifySeverityInfo = uint8(iota)
+	notifySeverityWarning
+	notifySeverityCritical
+)
+const (
+	notifyEventJobFailed       = "job_failed"
+	notifyEventHostProvisioned = "host_provisioned"
+	notifyEventJobTimedOut     = "job_timed_out"
+
+	// rate limited channels have the limit for an hour by default:
+	notifyDefaultRatePeriod = time.Hour
+)
+
+var (
+	notifySeverityHumanDetail = map[uint8]string{
+		notifySeverityInfo:     "info",
+		notifySeverityWarning:  "warning",
+		notifySeverityCritical: "critical",
+	}
+
+	notifyEvents = map[string]uint8{
+		notifyEventJobFailed:       notifySeverityCritical,
+		notifyEventHostProvisioned: notifySeverityInfo,
+		notifyEventJobTimedOut:     notifySeverityCritical,
+	}
+)
+
+type (
+	notifyChannel struct {
+		name     string
+		notifier notifier
+
+		rateLimit  int
+		ratePeriod time.Duration
+		digest     bool
+	}
+	notifyRoute struct {
+		channels []string
+		projects map[string]bool
+		events   map[string]bool
+		severity uint8
+	}
+	notifyDispatcher struct {
+		channels  map[string]*notifyChannel
+		routes    []*notifyRoute
+		templates map[string]*template.Template
+
+		// notifications are kept in MySQL and sent by queued jobs, tests give their own:
+		store notifyStore
+		queue func(reqId, id string) *appError
+	}
+
+	notifyStore interface {
+		save(n *notification) *appError
+		markSent(id string) *appError
+		getById(id string) (*notification, *appError)
+		getDigests() (map[string][]*notification, *appError)
+		getSentCount(channel string, since time.Time) (int, *appError)
+	}
+	sqlNotifyStore struct{}
+
+	// every routed message is saved, so the rate limits and digests work on the whole cluster:
+	notification struct {
+		id         string
+		channel    string
+		event      string
+		severity   uint8
+		text       string
+		digest     bool
+		sent       bool
+		created_at time.Time
+	}
+)
+
+func newNotifyDispatcher() (*notifyDispatcher, error) {
+
+	var m = &notifyDispatcher{
+		channels:  make(map[string]*notifyChannel),
+		templates: make(map[string]*template.Template),
+		store:     new(sqlNotifyStore),
+		queue:     queueNotification,
+	}
+
+	for name, v := range globConfig.Base.Notify.Channels {
+
+		var cfg = v
+		ntf, e := newNotifier(&cfg)
+		if e != nil {
+			return nil, errors.New("Could not configure the notification channel " + name + ": " + e.Error())
+		}
+
+		m.channels[name] = &notifyChannel{
+			name:       name,
+			notifier:   ntf,
+			rateLimit:  cfg.RateLimit,
+			ratePeriod: cfg.RatePeriod,
+			digest:     cfg.Digest,
+		}
+
+		if m.channels[name].ratePeriod == 0 {
+			m.channels[name].ratePeriod = notifyDefaultRatePeriod
+		}
+	}
+
+	for i, v := range globConfig.Base.Notify.Routes {
+
+		var route = &notifyRoute{
+			channels: v.Channels,
+			projects: make(map[string]bool),
+			events:   make(map[string]bool),
+		}
+
+		for _, ch := range v.Channels {
+			if _, ok := m.channels[ch]; !ok {
+				return nil, errors.New("Unknown notification channel " + ch + " in the route " + strconv.Itoa(i) + "!")
+			}
+		}
+
+		for _, ev := range v.Events {
+			if _, ok := notifyEvents[ev]; !ok {
+				return nil, errors.New("Unknown notification event " + ev + " in the route " + strconv.Itoa(i) + "!")
+			}
+			route.events[ev] = true
+		}
+
+		for _, pr := range v.Projects {
+			route.projects[pr] = true
+		}
+
+		var ok bool
+		if route.severity, ok = getNotifySeverityByName(v.Severity); !ok {
+			return nil, errors.New("Unknown notification severity " + v.Severity + " in the route " + strconv.Itoa(i) + "!")
+		}
+
+		m.routes = append(m.routes, route)
+	}
+
+	for ev, v := range globConfig.Base.Notify.Templates {
+
+		if _, ok := notifyEvents[ev]; !ok {
+			return nil, errors.New("Unknown notification event " + ev + " in the templates!")
+		}
+
+		tmpl, e := template.New(ev).Parse(v)
+		if e != nil {
+			return nil, errors.New("Could not parse the notification template " + ev + ": " + e.Error())
+		}
+
+		m.templates[ev] = tmpl
+	}
+
+	return m, nil
+}
+
+// the message is rendered once and queued for every channel of the matched routes:
+func (m *notifyDispatcher) notify(reqId, event, project string, data interface{}) {
+
+	var severity = notifyEvents[event]
+	var channels = m.getRoutedChannels(event, project, severity)
+	if len(channels) == 0 {
+		return
+	}
+
+	tmpl, ok := m.templates[event]
+	if !ok {
+		globLogger.Warn().Str("event", event).Msg("[NOTIFY]: There is no template for the event, the notification is skipped!")
+		return
+	}
+
+	var buf bytes.Buffer
+	if e := tmpl.Execute(&buf, data); e != nil {
+		globLogger.Error().Err(e).Str("event", event).Msg("[NOTIFY]: Could not render the notification!")
+		return
+	}
+
+	for _, v := range channels {
+		if err := m.enqueue(v, reqId, event, severity, buf.String()); err != nil {
+			globLogger.Error().Str("channel", v.name).Str("event", event).Msg("[NOTIFY]: Could not queue the notification!")
+		}
+	}
+}
+
+func (m *notifyDispatcher) getRoutedChannels(event, project string, severity uint8) []*notifyChannel {
+
+	var names = make(map[string]bool)
+	for _, v := range m.routes {
+		if v.isMatched(event, project, severity) {
+			for _, ch := range v.channels {
+				names[ch] = true
+			}
+		}
+	}
+
+	var channels []*notifyChannel
+	for name := range names {
+		channels = append(channels, m.channels[name])
+	}
+
+	sort.Slice(channels, func(i, j int) bool { return channels[i].name < channels[j].name })
+	return channels
+}
+
+func (m *notifyDispatcher) getChannel(name string) (*notifyChannel, *appError) {
+
+	ch, ok := m.channels[name]
+	if !ok {
+		return nil, newAppError(errNotifyUnknownChannel).log(nil, "The notification channel is not configured on the node!")
+	}
+
+	return ch, nil
+}
+
+func (m *notifyRoute) isMatched(event, project string, severity uint8) bool {
+
+	if severity < m.severity {
+		return false
+	}
+
+	if len(m.events) != 0 && !m.events[event] {
+		return false
+	}
+
+	return len(m.projects) == 0 || m.projects[project]
+}
+
+func getNotifySeverityByName(name string) (uint8, bool) {
+
+	if name == "" {
+		return notifySeverityInfo, true
+	}
+
+	for k, v := range notifySeverityHumanDetail {
+		if v == name {
+			return k, true
+		}
+	}
+
+	return 0, false
+}
+
+// Messages of digest channels and messages beyond the rate limit are left for the next digest.
+// Other messages are sent by queued jobs, so they are retried as any other job.
+func (m *notifyDispatcher) enqueue(ch *notifyChannel, reqId, event string, severity uint8, text string) *appError {
+
+	var n = &notification{
+		id:       uuid.NewV4().String(),
+		channel:  ch.name,
+		event:    event,
+		severity: severity,
+		text:     text,
+		digest:   ch.digest,
+	}
+
+	if !n.digest && ch.rateLimit > 0 {
+		sent, err := m.store.getSentCount(ch.name, time.Now().Add(-ch.ratePeriod))
+		if err != nil {
+			return err
+		}
+
+		if sent >= ch.rateLimit {
+			globLogger.Warn().Str("channel", ch.name).Int("rate_limit", ch.rateLimit).
+				Msg("[NOTIFY]: The channel rate limit is reached, the notification is left for the digest")
+			n.digest = true
+		}
+	}
+
+	if err := m.store.save(n); err != nil {
+		return err
+	}
+
+	if n.digest {
+		return nil
+	}
+
+	return m.queue(reqId, n.id)
+}
+
+func queueNotification(reqId, id string) *appError {
+	_, err := newQueueJob(&reqId, jobActNotifySend, jobPriorityHigh, newNotificationJobPayload(id))
+	return err
+}
+
+func (m *notifyDispatcher) send(ctx context.Context, id string) *appError {
+
+	n, err := m.store.getById(id)
+	if err != nil {
+		return err
+	}
+
+	// the notification has been purged or sent before the node crash:
+	if n == nil || n.sent {
+		return nil
+	}
+
+	if err = checkJobContext(ctx); err != nil {
+		return err
+	}
+
+	ch, err := m.getChannel(n.channel)
+	if err != nil {
+		return err
+	}
+
+	if err = ch.notifier.send(ctx, n.getSubject(), n.text); err != nil {
+		return err
+	}
+
+	return m.store.markSent(n.id)
+}
+
+// channels are sent separately, so a failed channel doesn't resend the digest to others on retries:
+func (m *notifyDispatcher) sendDigests(ctx context.Context) *appError {
+
+	digests, err := m.store.getDigests()
+	if err != nil {
+		return err
+	}
+
+	var lastErr *appError
+	for name, nts := range digests {
+
+		if err = checkJobContext(ctx); err != nil {
+			return err
+		}
+
+		ch, err := m.getChannel(name)
+		if err != nil {
+			globLogger.Warn().Str("channel", name).Int("notifications", len(nts)).Msg("[NOTIFY]: The digest channel has been removed from the configuration!")
+			continue
+		}
+
+		if err = m.sendDigest(ctx, ch, nts); err != nil {
+			lastErr = err
+			continue
+		}
+
+		globLogger.Info().Str("channel", name).Int("notifications", len(nts)).Msg("[NOTIFY]: The digest has been sent")
+	}
+
+	return lastErr
+}
+
+// the digest has the counters of events and all messages in order of their creation:
+func (m *notifyDispatcher) sendDigest(ctx context.Context, ch *notifyChannel, nts []*notification) *appError {
+
+	var events = make(map[string]int)
+	for _, v := range nts {
+		events[v.event]++
+	}
+
+	var counters []string
+	for k, v := range events {
+		counters = append(counters, k+": "+strconv.Itoa(v))
+	}
+	sort.Strings(counters)
+
+	var buf bytes.Buffer
+	buf.WriteString("ks-installer digest, " + strconv.Itoa(len(nts)) + " notification(s): " + strings.Join(counters, ", ") + "\n\n")
+
+	for _, v := range nts {
+		buf.WriteString(v.created_at.Format(time.RFC3339) + " [" + notifySeverityHumanDetail[v.severity] + "] " + v.text + "\n")
+	}
+
+	if err := ch.notifier.send(ctx, "[ks-installer] digest: "+strconv.Itoa(len(nts))+" notification(s)", buf.String()); err != nil {
+		return err
+	}
+
+	for _, v := range nts {
+		if err := m.store.markSent(v.id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *notification) getSubject() string {
+	return "[ks-installer] " + m.event + " (" + notifySeverityHumanDetail[m.severity] + ")"
+}
+
+// MySQL store:
+func (m *sqlNotifyStore) getSentCount(channel string, since time.Time) (int, *appError) {
+
+	var count int
+	if e := globSqlDB.QueryRow("SELECT COUNT(*) FROM notifications WHERE channel = ? AND digest = 0 AND created_at > ?",
+		channel, since.Format("2006-01-02 15:04:05")).Scan(&count); e != nil {
+		return 0, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+
+	return count, nil
+}
+
+func (m *sqlNotifyStore) getById(id string) (*notification, *appError) {
+
+	rs, err := m.getByQuery("SELECT id,channel,event,severity,text,digest,sent_at IS NOT NULL,created_at FROM notifications WHERE id = ?", id)
+	if err != nil || len(rs) == 0 {
+		return nil, err
+	}
+
+	return rs[0], nil
+}
+
+// unsent digest notifications are grouped by channels:
+func (m *sqlNotifyStore) getDigests() (map[string][]*notification, *appError) {
+
+	rs, err := m.getByQuery(`SELECT id,channel,event,severity,text,digest,sent_at IS NOT NULL,created_at FROM notifications
+		WHERE digest = 1 AND sent_at IS NULL ORDER BY channel, created_at`)
+	if err != nil {
+		return nil, err
+	}
+
+	var digests = make(map[string][]*notification)
+	for _, v := range rs {
+		digests[v.channel] = append(digests[v.channel], v)
+	}
+
+	return digests, nil
+}
+
+func (m *sqlNotifyStore) getByQuery(query string, args ...interface{}) ([]*notification, *appError) {
+
+	var rs []*notification
+
+	rws, e := globSqlDB.Query(query, args...)
+	if e != nil {
+		return rs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var n = new(notification)
+		if e = rws.Scan(&n.id, &n.channel, &n.event, &n.severity, &n.text, &n.digest, &n.sent, &n.created_at); e != nil {
+			return rs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		rs = append(rs, n)
+	}
+
+	if rws.Err() != nil {
+		return rs, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return rs, nil
+}
+
+func (m *sqlNotifyStore) save(n *notification) *appError {
+
+	if _, e := globSqlDB.Exec("INSERT INTO notifications (id,channel,event,severity,text,digest) VALUES (?,?,?,?,?,?)",
+		n.id, n.channel, n.event, n.severity, n.text, n.digest); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not save the notification!")
+	}
+
+	return nil
+}
+
+func (m *sqlNotifyStore) markSent(id string) *appError {
+
+	if _, e := globSqlDB.Exec("UPDATE notifications SET sent_at = CURRENT_TIMESTAMP WHERE id = ?", id); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	return nil
+}
+
+// Notification events:
+func (m *queueJob) notifyFailed(aErr *appError) {
+
+	// failed messages must not be reported with new messages:
+	if m.action == jobActIcqSendMess || m.action == jobActNotifySend || m.action == jobActNotifyDigest {
+		return
+	}
+
+	// timed out jobs are reported by the watchdog with their own event:
+	if aErr.code == errJobsStuck {
+		return
+	}
+
+	var data = &icqJobFailed{
+		Job_Id:     m.id,
+		Action:     m.getHumanAction(),
+		Error:      apiErrorsDetail[aErr.code],
+		Fails:      m.fail_count,
+		Request_Id: m.requested_by,
+	}
+
+	globIcq.notify(m.requested_by, globIcq.jobFailed, data)
+	globNotifier.notify(m.requested_by, notifyEventJobFailed, m.getProject(), data)
+}
+
+// the stuck job is given as the template data, so the message has the state and the stuck time:
+func (m *queueJob) notifyTimedOut(stuck *queueStuckJob) {
+	globNotifier.notify(m.requested_by, notifyEventJobTimedOut, m.getProject(), stuck)
+}
+
+// The host is provisioned when all jobs of its request are done. The notification is sent by
+// the final pipeline step (rsview_parse) only. Rsview jobs of one request could finish at once
+// on different nodes, so the audit event with the request based id keeps it from sending twice.
+func (m *queueJob) notifyProvisioned() {
+
+	var pending int
+	if e := globSqlDB.QueryRow("SELECT COUNT(*) FROM jobs WHERE requested_by = ? AND action IN (?,?) AND (state != ? OR is_failed = 1)",
+		m.requested_by, jobActHostCreate, jobActRsviewParse, jobStatusDone).Scan(&pending); e != nil {
+		newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+		return
+	}
+
+	if pending != 0 {
+		return
+	}
+
+	tinyHost, err := getRequestHost(m.requested_by)
+	if err != nil || tinyHost == nil {
+		return
+	}
+
+	host, err := getHostById(tinyHost.id)
+	if err != nil || host == nil {
+		return
+	}
+
+	var event = newAuditEvent(m.requested_by, auditActHostProvisioned, host.hostname)
+	event.id = uuid.NewV5(uuid.NamespaceOID, m.requested_by+":"+auditActHostProvisioned).String()
+
+	if saved, err := event.saveOnce(); err != nil || !saved {
+		return
+	}
+
+	var data = &icqHostProvisioned{
+		Hostname:   host.hostname,
+		Host_Id:    host.id,
+		Request_Id: m.requested_by,
+	}
+
+	if host.ipmi_address != nil {
+		data.Ipmi_Address = host.ipmi_address.String()
+	}
+
+	globIcq.notify(m.requested_by, globIcq.hostProvisioned, data)
+	globNotifier.notify(m.requested_by, notifyEventHostProvisioned, globPuppet.getProjectByHostname(host.hostname), data)
+}
+
+// the project is found by the hostname of the job host or the host of the job request:
+func (m *queueJob) getProject() string {
+
+	if m.payload != nil && m.payload.Host != nil {
+		return globPuppet.getProjectByHostname(m.payload.Host.Hostname)
+	}
+
+	host, err := getRequestHost(m.requested_by)
+	if err != nil || host == nil {
+		return ""
+	}
+
+	return globPuppet.getProjectByHostname(host.hostname)
+}
+
+func getRequestHost(reqId string) (*baseHost, *appError) {
+
+	hostJob, err := getTinyJobByReqId(reqId, jobActHostCreate)
+	if err != nil || hostJob == nil {
+		return nil, err
+	}
+
+	return getTinyHostByJobId(hostJob.id)
+}
@@ -0,0 +1,357 @@
+package server
+
+import "net"
+import "sync"
+import "time"
+import "bufio"
+import "context"
+import "strconv"
+import "strings"
+import "testing"
+import "net/http"
+import "io/ioutil"
+import "encoding/json"
+import "net/http/httptest"
+import "github.com/MindHunter86/ks-installer/core/config"
+
+// memNotifyStore keeps notifications in memory instead of MySQL:
+type memNotifyStore struct {
+	sync.Mutex
+	nts []*notification
+}
+
+func (m *memNotifyStore) save(n *notification) *appError {
+	m.Lock()
+	defer m.Unlock()
+
+	n.created_at = time.Now()
+	m.nts = append(m.nts, n)
+	return nil
+}
+
+func (m *memNotifyStore) markSent(id string) *appError {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, v := range m.nts {
+		if v.id == id {
+			v.sent = true
+		}
+	}
+	return nil
+}
+
+func (m *memNotifyStore) getById(id string) (*notification, *appError) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, v := range m.nts {
+		if v.id == id {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memNotifyStore) getDigests() (map[string][]*notification, *appError) {
+	m.Lock()
+	defer m.Unlock()
+
+	var digests = make(map[string][]*notification)
+	for _, v := range m.nts {
+		if v.digest && !v.sent {
+			digests[v.channel] = append(digests[v.channel], v)
+		}
+	}
+	return digests, nil
+}
+
+func (m *memNotifyStore) getSentCount(channel string, since time.Time) (int, *appError) {
+	m.Lock()
+	defer m.Unlock()
+
+	var count int
+	for _, v := range m.nts {
+		if v.channel == channel && !v.digest && v.created_at.After(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// fakeSmtpServer accepts every message and keeps its DATA:
+type fakeSmtpServer struct {
+	sync.Mutex
+	ln       net.Listener
+	messages []string
+}
+
+func newFakeSmtpServer(t *testing.T) *fakeSmtpServer {
+
+	ln, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var m = &fakeSmtpServer{ln: ln}
+	go m.serve()
+	return m
+}
+
+func (m *fakeSmtpServer) serve() {
+	for {
+		conn, e := m.ln.Accept()
+		if e != nil {
+			return
+		}
+
+		go m.handle(conn)
+	}
+}
+
+func (m *fakeSmtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var rd = bufio.NewReader(conn)
+	var reply = func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, e := rd.ReadString('\n')
+		if e != nil {
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 Go ahead")
+
+			var data []string
+			for {
+				line, e = rd.ReadString('\n')
+				if e != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data = append(data, line)
+			}
+
+			m.Lock()
+			m.messages = append(m.messages, strings.Join(data, ""))
+			m.Unlock()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func (m *fakeSmtpServer) getMessages() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string(nil), m.messages...)
+}
+
+// fakeHttpServer keeps the text of every webhook and telegram message:
+type fakeHttpServer struct {
+	sync.Mutex
+	*httptest.Server
+	texts []string
+}
+
+func newFakeHttpServer() *fakeHttpServer {
+
+	var m = new(fakeHttpServer)
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		buf, _ := ioutil.ReadAll(r.Body)
+
+		var msg map[string]string
+		json.Unmarshal(buf, &msg)
+
+		m.Lock()
+		m.texts = append(m.texts, msg["text"])
+		m.Unlock()
+
+		w.Write([]byte(`{"ok":true}`))
+	}))
+
+	return m
+}
+
+func (m *fakeHttpServer) getTexts() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string(nil), m.texts...)
+}
+
+type notifyTest struct {
+	dp     *notifyDispatcher
+	store  *memNotifyStore
+	queued []string
+
+	smtp    *fakeSmtpServer
+	webhook *fakeHttpServer
+	tg      *fakeHttpServer
+}
+
+func newNotifyTest(t *testing.T, webhookRateLimit int) *notifyTest {
+
+	var m = &notifyTest{
+		store:   new(memNotifyStore),
+		smtp:    newFakeSmtpServer(t),
+		webhook: newFakeHttpServer(),
+		tg:      newFakeHttpServer(),
+	}
+
+	var mail = config.NotifyChannel{Type: notifierSmtp}
+	mail.Smtp.Addr, mail.Smtp.From, mail.Smtp.To = m.smtp.ln.Addr().String(), "ks@example.com", []string{"ops@example.com"}
+
+	var saved = globConfig.Base.Notify
+	globConfig.Base.Notify.Channels = map[string]config.NotifyChannel{
+		"mail":    mail,
+		"webhook": {Type: notifierWebhook, Url: m.webhook.URL, RateLimit: webhookRateLimit},
+		"tg":      {Type: notifierTelegram, Url: m.tg.URL, Token: "token", ChatIds: []string{"1"}, Digest: true},
+	}
+	globConfig.Base.Notify.Routes = []config.NotifyRoute{
+		{Channels: []string{"webhook"}, Events: []string{notifyEventJobFailed}},
+		{Channels: []string{"mail"}, Projects: []string{"web"}, Severity: "critical"},
+		{Channels: []string{"tg"}, Severity: "info"},
+	}
+	defer func() { globConfig.Base.Notify = saved }()
+
+	var e error
+	if m.dp, e = newNotifyDispatcher(); e != nil {
+		t.Fatal(e)
+	}
+
+	m.dp.store = m.store
+	m.dp.queue = func(reqId, id string) *appError {
+		m.queued = append(m.queued, id)
+		return nil
+	}
+
+	return m
+}
+
+func (m *notifyTest) close() {
+	m.smtp.ln.Close()
+	m.webhook.Close()
+	m.tg.Close()
+}
+
+// queued notifications are sent as the notify_send jobs do:
+func (m *notifyTest) sendQueued(t *testing.T) {
+
+	for _, id := range m.queued {
+		if err := m.dp.send(context.Background(), id); err != nil {
+			t.Fatalf("send() has failed with the code %d", err.code)
+		}
+	}
+	m.queued = nil
+}
+
+func TestNotifyRouting(t *testing.T) {
+
+	var nt = newNotifyTest(t, 0)
+	defer nt.close()
+
+	var data = &icqJobFailed{Job_Id: "job1", Action: "host_create", Error: "broken", Fails: 1}
+
+	nt.dp.notify("req1", notifyEventJobFailed, "web", data)
+	nt.dp.notify("req2", notifyEventJobFailed, "db", data)
+	nt.dp.notify("req3", notifyEventHostProvisioned, "web", &icqHostProvisioned{Hostname: "web1", Ipmi_Address: "10.0.0.1"})
+
+	// webhook: both job_failed, mail: job_failed of the web project, tg: all three for the digest
+	if len(nt.queued) != 3 {
+		t.Fatalf("%d notifications are queued, want 3", len(nt.queued))
+	}
+	nt.sendQueued(t)
+
+	if texts := nt.webhook.getTexts(); len(texts) != 2 || !strings.Contains(texts[0], "job1") {
+		t.Errorf("the webhook has got %q", texts)
+	}
+
+	var mails = nt.smtp.getMessages()
+	if len(mails) != 1 || !strings.Contains(mails[0], "Subject: [ks-installer] job_failed (critical)") || !strings.Contains(mails[0], "job1") {
+		t.Errorf("the smtp server has got %q", mails)
+	}
+
+	if texts := nt.tg.getTexts(); len(texts) != 0 {
+		t.Errorf("the digest channel must not get messages before the digest, got %q", texts)
+	}
+}
+
+func TestNotifyRateLimit(t *testing.T) {
+
+	var nt = newNotifyTest(t, 2)
+	defer nt.close()
+
+	for i := 0; i < 4; i++ {
+		nt.dp.notify("req1", notifyEventJobFailed, "db", &icqJobFailed{Job_Id: "job" + strconv.Itoa(i+1)})
+	}
+
+	if len(nt.queued) != 2 {
+		t.Fatalf("%d notifications are queued, want the rate limit 2", len(nt.queued))
+	}
+	nt.sendQueued(t)
+
+	digests, _ := nt.store.getDigests()
+	if len(digests["webhook"]) != 2 {
+		t.Fatalf("%d webhook notifications are left for the digest, want 2", len(digests["webhook"]))
+	}
+
+	if err := nt.dp.sendDigests(context.Background()); err != nil {
+		t.Fatalf("sendDigests() has failed with the code %d", err.code)
+	}
+
+	var texts = nt.webhook.getTexts()
+	if len(texts) != 3 || !strings.Contains(texts[2], "2 notification(s): job_failed: 2") ||
+		!strings.Contains(texts[2], "job3") || !strings.Contains(texts[2], "job4") {
+		t.Errorf("the webhook has got %q", texts)
+	}
+}
+
+func TestNotifyDigest(t *testing.T) {
+
+	var nt = newNotifyTest(t, 0)
+	defer nt.close()
+
+	nt.dp.notify("req1", notifyEventJobFailed, "db", &icqJobFailed{Job_Id: "job1"})
+	nt.dp.notify("req2", notifyEventHostProvisioned, "db", &icqHostProvisioned{Hostname: "db1"})
+	nt.dp.notify("req3", notifyEventHostProvisioned, "db", &icqHostProvisioned{Hostname: "db2"})
+	nt.sendQueued(t)
+
+	if err := nt.dp.sendDigests(context.Background()); err != nil {
+		t.Fatalf("sendDigests() has failed with the code %d", err.code)
+	}
+
+	var texts = nt.tg.getTexts()
+	if len(texts) != 1 {
+		t.Fatalf("the telegram channel has got %d messages, want 1 digest", len(texts))
+	}
+
+	for _, v := range []string{"3 notification(s): host_provisioned: 2, job_failed: 1", "[critical]", "db1", "db2"} {
+		if !strings.Contains(texts[0], v) {
+			t.Errorf("the digest %q has no %q", texts[0], v)
+		}
+	}
+
+	// sent digests are not sent again:
+	if err := nt.dp.sendDigests(context.Background()); err != nil {
+		t.Fatalf("sendDigests() has failed with the code %d", err.code)
+	}
+
+	if texts = nt.tg.getTexts(); len(texts) != 1 {
+		t.Errorf("the digest has been sent twice")
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import "net"
+import "encoding/json"
+
+// Job payloads are stored in the jobs table, so unfinished jobs could be reloaded after
+// the restart. The version must be increased on every incompatible format change.
+const jobPayloadVersion = uint8(1)
+
+type (
+	jobPayload struct {
+		Version uint8           `json:"version"`
+		Host    *jobPayloadHost `json:"host,omitempty"`
+		Port    *jobPayloadPort `json:"port,omitempty"`
+
+		Message      *jobPayloadMessage      `json:"message,omitempty"`
+		Notification *jobPayloadNotification `json:"notification,omitempty"`
+	}
+	jobPayloadHost struct {
+		Id           string `json:"id"`
+		Hostname     string `json:"hostname,omitempty"`
+		Ipmi_Address string `json:"ipmi_address"`
+	}
+	jobPayloadPort struct {
+		Mac string `json:"mac"`
+	}
+	jobPayloadMessage struct {
+		Chat_Id string `json:"chat_id"`
+		Text    string `json:"text"`
+	}
+	jobPayloadNotification struct {
+		Id string `json:"id"`
+	}
+)
+
+func newHostJobPayload(host *baseHost) *jobPayload {
+	return &jobPayload{
+		Version: jobPayloadVersion,
+		Host: &jobPayloadHost{
+			Id:           host.id,
+			Hostname:     host.hostname,
+			Ipmi_Address: host.ipmi_address.String(),
+		},
+	}
+}
+
+// jobs without arguments have the version only:
+func newEmptyJobPayload() *jobPayload {
+	return &jobPayload{
+		Version: jobPayloadVersion,
+	}
+}
+
+func newPortJobPayload(port *basePort) *jobPayload {
+	return &jobPayload{
+		Version: jobPayloadVersion,
+		Port: &jobPayloadPort{
+			Mac: port.mac.String(),
+		},
+	}
+}
+
+func newMessageJobPayload(chatId, text string) *jobPayload {
+	return &jobPayload{
+		Version: jobPayloadVersion,
+		Message: &jobPayloadMessage{
+			Chat_Id: chatId,
+			Text:    text,
+		},
+	}
+}
+
+func newNotificationJobPayload(id string) *jobPayload {
+	return &jobPayload{
+		Version: jobPayloadVersion,
+		Notification: &jobPayloadNotification{
+			Id: id,
+		},
+	}
+}
+
+func parseJobPayload(buf []byte) (*jobPayload, *appError) {
+
+	var payload *jobPayload
+	if e := json.Unmarshal(buf, &payload); e != nil {
+		return nil, newAppError(errJobsPayloadInvalid).log(e, "Could not unmarshal the job payload!")
+	}
+
+	if payload == nil || payload.Version != jobPayloadVersion {
+		return nil, newAppError(errJobsPayloadInvalid).log(nil, "The job payload has unsupported version!")
+	}
+
+	return payload, nil
+}
+
+func (m *jobPayload) marshal() ([]byte, *appError) {
+
+	buf, e := json.Marshal(m)
+	if e != nil {
+		return nil, newAppError(errInternalCommonError).log(e, "Could not marshal the job payload!")
+	}
+
+	return buf, nil
+}
+
+func (m *jobPayload) getHost() (*baseHost, *appError) {
+
+	if m == nil || m.Host == nil {
+		return nil, newAppError(errJobsPayloadInvalid).log(nil, "The job payload has no host!")
+	}
+
+	var ipmiAddr = net.ParseIP(m.Host.Ipmi_Address)
+	if ipmiAddr == nil {
+		return nil, newAppError(errJobsPayloadInvalid).log(nil, "Could not parse the ipmi address from the job payload!")
+	}
+
+	return &baseHost{
+		id:           m.Host.Id,
+		hostname:     m.Host.Hostname,
+		ipmi_address: &ipmiAddr,
+	}, nil
+}
+
+func (m *jobPayload) getPort() (*basePort, *appError) {
+
+	if m == nil || m.Port == nil {
+		return nil, newAppError(errJobsPayloadInvalid).log(nil, "The job payload has no port!")
+	}
+
+	mac, e := net.ParseMAC(m.Port.Mac)
+	if e != nil {
+		return nil, newAppError(errJobsPayloadInvalid).log(e, "Could not parse the MAC address from the job payload!")
+	}
+
+	var port = newPort()
+	port.mac = mac
+
+	return port, nil
+}
+
+func (m *jobPayload) getMessage() (*jobPayloadMessage, *appError) {
+
+	if m == nil || m.Message == nil || m.Message.Chat_Id == "" {
+		return nil, newAppError(errJobsPayloadInvalid).log(nil, "The job payload has no message!")
+	}
+
+	return m.Message, nil
+}
+
+func (m *jobPayload) getNotification() (*jobPayloadNotification, *appError) {
+
+	if m == nil || m.Notification == nil || m.Notification.Id == "" {
+		return nil, newAppError(errJobsPayloadInvalid).log(nil, "The job payload has no notification!")
+	}
+
+	return m.Notification, nil
+}
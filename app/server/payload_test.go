@@ -0,0 +1,119 @@
+package server
+
+import "net"
+import "testing"
+
+func TestJobPayloadRoundTrip(t *testing.T) {
+
+	var ip = net.ParseIP("10.0.0.1")
+	var mac, _ = net.ParseMAC("00:11:22:33:44:55")
+
+	var host = &baseHost{id: "host1", hostname: "web1", ipmi_address: &ip}
+	var port = &basePort{mac: mac}
+
+	var tests = []struct {
+		name    string
+		payload *jobPayload
+		check   func(*jobPayload) *appError
+	}{
+		{"host", newHostJobPayload(host), func(pl *jobPayload) *appError {
+			h, err := pl.getHost()
+			if err == nil && (h.id != host.id || h.hostname != host.hostname || !h.ipmi_address.Equal(ip)) {
+				t.Errorf("getHost() = %+v, want %+v", h, host)
+			}
+			return err
+		}},
+		{"port", newPortJobPayload(port), func(pl *jobPayload) *appError {
+			p, err := pl.getPort()
+			if err == nil && p.mac.String() != mac.String() {
+				t.Errorf("getPort() has the MAC %s, want %s", p.mac, mac)
+			}
+			return err
+		}},
+		{"message", newMessageJobPayload("chat1", "hello"), func(pl *jobPayload) *appError {
+			msg, err := pl.getMessage()
+			if err == nil && (msg.Chat_Id != "chat1" || msg.Text != "hello") {
+				t.Errorf("getMessage() = %+v", msg)
+			}
+			return err
+		}},
+		{"notification", newNotificationJobPayload("n1"), func(pl *jobPayload) *appError {
+			n, err := pl.getNotification()
+			if err == nil && n.Id != "n1" {
+				t.Errorf("getNotification() = %+v", n)
+			}
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			buf, err := tt.payload.marshal()
+			if err != nil {
+				t.Fatalf("marshal() has failed with the code %d", err.code)
+			}
+
+			pl, err := parseJobPayload(buf)
+			if err != nil {
+				t.Fatalf("parseJobPayload(%s) has failed with the code %d", buf, err.code)
+			}
+
+			if err = tt.check(pl); err != nil {
+				t.Errorf("the payload %s could not be restored, the code %d", buf, err.code)
+			}
+		})
+	}
+}
+
+func TestParseJobPayloadInvalid(t *testing.T) {
+
+	var tests = []string{
+		``,
+		`null`,
+		`{"host":`,
+		`{}`,
+		`{"version":2}`,
+		`["version",1]`,
+	}
+
+	for _, tt := range tests {
+		if _, err := parseJobPayload([]byte(tt)); err == nil || err.code != errJobsPayloadInvalid {
+			t.Errorf("parseJobPayload(%q) must fail with errJobsPayloadInvalid", tt)
+		}
+	}
+}
+
+func TestJobPayloadGettersInvalid(t *testing.T) {
+
+	var tests = []struct {
+		name string
+		buf  string
+		get  func(*jobPayload) *appError
+	}{
+		{"no host", `{"version":1}`, func(pl *jobPayload) *appError { _, err := pl.getHost(); return err }},
+		{"bad ipmi address", `{"version":1,"host":{"id":"h1","ipmi_address":"10.0.0"}}`,
+			func(pl *jobPayload) *appError { _, err := pl.getHost(); return err }},
+		{"no port", `{"version":1}`, func(pl *jobPayload) *appError { _, err := pl.getPort(); return err }},
+		{"bad mac", `{"version":1,"port":{"mac":"00:11:22"}}`, func(pl *jobPayload) *appError { _, err := pl.getPort(); return err }},
+		{"no chat", `{"version":1,"message":{"text":"hello"}}`, func(pl *jobPayload) *appError { _, err := pl.getMessage(); return err }},
+		{"no notification id", `{"version":1,"notification":{}}`,
+			func(pl *jobPayload) *appError { _, err := pl.getNotification(); return err }},
+	}
+
+	for _, tt := range tests {
+		pl, err := parseJobPayload([]byte(tt.buf))
+		if err != nil {
+			t.Fatalf("%s: parseJobPayload() has failed with the code %d", tt.name, err.code)
+		}
+
+		if err = tt.get(pl); err == nil || err.code != errJobsPayloadInvalid {
+			t.Errorf("%s: the getter must fail with errJobsPayloadInvalid", tt.name)
+		}
+	}
+
+	// handlers could be given a nil payload of the old jobs:
+	if _, err := (*jobPayload)(nil).getHost(); err == nil {
+		t.Error("getHost() of the nil payload must fail")
+	}
+}
@@ -1,15 +1,21 @@
 package server
 
 import "net"
+import "context"
+import "time"
 import "strings"
 import "strconv"
+import "database/sql"
 
 type basePort struct {
 	mac           net.HardwareAddr
+	host          string
 	jun_name      string
 	jun_port_name string
 	jun_vlan      uint16
 	lldp_host     string
+	updated_at    time.Time
+	created_at    time.Time
 }
 
 func newPort() *basePort {
@@ -26,7 +32,79 @@ func newPortWithMAC(mac *string) (*basePort, *appError) {
 		return nil, err.log(e, "Could not parse the given MAC address!", err.glCtx().Str("mac", *mac))
 	}
 
-	return port, port.getOrCreate()
+	return port, nil
+}
+
+func getPortsByHostId(hId string) ([]*basePort, *appError) {
+
+	var ports []*basePort
+
+	rws, e := globSqlDB.Query("SELECT mac,host,jun_name,jun_port_name,jun_vlan,updated_at,created_at FROM macs WHERE host = ?", hId)
+	if e != nil {
+		return ports, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var port = newPort()
+		var mac string
+		var host, junName, junPortName sql.NullString
+		var junVlan sql.NullInt64
+
+		if e = rws.Scan(&mac, &host, &junName, &junPortName, &junVlan, &port.updated_at, &port.created_at); e != nil {
+			return ports, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		if port.mac, e = net.ParseMAC(mac); e != nil {
+			return ports, newAppError(errInternalCommonError).log(e, "Could not parse the MAC address from DB!")
+		}
+
+		port.host, port.jun_name, port.jun_port_name = host.String, junName.String, junPortName.String
+		port.jun_vlan = uint16(junVlan.Int64)
+
+		ports = append(ports, port)
+	}
+
+	if rws.Err() != nil {
+		return ports, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return ports, nil
+}
+
+// ports which have been linked with hosts:
+func getLinkedPorts() ([]*basePort, *appError) {
+
+	var ports []*basePort
+
+	rws, e := globSqlDB.Query("SELECT mac FROM macs WHERE host IS NOT NULL")
+	if e != nil {
+		return ports, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var port = newPort()
+		var mac string
+
+		if e = rws.Scan(&mac); e != nil {
+			return ports, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		if port.mac, e = net.ParseMAC(mac); e != nil {
+			return ports, newAppError(errInternalCommonError).log(e, "Could not parse the MAC address from DB!")
+		}
+
+		ports = append(ports, port)
+	}
+
+	if rws.Err() != nil {
+		return ports, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return ports, nil
 }
 
 func (m *basePort) getOrCreate() *appError {
@@ -56,9 +134,9 @@ func (m *basePort) getOrCreate() *appError {
 	return nil
 }
 
-func (m *basePort) parseRsviewProperties() *appError {
+func (m *basePort) parseRsviewProperties(ctx context.Context) *appError {
 
-	rsResult, err := globRsview.getPortAttributes(m.mac)
+	rsResult, err := globRsview.getPortAttributes(ctx, m.mac)
 	if err != nil {
 		return err
 	}
@@ -159,3 +237,47 @@ func (m *basePort) linkWithHost(hId string) *appError {
 
 	return nil
 }
+
+// apiResource interface implementation:
+func (m *basePort) getResourceType() string { return apiTypePort }
+func (m *basePort) getResourceId() string   { return m.mac.String() }
+
+func (m *basePort) getResourceAttributes() interface{} {
+
+	var attributes = &attributesPort{
+		Mac:           m.mac.String(),
+		Jun_Name:      m.jun_name,
+		Jun_Port_Name: m.jun_port_name,
+		Jun_Vlan:      m.jun_vlan,
+	}
+
+	if !m.updated_at.IsZero() {
+		attributes.Updated_At = m.updated_at.Format(time.RFC3339)
+	}
+
+	if !m.created_at.IsZero() {
+		attributes.Created_At = m.created_at.Format(time.RFC3339)
+	}
+
+	return attributes
+}
+
+func (m *basePort) getResourceRelations() []*resourceRelation {
+	return []*resourceRelation{
+		{name: "host", loader: m.loadHostResources, linkType: apiTypeHost, linkId: m.host},
+	}
+}
+
+func (m *basePort) loadHostResources() ([]apiResource, *appError) {
+
+	if m.host == "" {
+		return nil, nil
+	}
+
+	host, err := getHostById(m.host)
+	if err != nil || host == nil {
+		return nil, err
+	}
+
+	return []apiResource{host}, nil
+}
@@ -1,6 +1,7 @@
 package server
 
 import "net/http"
+import "sort"
 import "regexp"
 
 type (
@@ -94,3 +95,21 @@ func (m *puppetClient) parseEndpoints() error {
 
 	return nil
 }
+
+// the first project (by name) with the matched hostname regexp:
+func (m *puppetClient) getProjectByHostname(hostname string) string {
+
+	var names []string
+	for k := range m.projects {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, v := range names {
+		if m.projects[v].hostRegexp.MatchString(hostname) {
+			return v
+		}
+	}
+
+	return ""
+}
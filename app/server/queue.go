@@ -1,14 +1,28 @@
 package server
 
 import "sync"
+import "context"
 import "time"
+import "strings"
+import "database/sql"
+import "container/heap"
 import "github.com/satori/go.uuid"
 
 const (
 	jobActServerPing = uint8(iota)
 	jobActHostCreate
 	jobActRsviewParse // todo
-	jobActIcqSendMess // todo
+	jobActIcqSendMess
+	jobActRsviewRescan
+	jobActRequestsPurge
+	jobActHostsReport
+	jobActNotifySend
+	jobActNotifyDigest
+)
+const (
+	jobPriorityLow = uint8(iota)
+	jobPriorityNormal
+	jobPriorityHigh
 )
 const (
 	jobStatusCreated = uint8(iota)
@@ -24,6 +38,19 @@ var (
 		jobActHostCreate:  "Processing the received request to create a host",
 		jobActRsviewParse: "Rsview parsing",
 		jobActIcqSendMess: "ICQ message sending",
+
+		jobActRsviewRescan:  "Rsview re-scan of the known port",
+		jobActRequestsPurge: "Purging of old requests",
+		jobActHostsReport:   "Stale hosts report",
+
+		jobActNotifySend:   "Notification sending",
+		jobActNotifyDigest: "Notifications digest sending",
+	}
+
+	jobPriorityHumanDetail = map[uint8]string{
+		jobPriorityLow:    "low",
+		jobPriorityNormal: "normal",
+		jobPriorityHigh:   "high",
 	}
 
 	jobStatusHumanDetail = map[uint8]string{
@@ -37,51 +64,170 @@ var (
 
 type (
 	queueJob struct {
-		payload    *map[string]interface{}
+		payload    *jobPayload
 		fail_count int
 		errors     []*appError
 
+		// every run of the job is recorded for the dead-letter queue:
+		attempts   []*jobAttempt
+		started_at time.Time
+
 		id           string
 		requested_by string
 		action       uint8
+		priority     uint8
 		state        uint8
 		is_failed    bool
 		updated_at   time.Time
 		created_at   time.Time
+
+		// dispatcher order for jobs with the same priority:
+		seq uint64
+
+		// the raft lease which allows the local node to run the job:
+		lease_id string
+
+		// the local worker which runs the job (0 - none):
+		worker int
+
+		// the chosen IPMI hostname of host jobs:
+		resolution *hostResolution
 	}
 	queueDispatcher struct {
 		jobQueue chan *queueJob
 		pool     chan chan *queueJob
 
+		// jobs which wait for their dependencies, released by finished jobs:
+		blocked  map[string]*queueJob
+		finished chan *queueJob
+
+		// blocked jobs which dependencies could not be checked, they are checked again by the ticker:
+		unchecked map[string]*queueJob
+
+		// jobs which wait for a worker or an action slot, by action:
+		waiting  map[uint8]*jobHeap
+		running  map[uint8]int
+		released chan *queueJob
+		seq      uint64
+
+		// jobs which have been given to workers, they are awaited on shutdown; handlers
+		// are cancelled by the jobs context when the grace period has expired:
+		inflight   map[string]*queueJob
+		jobsCtx    context.Context
+		cancelJobs context.CancelFunc
+
+		// jobs which wait for their retry delay, the timers are stopped by the drain:
+		retries   map[*queueJob]*time.Timer
+		retriesMu sync.Mutex
+		stopped   bool
+
+		// the pool is resized by the control state; excess workers are stopped
+		// when they take the next job:
+		control    *queueControl
+		controls   chan *queueControl
+		workers    sync.WaitGroup
+		size       int
+		excess     int
+		lastWorker int
+
+		status chan chan *queueStatus
+
+		// stuck jobs are reported in the queue status:
+		watchdog *queueWatchdog
+
+		started    chan struct{}
 		done       chan struct{}
 		workerDone chan struct{}
+		drained    chan struct{}
 	}
 	queueWorker struct {
-		pool  chan chan *queueJob
-		inbox chan *queueJob
+		id  int
+		ctx context.Context
+
+		pool     chan chan *queueJob
+		inbox    chan *queueJob
+		released chan *queueJob
 
 		done chan struct{}
 	}
 )
 
-func newQueueJob(reqId *string, act uint8) (*queueJob, *appError) {
+// jobs of one request could be created in a transaction, so the request never has a part of them:
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func newQueueJob(reqId *string, act, priority uint8, payload *jobPayload, dependsOn ...*queueJob) (*queueJob, *appError) {
+	return createQueueJob(globSqlDB, reqId, act, priority, payload, dependsOn...)
+}
+
+// ports are linked with the host, so rsview jobs wait for the host creation:
+func newHostCreateJobs(reqId *string, priority uint8, host *baseHost, ports []*basePort) ([]*queueJob, *appError) {
+
+	tx, e := globSqlDB.Begin()
+	if e != nil {
+		return nil, newAppError(errInternalSqlError).log(e, "Could not begin the transaction!")
+	}
+
+	hostJob, err := createQueueJob(tx, reqId, jobActHostCreate, priority, newHostJobPayload(host))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var jbs = []*queueJob{hostJob}
+	for _, v := range ports {
+		jb, err := createQueueJob(tx, reqId, jobActRsviewParse, priority, newPortJobPayload(v), hostJob)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		jbs = append(jbs, jb)
+	}
+
+	if e = tx.Commit(); e != nil {
+		return nil, newAppError(errInternalSqlError).log(e, "Could not commit the transaction!")
+	}
+
+	return jbs, nil
+}
+
+func createQueueJob(db sqlExecer, reqId *string, act, priority uint8, payload *jobPayload, dependsOn ...*queueJob) (*queueJob, *appError) {
 
 	var jb = &queueJob{
 		id:           uuid.NewV4().String(),
 		state:        jobStatusCreated,
 		action:       act,
+		priority:     priority,
+		payload:      payload,
 		requested_by: *reqId,
 		updated_at:   time.Now(),
 		created_at:   time.Now()}
 
-	if _, e := globSqlDB.Exec(
-		"INSERT INTO jobs (id, requested_by, action, updated_at, created_at) VALUES (?,?,?,?,?)",
-		jb.id, jb.requested_by, jb.action,
+	buf, err := payload.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, e := db.Exec(
+		"INSERT INTO jobs (id, requested_by, action, priority, payload, updated_at, created_at) VALUES (?,?,?,?,?,?,?)",
+		jb.id, jb.requested_by, jb.action, jb.priority, buf,
 		jb.updated_at.Format("2006-01-02 15:04:05.999999"), jb.created_at.Format("2006-01-02 15:04:05.999999")); e != nil {
 
 		return nil, newAppError(errInternalCommonError).log(e, "Could not create a new job because of a database error!")
 	}
 
+	if err = jb.insertTransition(db, nil, jb.state); err != nil {
+		return nil, err
+	}
+
+	for _, v := range dependsOn {
+		if _, e := db.Exec("INSERT INTO job_dependencies (job_id, depends_on) VALUES (?,?)", jb.id, v.id); e != nil {
+			return nil, newAppError(errInternalSqlError).log(e, "Could not save the job dependency!")
+		}
+	}
+
 	return jb, nil
 }
 
@@ -89,7 +235,7 @@ func getJobById(jobId string) (*queueJob, *appError) {
 
 	jb := new(queueJob)
 
-	rws, e := globSqlDB.Query("SELECT action,state,updated_at,created_at FROM jobs WHERE id=? LIMIT 2", jobId)
+	rws, e := globSqlDB.Query("SELECT requested_by,action,priority,state,is_failed,resolution,updated_at,created_at FROM jobs WHERE id=? LIMIT 2", jobId)
 	if e != nil {
 		return nil, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
 	}
@@ -102,9 +248,11 @@ func getJobById(jobId string) (*queueJob, *appError) {
 		return nil, newAppError(errJobsJobNotFound).log(nil, "The requested job was not found!")
 	}
 
-	if e = rws.Scan(&jb.action, &jb.state, &jb.updated_at, &jb.created_at); e != nil {
+	var resolution sql.NullString
+	if e = rws.Scan(&jb.requested_by, &jb.action, &jb.priority, &jb.state, &jb.is_failed, &resolution, &jb.updated_at, &jb.created_at); e != nil {
 		return nil, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
 	}
+	jb.resolution = parseHostResolution(resolution)
 
 	if rws.Next() {
 		return nil, newAppError(errInternalSqlError).log(nil, "Rows is not equal to 1. The DB has broken!")
@@ -145,59 +293,250 @@ func getTinyJobByReqId(reqId string, jobAct uint8) (*queueJob, *appError) {
 	return jb, nil
 }
 
-func (m *queueJob) getResponseErrors() ([]*jobsErrors, *appError) {
+func getJobsByRequestId(reqId string) ([]*queueJob, *appError) {
 
-	var jbErrs []*jobsErrors
+	var jbs []*queueJob
 
-	rws, e := globSqlDB.Query("SELECT id,internal_code,displayed_title,displayed_detail FROM errors WHERE job_id = ?", m.id)
+	rws, e := globSqlDB.Query("SELECT id,action,priority,state,is_failed,resolution,updated_at,created_at FROM jobs WHERE requested_by = ? ORDER BY created_at", reqId)
 	if e != nil {
-		return jbErrs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+		return jbs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
 	}
 	defer rws.Close()
 
 	for rws.Next() {
 
-		var jbErr = &jobsErrors{}
+		var jb = &queueJob{
+			requested_by: reqId,
+		}
 
-		if e := rws.Scan(&jbErr.Id, &jbErr.Code, &jbErr.Title, &jbErr.Details); e != nil {
-			return jbErrs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		var resolution sql.NullString
+		if e = rws.Scan(&jb.id, &jb.action, &jb.priority, &jb.state, &jb.is_failed, &resolution, &jb.updated_at, &jb.created_at); e != nil {
+			return jbs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
 		}
+		jb.resolution = parseHostResolution(resolution)
 
-		jbErrs = append(jbErrs, jbErr)
+		jbs = append(jbs, jb)
 	}
 
 	if rws.Err() != nil {
-		return jbErrs, newAppError(errInternalSqlError).log(e, "Could not exec rows.Next method!")
+		return jbs, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
 	}
 
-	return jbErrs, nil
+	return jbs, nil
 }
 
+// jobs which are waiting for a node or have been interrupted (created, pending or blocked):
+// Unfinished jobs are loaded by the raft leader every second, so the payloads are not loaded here.
+// Every job row is joined with its dependencies, jobs without dependencies have the only row.
+func getUnfinishedJobStates() ([]*queueJob, map[string][]*queueJob, *appError) {
+
+	var jbs []*queueJob
+	var deps = make(map[string][]*queueJob)
+
+	rws, e := globSqlDB.Query(`SELECT jobs.id,jobs.action,jobs.state,deps.id,deps.state,deps.is_failed
+		FROM jobs
+		LEFT JOIN job_dependencies
+		ON job_dependencies.job_id = jobs.id
+		LEFT JOIN jobs AS deps
+		ON deps.id = job_dependencies.depends_on
+		WHERE jobs.is_failed = 0 AND jobs.state IN (?,?,?)
+		ORDER BY jobs.created_at,jobs.id`,
+		jobStatusCreated, jobStatusPending, jobStatusBlocked)
+	if e != nil {
+		return jbs, deps, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var jb = new(queueJob)
+		var depId sql.NullString
+		var depState sql.NullInt64
+		var depFailed sql.NullBool
+
+		if e = rws.Scan(&jb.id, &jb.action, &jb.state, &depId, &depState, &depFailed); e != nil {
+			return jbs, deps, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		if len(jbs) == 0 || jbs[len(jbs)-1].id != jb.id {
+			jbs = append(jbs, jb)
+		}
+
+		if depId.Valid {
+			deps[jb.id] = append(deps[jb.id], &queueJob{
+				id:        depId.String,
+				state:     uint8(depState.Int64),
+				is_failed: depFailed.Bool,
+			})
+		}
+	}
+
+	if rws.Err() != nil {
+		return jbs, deps, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return jbs, deps, nil
+}
+
+// jobs are loaded with their payloads in the order of their creation:
+func getJobsByIds(ids []string) ([]*queueJob, *appError) {
+
+	var jbs []*queueJob
+	if len(ids) == 0 {
+		return jbs, nil
+	}
+
+	var args = make([]interface{}, len(ids))
+	for i, v := range ids {
+		args[i] = v
+	}
+
+	rws, e := globSqlDB.Query("SELECT id,requested_by,action,priority,state,payload,updated_at,created_at FROM jobs WHERE id IN (?"+
+		strings.Repeat(",?", len(ids)-1)+") ORDER BY created_at,id", args...)
+	if e != nil {
+		return jbs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var jb = new(queueJob)
+		var payload []byte
+
+		if e = rws.Scan(&jb.id, &jb.requested_by, &jb.action, &jb.priority, &jb.state, &payload, &jb.updated_at, &jb.created_at); e != nil {
+			return jbs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		// jobs with broken payloads could not be retried:
+		var err *appError
+		if jb.payload, err = parseJobPayload(payload); err != nil {
+			jb.errors = append(jb.errors, err.setJobId(jb.id))
+		}
+
+		jbs = append(jbs, jb)
+	}
+
+	if rws.Err() != nil {
+		return jbs, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return jbs, nil
+}
+
+// jobs which the given job waits on:
+func getJobDependencies(jobId string) ([]*queueJob, *appError) {
+
+	var jbs []*queueJob
+
+	rws, e := globSqlDB.Query(`SELECT jobs.id,jobs.requested_by,jobs.action,jobs.state,jobs.is_failed,jobs.updated_at,jobs.created_at
+		FROM job_dependencies
+		INNER JOIN jobs
+		ON jobs.id = job_dependencies.depends_on
+		WHERE job_dependencies.job_id = ?`, jobId)
+	if e != nil {
+		return jbs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var jb = new(queueJob)
+		if e = rws.Scan(&jb.id, &jb.requested_by, &jb.action, &jb.state, &jb.is_failed, &jb.updated_at, &jb.created_at); e != nil {
+			return jbs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		jbs = append(jbs, jb)
+	}
+
+	if rws.Err() != nil {
+		return jbs, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return jbs, nil
+}
+
+// ids of jobs which wait on the given job:
+func getJobDependents(jobId string) ([]string, *appError) {
+
+	var ids []string
+
+	rws, e := globSqlDB.Query("SELECT job_id FROM job_dependencies WHERE depends_on = ?", jobId)
+	if e != nil {
+		return ids, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var id string
+		if e = rws.Scan(&id); e != nil {
+			return ids, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		ids = append(ids, id)
+	}
+
+	if rws.Err() != nil {
+		return ids, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return ids, nil
+}
+
+// Every error is saved at once and the fails counter is kept in DB, so the job which has
+// been reloaded (by another node or after the restart) is not retried beyond its policy.
 func (m *queueJob) appendAppError(aErr *appError) *appError {
 
 	m.errors = append(m.errors, aErr.setJobId(m.id))
+	m.attempts = append(m.attempts, newJobAttempt(m.started_at, aErr))
 
-	if len(m.errors) == globConfig.Base.Queue.JobRetryMaxFails {
-		globLogger.Error().Str("job_id", m.id).Str("job_action", jobActHumanDetail[m.action]).
-			Msg("The job has reached the maximum number of failures!")
+	aErr.save()
 
-		m.setFailed()
+	if err := m.incrementFails(); err != nil {
+		m.fail_count++
+	}
 
-		for _, v := range m.errors {
-			v.save()
+	var policy = getJobRetryPolicy(m.action)
+
+	if !policy.shouldRetry(aErr, m.fail_count) {
+		var msg = "The job has reached the maximum number of failures!"
+		if !aErr.isRetryable() {
+			msg = "The job has failed with a permanent error!"
 		}
 
-		// TODO: add icq notify
+		globLogger.Error().Str("job_id", m.id).Str("job_action", jobActHumanDetail[m.action]).Msg(msg)
+
+		m.setFailed()
+
+		m.saveDeadLetter(aErr)
+
+		m.notifyFailed(aErr)
 
 		return aErr
 	}
 
-	// TODO: add interval between job starts
+	var delay = policy.getRetryDelay(m.fail_count)
+	globLogger.Warn().Str("job_id", m.id).Int("fails", m.fail_count).Dur("delay", delay).
+		Msg("The job has failed and will be retried")
 
-	m.addToQueue()
+	globQueue.retryAfter(m, delay)
 	return aErr
 }
 
+// the counter is read back, because the job could have failed on other nodes before:
+func (m *queueJob) incrementFails() *appError {
+
+	if _, e := globSqlDB.Exec("UPDATE jobs SET fails = fails + 1 WHERE id = ?", m.id); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	if e := globSqlDB.QueryRow("SELECT fails FROM jobs WHERE id = ?", m.id).Scan(&m.fail_count); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+
+	return nil
+}
+
 func (m *queueJob) setFailed() *appError {
 
 	m.is_failed = true
@@ -212,23 +551,82 @@ func (m *queueJob) setFailed() *appError {
 	return nil
 }
 
+func (m *queueJob) setInterrupted() *appError {
+
+	var from = m.state
+	m.state = jobStatusCreated
+
+	if _, e := globSqlDB.Exec("UPDATE jobs SET state = ?, interrupted = interrupted + 1 WHERE id = ?", m.state, m.id); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	return m.saveTransition(&from, m.state)
+}
+
+// only jobs which have not been finished are reset, the job struct could be shared with a retry timer:
+func (m *queueJob) saveInterrupted() *appError {
+
+	rs, e := globSqlDB.Exec("UPDATE jobs SET state = ?, interrupted = interrupted + 1 WHERE id = ? AND state = ?",
+		jobStatusCreated, m.id, jobStatusPending)
+	if e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	if n, e := rs.RowsAffected(); e != nil || n == 0 {
+		return nil
+	}
+
+	var from = jobStatusPending
+	return m.saveTransition(&from, jobStatusCreated)
+}
+
 func (m *queueJob) stateUpdate(state uint8) *appError {
 
+	var from = m.state
 	m.state = state
 
 	if _, e := globSqlDB.Exec("UPDATE jobs SET state = ? WHERE id = ?", state, m.id); e != nil {
 		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
 	}
 
+	if err := m.saveTransition(&from, state); err != nil {
+		return err
+	}
+
+	// dependent jobs are released (or failed) by the dispatcher, blocked dependents
+	// are reset by the drain, if the dispatcher has been stopped:
+	if state == jobStatusDone || state == jobStatusFailed {
+		globQueue.submit(globJobsDone, m)
+	}
+
 	return nil
 }
 
-func (m *queueJob) setPayload(pl *map[string]interface{}) {
-	m.payload = pl
+// The blocked state is set by the dispatcher before the job is parked. Only the states before
+// the run are changed, so the job which has been run meanwhile keeps its state:
+func (m *queueJob) blockUpdate() *appError {
+
+	rs, e := globSqlDB.Exec("UPDATE jobs SET state = ? WHERE id = ? AND state IN (?,?)",
+		jobStatusBlocked, m.id, jobStatusCreated, jobStatusPending)
+	if e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	if affected, _ := rs.RowsAffected(); affected == 0 {
+		return nil
+	}
+
+	var from = m.state
+	m.state = jobStatusBlocked
+
+	return m.saveTransition(&from, jobStatusBlocked)
 }
 
+// the job is given back to the raft leader, if the dispatcher has been stopped:
 func (m *queueJob) addToQueue() {
-	globQueueChan <- m
+	if !globQueue.submit(globQueueChan, m) {
+		m.saveInterrupted()
+	}
 }
 
 func (m *queueJob) getHumanAction() string {
@@ -239,172 +637,605 @@ func (m *queueJob) getHumanStateDetails() string {
 	return jobStatusHumanDetail[m.state]
 }
 
+func (m *queueJob) getHumanPriority() string {
+	return jobPriorityHumanDetail[m.priority]
+}
+
+func parseJobPriority(priority string) (uint8, bool) {
+
+	if priority == "" {
+		return jobPriorityNormal, true
+	}
+
+	for k, v := range jobPriorityHumanDetail {
+		if v == priority {
+			return k, true
+		}
+	}
+
+	return 0, false
+}
+
+// apiResource interface implementation:
+func (m *queueJob) getResourceType() string { return apiTypeJob }
+func (m *queueJob) getResourceId() string   { return m.id }
+
+func (m *queueJob) getResourceAttributes() interface{} {
+	return &attributesJob{
+		Action:     m.getHumanAction(),
+		Priority:   m.getHumanPriority(),
+		State:      m.getHumanStateDetails(),
+		Is_Failed:  m.is_failed,
+		Resolution: m.resolution,
+		Updated_At: m.updated_at.Format(time.RFC3339),
+		Created_At: m.created_at.Format(time.RFC3339),
+	}
+}
+
+func (m *queueJob) getResourceRelations() []*resourceRelation {
+	return []*resourceRelation{
+		{name: "errors", toMany: true, loader: m.loadErrorResources},
+		{name: "request", loader: m.loadRequestResources, linkType: apiTypeRequest, linkId: m.requested_by},
+		{name: "depends_on", toMany: true, loader: m.loadDependencyResources},
+		{name: "transitions", toMany: true, loader: m.loadTransitionResources},
+	}
+}
+
+func (m *queueJob) loadErrorResources() ([]apiResource, *appError) {
+
+	aErrs, err := getAppErrorsByJobId(m.id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []apiResource
+	for _, v := range aErrs {
+		rs = append(rs, v)
+	}
+
+	return rs, nil
+}
+
+func (m *queueJob) loadRequestResources() ([]apiResource, *appError) {
+
+	req, err := getRequestById(m.requested_by)
+	if err != nil || req == nil {
+		return nil, err
+	}
+
+	return []apiResource{req}, nil
+}
+
+func (m *queueJob) loadTransitionResources() ([]apiResource, *appError) {
+
+	trs, err := getJobTransitions(m.id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []apiResource
+	for _, v := range trs {
+		rs = append(rs, v)
+	}
+
+	return rs, nil
+}
+
+func (m *queueJob) loadDependencyResources() ([]apiResource, *appError) {
+
+	jbs, err := getJobDependencies(m.id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []apiResource
+	for _, v := range jbs {
+		rs = append(rs, v)
+	}
+
+	return rs, nil
+}
+
+// jobs with higher priority are the first, the same priority is FIFO:
+func (m *queueJob) isBefore(jb *queueJob) bool {
+	if m.priority != jb.priority {
+		return m.priority > jb.priority
+	}
+	return m.seq < jb.seq
+}
+
+// container/heap implementation:
+type jobHeap []*queueJob
+
+func (m jobHeap) Len() int            { return len(m) }
+func (m jobHeap) Less(i, j int) bool  { return m[i].isBefore(m[j]) }
+func (m jobHeap) Swap(i, j int)       { m[i], m[j] = m[j], m[i] }
+func (m *jobHeap) Push(x interface{}) { *m = append(*m, x.(*queueJob)) }
+
+func (m *jobHeap) Pop() interface{} {
+	var old = *m
+	var jb = old[len(old)-1]
+	*m = old[:len(old)-1]
+	return jb
+}
+
 func newQueueDispatcher() *queueDispatcher {
-	return &queueDispatcher{
+	var m = &queueDispatcher{
 		jobQueue: make(chan *queueJob, globConfig.Base.Queue.JobChanBuffer),
 		pool:     make(chan chan *queueJob, globConfig.Base.Queue.WorkersCapacity),
 
+		blocked:  make(map[string]*queueJob),
+		finished: make(chan *queueJob, globConfig.Base.Queue.JobChanBuffer),
+
+		unchecked: make(map[string]*queueJob),
+
+		waiting:  make(map[uint8]*jobHeap),
+		running:  make(map[uint8]int),
+		released: make(chan *queueJob, globConfig.Base.Queue.Workers),
+		inflight: make(map[string]*queueJob),
+		retries:  make(map[*queueJob]*time.Timer),
+
+		control:  &queueControl{Workers: make(map[string]int)},
+		controls: make(chan *queueControl, 1),
+
+		status:   make(chan chan *queueStatus),
+		watchdog: newQueueWatchdog(),
+
+		started:    make(chan struct{}),
 		done:       make(chan struct{}, 1),
 		workerDone: make(chan struct{}, 1),
+		drained:    make(chan struct{}),
 	}
+
+	m.jobsCtx, m.cancelJobs = context.WithCancel(context.Background())
+	return m
 }
 
 func (m *queueDispatcher) getQueueChan() chan *queueJob {
 	return m.jobQueue
 }
 
+func (m *queueDispatcher) getDoneChan() chan *queueJob {
+	return m.finished
+}
+
+// workers are stopped and awaited by the drain:
 func (m *queueDispatcher) bootstrap() {
-	var wg sync.WaitGroup
-	wg.Add(globConfig.Base.Queue.Workers + 1)
+	defer close(m.drained)
+	close(m.started)
 
-	for i := 0; i < globConfig.Base.Queue.Workers; i++ {
-		go func(wg sync.WaitGroup) {
-			newQueueWorker(m).spawn()
-			wg.Done()
-		}(wg)
+	m.resize(globConfig.Base.Queue.Workers)
+	m.dispatch()
+}
+
+// Jobs are sent to the dispatcher only while it runs, so senders never hang after the drain.
+// The done channel is closed by the destruct only.
+func (m *queueDispatcher) submit(ch chan *queueJob, jb *queueJob) bool {
+	select {
+	case ch <- jb:
+		return true
+	case <-m.done:
+		return false
 	}
+}
 
-	go func(wg sync.WaitGroup) {
-		m.dispatch()
-		close(m.workerDone)
-		wg.Done()
-	}(wg)
+func (m *queueDispatcher) retryAfter(jb *queueJob, delay time.Duration) {
+	m.retriesMu.Lock()
+	defer m.retriesMu.Unlock()
 
-	wg.Wait()
+	if m.stopped {
+		jb.saveInterrupted()
+		return
+	}
+
+	m.retries[jb] = time.AfterFunc(delay, func() {
+		m.retriesMu.Lock()
+		delete(m.retries, jb)
+		m.retriesMu.Unlock()
+
+		jb.addToQueue()
+	})
 }
 
-func (m *queueDispatcher) dispatch() {
+// jobs of the stopped timers are returned; the fired ones are reset by addToQueue:
+func (m *queueDispatcher) stopRetries() []*queueJob {
+	m.retriesMu.Lock()
+	defer m.retriesMu.Unlock()
 
-	var buf *queueJob
-	var nextWorker chan *queueJob
+	m.stopped = true
 
-	for {
-		select {
-		case <-m.done:
-			return
-		case buf = <-m.jobQueue:
-			go func(job *queueJob) {
+	var jobs []*queueJob
+	for jb, timer := range m.retries {
+		if timer.Stop() {
+			jobs = append(jobs, jb)
+		}
+	}
+
+	m.retries = make(map[*queueJob]*time.Timer)
+	return jobs
+}
+
+// the pool is changed by the dispatch loop only:
+func (m *queueDispatcher) resize(size int) {
+
+	if size == m.size {
+		return
+	}
 
-				if err := job.stateUpdate(jobStatusPending); err != nil {
-					job.appendAppError(err)
-					return
-				}
+	globLogger.Info().Int("from", m.size).Int("to", size).Msg("The worker pool is being resized")
 
-				nextWorker = <-m.pool
-				nextWorker <- job
-			}(buf)
+	for ; m.size < size; m.size++ {
+		// stopping workers are kept instead of new ones:
+		if m.excess > 0 {
+			m.excess--
+			continue
 		}
+
+		m.lastWorker++
+		var worker = newQueueWorker(m, m.lastWorker)
+
+		m.workers.Add(1)
+		go func() {
+			defer m.workers.Done()
+			worker.spawn()
+		}()
 	}
 
-	// TODO: add sync.WaitGroup
-	// BUG: jobQueue without close()
+	for ; m.size > size; m.size-- {
+		m.excess++
+	}
 }
 
-func (m *queueDispatcher) destruct() {
-	close(m.done)
+func (m *queueDispatcher) applyControl(ctl *queueControl) {
+	select {
+	case m.controls <- ctl:
+	default:
+		// the dispatcher is busy, the state will be sent with the next leaser tick
+	}
 }
 
-func newQueueWorker(dp *queueDispatcher) *queueWorker {
-	return &queueWorker{
-		pool:  dp.pool,
-		inbox: make(chan *queueJob, globConfig.Base.Queue.WorkersCapacity),
+func (m *queueDispatcher) setControl(ctl *queueControl) {
 
-		done: dp.workerDone,
+	if m.control.isEqual(ctl) {
+		return
+	}
+
+	if m.control.Paused != ctl.Paused {
+		globLogger.Warn().Bool("paused", ctl.Paused).Msg("The queue dispatching has been changed")
 	}
+
+	globLogger.Info().Bool("paused", ctl.Paused).Strs("paused_actions", ctl.Paused_Actions).
+		Msg("The queue control state has been applied")
+
+	m.control = ctl
+	m.resize(ctl.getWorkers(globRaftStore.LocalId()))
 }
 
-func (m *queueWorker) spawn() {
+// Jobs are given to workers by priority, but only if the job action has a free slot.
+// The pool case is enabled only when there is a job to run, so idle workers wait
+// in the pool without busy loops.
+func (m *queueDispatcher) dispatch() {
 
-	defer close(m.inbox)
+	var recheck = time.NewTicker(globConfig.Base.Queue.JobRetryInterval)
+	defer recheck.Stop()
 
 	for {
-
-		m.pool <- m.inbox
+		var pool chan chan *queueJob
+		var next = m.getNextAction()
+		if next != nil || m.excess > 0 {
+			pool = m.pool
+		}
 
 		select {
 		case <-m.done:
+			m.drain()
 			return
-		case buf := <-m.inbox:
-			m.doJob(buf)
+		case jb := <-m.finished:
+			m.releaseDependents(jb)
+		case <-recheck.C:
+			m.recheckBlocked()
+		case jb := <-m.released:
+			m.release(jb)
+		case rsp := <-m.status:
+			rsp <- m.getStatus()
+		case ctl := <-m.controls:
+			m.setControl(ctl)
+		case jb := <-m.jobQueue:
+			if !m.isBlocked(jb) {
+				m.enqueue(jb)
+			}
+		case inbox := <-pool:
+			// the empty job stops the worker:
+			if m.excess > 0 {
+				m.excess--
+				inbox <- nil
+				continue
+			}
+
+			var jb = heap.Pop(m.waiting[*next]).(*queueJob)
+
+			m.running[jb.action]++
+			m.inflight[jb.id] = jb
+			inbox <- jb
 		}
 	}
 }
 
-func (m *queueWorker) doJob(jb *queueJob) {
-	globLogger.Debug().Uint8("job_code", jb.action).Str("code_human", jobActHumanDetail[jb.action]).
-		Msg("The worker received a new job!")
+func (m *queueDispatcher) release(jb *queueJob) {
+	m.running[jb.action]--
+	delete(m.inflight, jb.id)
+}
 
-	// get payload for job handler:
-	var payload map[string]interface{} = *jb.payload
+// New jobs are not accepted while the queue is draining (the queue channel is not closed,
+// because it has many writers). In-flight jobs are awaited for the grace period, then their
+// handlers are cancelled. All other jobs are reset in DB only when the workers have returned,
+// so the raft leader will assign them again and no job is run twice.
+func (m *queueDispatcher) drain() {
 
-	// match job handler and exec it:
-	switch jb.action {
-	case jobActHostCreate:
+	var grace = time.NewTimer(globConfig.Base.Queue.ShutdownGrace)
+	defer grace.Stop()
 
-		var host = payload["job_payload_host"].(*baseHost)
+	globLogger.Info().Int("jobs", len(m.inflight)).Dur("grace", globConfig.Base.Queue.ShutdownGrace).
+		Msg("The queue is draining, waiting for in-flight jobs...")
 
-		if e := host.resolveIpmiHostname(); e != nil {
-			jb.appendAppError(e)
-			return
+LOOP:
+	for len(m.inflight) != 0 {
+		select {
+		case jb := <-m.released:
+			m.release(jb)
+		case <-grace.C:
+			globLogger.Warn().Int("jobs", len(m.inflight)).Msg("The grace period has expired, in-flight jobs will be requeued!")
+			m.cancelJobs()
+			break LOOP
 		}
+	}
 
-		if e := host.updateOrCreate(jb.id); e != nil {
-			jb.appendAppError(e)
-			return
+	close(m.workerDone)
+	m.workers.Wait()
+	m.cancelJobs()
+
+	var left = m.stopRetries()
+	for _, v := range m.inflight {
+		left = append(left, v)
+	}
+
+	for _, jobs := range m.waiting {
+		left = append(left, *jobs...)
+	}
+
+	for _, v := range m.blocked {
+		left = append(left, v)
+	}
+
+	for _, v := range left {
+		v.saveInterrupted()
+	}
+
+	globLogger.Info().Int("left", len(left)).Msg("The queue has been drained")
+}
+
+func (m *queueDispatcher) enqueue(jb *queueJob) {
+
+	if m.waiting[jb.action] == nil {
+		m.waiting[jb.action] = new(jobHeap)
+	}
+
+	m.seq++
+	jb.seq = m.seq
+
+	heap.Push(m.waiting[jb.action], jb)
+}
+
+// the action of the most prior job which could be run now:
+func (m *queueDispatcher) getNextAction() *uint8 {
+
+	var next *queueJob
+	for action, jobs := range m.waiting {
+		if jobs.Len() == 0 || m.isActionLimited(action) || m.control.isActionPaused(action) {
+			continue
 		}
 
-		jb.stateUpdate(jobStatusDone)
+		if top := (*jobs)[0]; next == nil || top.isBefore(next) {
+			next = top
+		}
+	}
+
+	if next == nil {
+		return nil
+	}
 
-	case jobActRsviewParse:
+	return &next.action
+}
 
-		var port = payload["job_payload_port"].(*basePort)
+func (m *queueDispatcher) isActionLimited(action uint8) bool {
+	var limit = getJobConcurrency(action)
+	return limit > 0 && m.running[action] >= limit
+}
 
-		if e := port.parseRsviewProperties(); e != nil {
-			jb.appendAppError(e)
-			return
+// the job is parked until all of its dependencies are done:
+func (m *queueDispatcher) isBlocked(jb *queueJob) bool {
+
+	// the job is not lost on DB errors, it's checked again later:
+	deps, err := getJobDependencies(jb.id)
+	if err != nil {
+		globLogger.Warn().Str("job_id", jb.id).Msg("Could not check the job dependencies, the job is parked until the next check!")
+		m.blocked[jb.id], m.unchecked[jb.id] = jb, jb
+		return true
+	}
+
+	var blocked bool
+	for _, v := range deps {
+		if v.is_failed || v.state == jobStatusFailed {
+			globLogger.Warn().Str("job_id", jb.id).Str("dependency_id", v.id).Msg("The job dependency has failed!")
+			go jb.appendAppError(newAppError(errJobsDependencyFailed).log(nil, "Could not run the job because of the failed dependency!"))
+			return true
 		}
 
-		reqHostJob, e := getTinyJobByReqId(jb.requested_by, jobActHostCreate)
-		if e != nil {
-			jb.appendAppError(e)
-			return
+		if v.state != jobStatusDone {
+			blocked = true
 		}
+	}
 
-		if reqHostJob.state == jobStatusPending {
-			jb.stateUpdate(jobStatusBlocked)
+	if !blocked {
+		return false
+	}
+
+	if _, ok := m.blocked[jb.id]; !ok {
+		globLogger.Debug().Str("job_id", jb.id).Msg("The job is blocked by its dependencies")
+		jb.blockUpdate()
+	}
+
+	m.blocked[jb.id] = jb
+	return true
+}
+
+// the jobs which could not be checked again are parked in the new set:
+func (m *queueDispatcher) recheckBlocked() {
 
-			// TODO: sleep while job is Pending
-			// and set Pending state! XXX
+	var jobs = m.unchecked
+	m.unchecked = make(map[string]*queueJob)
+
+	for id, jb := range jobs {
+		delete(m.blocked, id)
+
+		if !m.isBlocked(jb) {
+			m.enqueue(jb)
 		}
+	}
+}
 
-		host, e := getTinyHostByJobId(reqHostJob.id)
-		if e != nil {
-			jb.appendAppError(e)
-			return
+func (m *queueDispatcher) releaseDependents(jb *queueJob) {
+
+	if len(m.blocked) == 0 {
+		return
+	}
+
+	ids, err := getJobDependents(jb.id)
+	if err != nil {
+		return
+	}
+
+	for _, v := range ids {
+		if dependent, ok := m.blocked[v]; ok {
+			delete(m.blocked, v)
+			delete(m.unchecked, v)
+			go dependent.addToQueue()
 		}
+	}
+}
+
+// the dispatcher state is owned by the dispatch loop, so it's requested through the channel:
+func (m *queueDispatcher) requestStatus() (*queueStatus, *appError) {
+
+	var rsp = make(chan *queueStatus, 1)
+
+	select {
+	case m.status <- rsp:
+		return <-rsp, nil
+	case <-time.After(5 * time.Second):
+		return nil, newAppError(errInternalCommonError).log(nil, "The queue dispatcher has not responded in time!")
+	}
+}
+
+// the dispatcher is drained before the return, if it has been started:
+func (m *queueDispatcher) destruct() {
+	m.watchdog.destruct()
+
+	select {
+	case <-m.started:
+	default:
+		return
+	}
+
+	close(m.done)
+	<-m.drained
+}
+
+func newQueueWorker(dp *queueDispatcher, id int) *queueWorker {
+	return &queueWorker{
+		id:  id,
+		ctx: dp.jobsCtx,
+
+		pool:     dp.pool,
+		inbox:    make(chan *queueJob, globConfig.Base.Queue.WorkersCapacity),
+		released: dp.released,
 
-		if host == nil {
-			err := newAppError(errHostsNotFound).log(nil, "Couldn't find a host from current request!")
-			jb.appendAppError(err)
+		done: dp.workerDone,
+	}
+}
+
+func (m *queueWorker) spawn() {
+
+	defer close(m.inbox)
+
+	for {
+
+		select {
+		case <-m.done:
 			return
+		case m.pool <- m.inbox:
 		}
 
-		if !port.compareLLDPWithHost(host.hostname) {
-			err := newAppError(errRsviewLLDPMismatch)
-			jb.appendAppError(err)
+		select {
+		case <-m.done:
 			return
+		case buf := <-m.inbox:
+			if buf == nil {
+				globLogger.Debug().Int("worker", m.id).Msg("The worker has been stopped by the pool resize")
+				return
+			}
+
+			m.doJob(buf)
+
+			// the action slot is free now, the drain does not wait for it after the grace period:
+			select {
+			case m.released <- buf:
+			case <-m.done:
+				return
+			}
 		}
+	}
+}
+
+func (m *queueWorker) doJob(jb *queueJob) {
+	globLogger.Debug().Uint8("job_code", jb.action).Str("code_human", jobActHumanDetail[jb.action]).
+		Msg("The worker received a new job!")
+
+	// the job has been given to another node, if the lease has been expired:
+	if !jb.hasLease() {
+		globLogger.Warn().Str("job_id", jb.id).Msg("The job lease has been lost, the job is skipped!")
+		return
+	}
+
+	h, err := getJobHandler(jb.action)
+	if err != nil {
+		jb.appendAppError(err)
+		return
+	}
 
-		if e = port.linkWithHost(host.id); e != nil {
-			jb.appendAppError(e)
+	jb.started_at, jb.worker = time.Now(), m.id
+	if err = jb.stateUpdate(jobStatusPending); err != nil {
+		jb.appendAppError(err)
+		return
+	}
+
+	if err = h.exec(m.ctx, jb); err != nil {
+		// the job has been cancelled by the drain, it will be reset in DB:
+		if m.ctx.Err() != nil {
+			globLogger.Warn().Str("job_id", jb.id).Msg("The job has been cancelled by the queue shutdown!")
 			return
 		}
 
-		// TODO: create task for server installation
+		jb.appendAppError(err)
+		return
+	}
 
-		jb.stateUpdate(jobStatusDone)
+	if err = jb.stateUpdate(jobStatusDone); err != nil {
+		return
+	}
 
-	default:
-		globLogger.Warn().Msg("Unknown job type!")
+	// rsview jobs are the final step of the install pipeline, they wait for the host creation:
+	if jb.action == jobActRsviewParse {
+		jb.notifyProvisioned()
 	}
 }
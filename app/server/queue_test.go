@@ -0,0 +1,104 @@
+package server
+
+import "time"
+import "strings"
+import "testing"
+
+func TestQueueDispatcherSubmit(t *testing.T) {
+
+	var dp = newQueueDispatcher()
+	var ch = make(chan *queueJob)
+
+	var sent = make(chan bool)
+	go func() { sent <- dp.submit(ch, &queueJob{id: "test"}) }()
+
+	// nobody reads the channel, so the sender waits for the dispatcher stop:
+	select {
+	case <-sent:
+		t.Fatal("submit() has returned before the dispatcher stop")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(dp.done)
+
+	select {
+	case ok := <-sent:
+		if ok {
+			t.Error("submit() = true after the dispatcher stop")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("submit() hangs after the dispatcher stop")
+	}
+}
+
+func TestQueueDispatcherStopRetries(t *testing.T) {
+
+	var dp = newQueueDispatcher()
+
+	var savedChan, savedQueue = globQueueChan, globQueue
+	globQueueChan, globQueue = make(chan *queueJob, 1), dp
+	defer func() { globQueueChan, globQueue = savedChan, savedQueue }()
+
+	var fired, waiting = &queueJob{id: "fired"}, &queueJob{id: "waiting"}
+	dp.retryAfter(fired, time.Millisecond)
+	dp.retryAfter(waiting, time.Hour)
+
+	select {
+	case jb := <-globQueueChan:
+		if jb != fired {
+			t.Fatalf("the job %s has been queued, want %s", jb.id, fired.id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the retry timer has not fired")
+	}
+
+	var jobs = dp.stopRetries()
+	if len(jobs) != 1 || jobs[0] != waiting {
+		t.Fatalf("stopRetries() = %d jobs, want the waiting one", len(jobs))
+	}
+
+	if !dp.stopped || len(dp.retries) != 0 {
+		t.Error("the retries are kept after stopRetries()")
+	}
+}
+
+// the job is not dropped on DB errors, it's parked until the dependencies could be checked:
+func TestQueueDispatcherIsBlockedLookupError(t *testing.T) {
+
+	var db = newSqlStubDB(t)
+	var dp = newQueueDispatcher()
+	var jb = &queueJob{id: "blocked", action: jobActRsviewParse}
+
+	db.setFailing("FROM job_dependencies")
+
+	if !dp.isBlocked(jb) {
+		t.Fatal("isBlocked() = false on the dependency lookup error")
+	}
+
+	if dp.blocked[jb.id] != jb || dp.unchecked[jb.id] != jb {
+		t.Fatal("the job has not been parked on the dependency lookup error")
+	}
+
+	// the database is still unavailable, so the job is parked again:
+	dp.recheckBlocked()
+	if dp.blocked[jb.id] != jb || dp.unchecked[jb.id] != jb {
+		t.Fatal("the job has been dropped by the failed recheck")
+	}
+
+	db.setFailing("")
+	dp.recheckBlocked()
+
+	if len(dp.blocked) != 0 || len(dp.unchecked) != 0 {
+		t.Error("the job without dependencies is still blocked after the recheck")
+	}
+
+	if jobs := dp.waiting[jb.action]; jobs == nil || jobs.Len() != 1 || (*jobs)[0] != jb {
+		t.Error("the job has not been queued after the recheck")
+	}
+
+	for _, query := range db.queries {
+		if strings.HasPrefix(query, "UPDATE jobs") || strings.Contains(query, "INTO errors") {
+			t.Errorf("the job has been changed in DB on the dependency lookup error: %.64q", query)
+		}
+	}
+}
@@ -1,19 +1,163 @@
 package server
 
+import "net"
+import "time"
 import "strings"
 import "net/http"
+import "database/sql"
 import _ "github.com/go-sql-driver/mysql"
 import "github.com/satori/go.uuid"
 
+// the length of the url column, longer urls are saved truncated:
+const requestUrlMaxLength = 2048
+
 type httpRequest struct {
 	id, link string
 	status   int
+	format   uint8
 	errors   []*appError
+
+	srcip, method, user_agent string
+	size                      int64
+	requested_at              time.Time
+
+	// the id of the key which has signed the request:
+	api_key string
+}
+
+type requestFilter struct {
+	srcip, url, user_agent string
+	api_key                string
+	status                 int
+	since, until           time.Time
+	limit, offset          int
+}
+
+func newRequestFilter() *requestFilter {
+	return &requestFilter{
+		limit: 100,
+	}
+}
+
+func getRequestsByFilter(f *requestFilter) ([]*httpRequest, *appError) {
+
+	var reqs []*httpRequest
+	var where []string
+	var args []interface{}
+
+	if f.srcip != "" {
+		where, args = append(where, "srcip = ?"), append(args, f.srcip)
+	}
+	if f.url != "" {
+		where, args = append(where, "url LIKE ?"), append(args, "%"+escapeSqlLike(f.url)+"%")
+	}
+	if f.user_agent != "" {
+		where, args = append(where, "user_agent LIKE ?"), append(args, "%"+escapeSqlLike(f.user_agent)+"%")
+	}
+	if f.api_key != "" {
+		where, args = append(where, "api_key = ?"), append(args, f.api_key)
+	}
+	if f.status != 0 {
+		where, args = append(where, "status = ?"), append(args, f.status)
+	}
+	if !f.since.IsZero() {
+		where, args = append(where, "requested_at >= ?"), append(args, f.since)
+	}
+	if !f.until.IsZero() {
+		where, args = append(where, "requested_at <= ?"), append(args, f.until)
+	}
+
+	var query = "SELECT id,srcip,method,size,url,status,user_agent,api_key,requested_at FROM requests"
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY requested_at DESC LIMIT ? OFFSET ?"
+	args = append(args, f.limit, f.offset)
+
+	rws, e := globSqlDB.Query(query, args...)
+	if e != nil {
+		return reqs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var req = new(httpRequest)
+		var apiKey sql.NullString
+
+		if e = rws.Scan(&req.id, &req.srcip, &req.method, &req.size, &req.link, &req.status, &req.user_agent, &apiKey, &req.requested_at); e != nil {
+			return reqs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		req.api_key = apiKey.String
+
+		reqs = append(reqs, req)
+	}
+
+	if rws.Err() != nil {
+		return reqs, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return reqs, nil
+}
+
+func escapeSqlLike(in string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(in)
+}
+
+func getRequestById(rId string) (*httpRequest, *appError) {
+
+	rws, e := globSqlDB.Query("SELECT srcip,method,size,url,status,user_agent,api_key,requested_at FROM requests WHERE id = ? LIMIT 2", rId)
+	if e != nil {
+		return nil, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	if !rws.Next() {
+		if rws.Err() != nil {
+			return nil, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+		}
+
+		return nil, nil
+	}
+
+	var req = &httpRequest{
+		id: rId,
+	}
+
+	var apiKey sql.NullString
+	if e = rws.Scan(&req.srcip, &req.method, &req.size, &req.link, &req.status, &req.user_agent, &apiKey, &req.requested_at); e != nil {
+		return nil, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+	}
+	req.api_key = apiKey.String
+
+	if rws.Next() {
+		return nil, newAppError(errInternalSqlError).log(nil, "Rows is not equal to 1. The DB has broken!")
+	}
+
+	return req, nil
+}
+
+// IPv6 peers have colons in the address, so the port is split by net:
+func getRemoteAddress(req *http.Request) string {
+
+	host, _, e := net.SplitHostPort(req.RemoteAddr)
+	if e != nil {
+		return req.RemoteAddr
+	}
+
+	return host
 }
 
 func (m *httpRequest) createAndSave(req *http.Request) (*httpRequest, error) {
 	m.id = uuid.NewV4().String()
 	m.link = req.RequestURI
+	if len(m.link) > requestUrlMaxLength {
+		m.link = m.link[:requestUrlMaxLength]
+	}
+	m.srcip = getRemoteAddress(req)
+	m.method = req.Method
+	m.user_agent = req.UserAgent()
 
 	stmt, e := globSqlDB.Prepare("INSERT INTO requests (id,srcip,method,size,url,status,user_agent) VALUES (?,?,?,?,?,?,?)")
 	if e != nil {
@@ -21,7 +165,7 @@ func (m *httpRequest) createAndSave(req *http.Request) (*httpRequest, error) {
 	}
 	defer stmt.Close()
 
-	if _, e = stmt.Exec(m.id, strings.Split(req.RemoteAddr, ":")[0], req.Method, req.ContentLength, m.link, m.status, req.UserAgent()); e != nil {
+	if _, e = stmt.Exec(m.id, m.srcip, m.method, req.ContentLength, m.link, m.status, m.user_agent); e != nil {
 		return m, e
 	}
 
@@ -30,14 +174,14 @@ func (m *httpRequest) createAndSave(req *http.Request) (*httpRequest, error) {
 }
 
 func (m *httpRequest) updateAndSave() {
-	stmt, e := globSqlDB.Prepare("UPDATE requests SET status = ? WHERE id = ?")
+	stmt, e := globSqlDB.Prepare("UPDATE requests SET status = ?, api_key = ? WHERE id = ?")
 	if e != nil {
 		globLogger.Error().Err(e).Msg("[REQUEST]: Could not prepare DB statement!")
 		return
 	}
 	defer stmt.Close()
 
-	if _, e := stmt.Exec(m.status, m.id); e != nil {
+	if _, e := stmt.Exec(m.status, getSqlString(m.api_key), m.id); e != nil {
 		globLogger.Error().Err(e).Msg("[REQUEST]: Could not execute DB statement!")
 		return
 	}
@@ -65,9 +209,8 @@ func (m *httpRequest) respondApiErrors() []*responseError {
 			Code:   int(v.code),
 			Status: v.getHttpStatusCode(),
 			Title:  v.getErrorTitle(),
-			Detail: v.getHumanDetails()})
-		//	Source: &errorSource{
-		//		Parameter: v.srcParam}})
+			Detail: v.getHumanDetails(),
+			Source: v.getResponseSource()})
 
 		if v.getHttpStatusCode() > m.status {
 			m.status = v.getHttpStatusCode()
@@ -92,3 +235,73 @@ func (m *httpRequest) saveErrors() *httpRequest {
 
 	return m
 }
+
+// apiResource interface implementation:
+func (m *httpRequest) getResourceType() string { return apiTypeRequest }
+func (m *httpRequest) getResourceId() string   { return m.id }
+
+func (m *httpRequest) getResourceAttributes() interface{} {
+	return &attributesRequest{
+		Api_Key:      m.api_key,
+		Srcip:        m.srcip,
+		Method:       m.method,
+		Size:         m.size,
+		Url:          m.link,
+		Status:       m.status,
+		User_Agent:   m.user_agent,
+		Requested_At: m.requested_at.Format(time.RFC3339),
+	}
+}
+
+func (m *httpRequest) getResourceRelations() []*resourceRelation {
+	return []*resourceRelation{
+		{name: "jobs", toMany: true, loader: m.loadJobResources},
+		{name: "errors", toMany: true, loader: m.loadErrorResources},
+		{name: "events", toMany: true, loader: m.loadEventResources},
+	}
+}
+
+func (m *httpRequest) loadJobResources() ([]apiResource, *appError) {
+
+	jbs, err := getJobsByRequestId(m.id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []apiResource
+	for _, v := range jbs {
+		rs = append(rs, v)
+	}
+
+	return rs, nil
+}
+
+func (m *httpRequest) loadErrorResources() ([]apiResource, *appError) {
+
+	aErrs, err := getAppErrorsByRequestId(m.id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []apiResource
+	for _, v := range aErrs {
+		rs = append(rs, v)
+	}
+
+	return rs, nil
+}
+
+func (m *httpRequest) loadEventResources() ([]apiResource, *appError) {
+
+	events, err := getAuditEventsByRequestId(m.id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []apiResource
+	for _, v := range events {
+		rs = append(rs, v)
+	}
+
+	return rs, nil
+}
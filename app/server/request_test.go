@@ -0,0 +1,87 @@
+package server
+
+import "strings"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "github.com/gorilla/context"
+
+func TestGetRemoteAddress(t *testing.T) {
+
+	var tests = []struct {
+		remoteAddr, addr string
+	}{
+		{"10.1.2.3:52000", "10.1.2.3"},
+		{"[2001:db8::1]:52000", "2001:db8::1"},
+		{"[::1]:80", "::1"},
+		{"10.1.2.3", "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+
+		var r = httptest.NewRequest("GET", "/v1/requests", nil)
+		r.RemoteAddr = tt.remoteAddr
+
+		if addr := getRemoteAddress(r); addr != tt.addr {
+			t.Errorf("getRemoteAddress(%q) = %q, want %q", tt.remoteAddr, addr, tt.addr)
+		}
+	}
+}
+
+// The revocation event is linked with the request, so the DELETE request must be saved first.
+// The key is revoked in the raft store, so only the database part of the handler is here.
+func TestKeyRevokeAuditEvent(t *testing.T) {
+
+	var db = newSqlStubDB(t)
+
+	var event *auditEvent
+	var handler = globApi.httpMiddlewareRequestLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req = context.Get(r, "internal_request").(*httpRequest)
+
+		event = newAuditEvent(req.id, auditActKeyRevoked, "8d4d5a6e-1f1c-4c55-9a36-3b8e5a3c2d11")
+		if err := event.save(); err != nil {
+			req.appendAppError(err)
+		}
+
+		if len(req.errors) != 0 {
+			t.Errorf("the request has failed with the code %d", req.errors[0].code)
+		}
+	}))
+
+	var r = httptest.NewRequest("DELETE", "/v1/keys/8d4d5a6e-1f1c-4c55-9a36-3b8e5a3c2d11", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if event == nil || !db.hasRow("requests", event.request_id) || !db.hasRow("events", event.id) {
+		t.Fatal("the revocation event has not been saved with its request")
+	}
+}
+
+func TestRequestSaveLongUrl(t *testing.T) {
+
+	var db = newSqlStubDB(t)
+
+	var tests = []struct {
+		url, link string
+	}{
+		{
+			"/v1/requests?filter[srcip]=2001:db8::1&filter[url]=/v1/keys&filter[status]=404" +
+				"&filter[api_key]=8d4d5a6e-1f1c-4c55-9a36-3b8e5a3c2d11&filter[since]=2018-07-01T00:00:00Z" +
+				"&filter[until]=2018-07-31T23:59:59Z&page[limit]=50&page[offset]=100",
+			"",
+		},
+		{"/v1/requests?filter[url]=" + strings.Repeat("a", 3*requestUrlMaxLength), ""},
+	}
+	tests[0].link, tests[1].link = tests[0].url, tests[1].url[:requestUrlMaxLength]
+
+	for _, tt := range tests {
+		req, e := new(httpRequest).createAndSave(httptest.NewRequest("GET", tt.url, nil))
+		if e != nil {
+			t.Errorf("createAndSave(%.64q) has failed: %v", tt.url, e)
+			continue
+		}
+
+		if req.link != tt.link || !db.hasRow("requests", req.id) {
+			t.Errorf("createAndSave(%.64q) has saved %.64q, want %.64q", tt.url, req.link, tt.link)
+		}
+	}
+}
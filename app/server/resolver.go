@@ -0,0 +1,192 @@
+package server
+
+import "net"
+import "sort"
+import "errors"
+import "regexp"
+import "context"
+import "strings"
+import "database/sql"
+import "encoding/json"
+
+const (
+	resolverPolicySingle = "single"
+	resolverPolicyTld    = "tld"
+	resolverPolicyRegexp = "regexp"
+	resolverPolicyFcrdns = "fcrdns"
+)
+
+type (
+	// net.Resolver is used by default, tests could give a local stub instead:
+	hostResolver interface {
+		LookupAddr(ctx context.Context, addr string) ([]string, error)
+		LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	}
+
+	// IPMI addresses could have several PTR records (aliases), the policy chooses one of them:
+	ipmiResolver struct {
+		lookup hostResolver
+		policy string
+		rexp   *regexp.Regexp
+	}
+
+	// the resolution is saved on the host job with the rejected PTR records:
+	hostResolution struct {
+		Policy   string              `json:"policy"`
+		Ptr      string              `json:"ptr,omitempty"`
+		Hostname string              `json:"hostname,omitempty"`
+		Project  string              `json:"project,omitempty"`
+		Rejected []*hostRejectedName `json:"rejected,omitempty"`
+	}
+	hostRejectedName struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	}
+)
+
+func newHostResolver() hostResolver {
+
+	var resolver = new(net.Resolver)
+
+	if globConfig.Base.DNSResolver != "" {
+		resolver.Dial = func(ctx context.Context, network, server string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, network, globConfig.Base.DNSResolver)
+		}
+	}
+
+	return resolver
+}
+
+func newIpmiResolver(lookup hostResolver) (*ipmiResolver, error) {
+
+	var m = &ipmiResolver{
+		lookup: lookup,
+		policy: globConfig.Base.Ipmi.Resolver.Policy,
+	}
+
+	switch m.policy {
+	case "":
+		m.policy = resolverPolicySingle
+	case resolverPolicySingle, resolverPolicyTld, resolverPolicyFcrdns:
+	case resolverPolicyRegexp:
+		var e error
+		if m.rexp, e = regexp.Compile(globConfig.Base.Ipmi.Resolver.HostnameRegexp); e != nil {
+			return nil, errors.New("Could not compile base/ipmi/resolver/hostname_regexp: " + e.Error())
+		}
+	default:
+		return nil, errors.New("Unknown IPMI resolver policy " + m.policy + "! Use single, tld, regexp or fcrdns.")
+	}
+
+	return m, nil
+}
+
+// The resolution is returned with errors too, so the rejected names are recorded anyway.
+func (m *ipmiResolver) resolve(ctx context.Context, ip net.IP) (*hostResolution, *appError) {
+
+	var res = &hostResolution{Policy: m.policy}
+
+	names, e := m.lookup.LookupAddr(ctx, ip.String())
+	if e != nil {
+		return nil, newAppError(errInternalCommonError).log(e, "Net lookup error!")
+	}
+
+	for i := range names {
+		names[i] = strings.TrimSuffix(names[i], ".")
+	}
+	sort.Strings(names)
+
+	var candidates []string
+	for _, v := range names {
+		if reason := m.getRejectReason(ctx, v, ip); reason != "" {
+			res.Rejected = append(res.Rejected, &hostRejectedName{Name: v, Reason: reason})
+			continue
+		}
+
+		candidates = append(candidates, v)
+	}
+
+	if len(candidates) == 0 {
+		ae := newAppError(errHostsPtrNotMatched)
+		return res, ae.log(nil, "No PTR record has been matched by the resolver policy!", ae.glCtx().Str("policy", m.policy))
+	}
+
+	if len(candidates) != 1 {
+		for _, v := range candidates {
+			res.Rejected = append(res.Rejected, &hostRejectedName{Name: v, Reason: "ambiguous"})
+		}
+
+		return res, newAppError(errHostsAmbiguousResolver).log(nil, "The resolver returned two or more hostnames!")
+	}
+
+	res.Ptr = candidates[0]
+	return res, nil
+}
+
+// an empty reason means the name is accepted by the policy:
+func (m *ipmiResolver) getRejectReason(ctx context.Context, name string, ip net.IP) string {
+
+	switch m.policy {
+	case resolverPolicyTld:
+		if !isIpmiDomainName(name) {
+			return "tld_mismatch"
+		}
+	case resolverPolicyRegexp:
+		if !m.rexp.MatchString(name) {
+			return "regexp_mismatch"
+		}
+	case resolverPolicyFcrdns:
+		addrs, e := m.lookup.LookupIPAddr(ctx, name)
+		if e != nil {
+			globLogger.Warn().Err(e).Str("name", name).Msg("[HOST]: Could not resolve the PTR name back")
+			return "not_forward_confirmed"
+		}
+
+		for _, v := range addrs {
+			if v.IP.Equal(ip) {
+				return ""
+			}
+		}
+
+		return "not_forward_confirmed"
+	}
+
+	return ""
+}
+
+// IPMI names are <hostname>.<HostnameTLD>, DNS names are case-insensitive and could be fully qualified:
+func isIpmiDomainName(name string) bool {
+	var parts = strings.SplitN(strings.TrimSuffix(name, "."), ".", 2)
+	return len(parts) == 2 && parts[0] != "" &&
+		strings.EqualFold(parts[1], strings.TrimSuffix(globConfig.Base.Ipmi.HostnameTLD, "."))
+}
+
+func (m *queueJob) saveResolution(res *hostResolution) *appError {
+
+	buf, e := json.Marshal(res)
+	if e != nil {
+		return newAppError(errInternalCommonError).log(e, "Could not marshal the host resolution!")
+	}
+
+	if _, e = globSqlDB.Exec("UPDATE jobs SET resolution = ? WHERE id = ?", buf, m.id); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	m.resolution = res
+	return nil
+}
+
+// broken resolutions are ignored, they are informational only:
+func parseHostResolution(buf sql.NullString) *hostResolution {
+
+	if !buf.Valid {
+		return nil
+	}
+
+	var res *hostResolution
+	if e := json.Unmarshal([]byte(buf.String), &res); e != nil {
+		globLogger.Warn().Err(e).Msg("Could not unmarshal the host resolution of the job!")
+		return nil
+	}
+
+	return res
+}
@@ -0,0 +1,184 @@
+package server
+
+import "net"
+import "errors"
+import "context"
+import "testing"
+
+// stubResolver answers from the local maps instead of DNS:
+type stubResolver struct {
+	ptr map[string][]string
+	a   map[string][]string
+}
+
+func (m *stubResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+
+	names, ok := m.ptr[addr]
+	if !ok {
+		return nil, errors.New("no PTR records for " + addr)
+	}
+
+	return append([]string(nil), names...), nil
+}
+
+func (m *stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+
+	addrs, ok := m.a[host]
+	if !ok {
+		return nil, errors.New("no A records for " + host)
+	}
+
+	var ips []net.IPAddr
+	for _, v := range addrs {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(v)})
+	}
+
+	return ips, nil
+}
+
+func TestIpmiResolver(t *testing.T) {
+
+	var stub = &stubResolver{
+		ptr: map[string][]string{
+			"10.0.0.1": {"web1.ipmi."},
+			"10.0.0.2": {"web2.ipmi.", "alias-web2.example.com."},
+			"10.0.0.3": {"db1.ipmi.", "db1-old.ipmi."},
+			"10.0.0.4": {"app1.example.com."},
+		},
+		a: map[string][]string{
+			"web1.ipmi":              {"10.0.0.1"},
+			"web2.ipmi":              {"10.0.0.2"},
+			"alias-web2.example.com": {"10.0.0.20"},
+			"db1.ipmi":               {"10.0.0.3"},
+			"db1-old.ipmi":           {"10.0.0.3"},
+		},
+	}
+
+	var tests = []struct {
+		name     string
+		policy   string
+		rexp     string
+		ip       string
+		ptr      string
+		code     uint8
+		rejected map[string]string
+	}{
+		{name: "single", policy: resolverPolicySingle, ip: "10.0.0.1", ptr: "web1.ipmi"},
+		{name: "single with aliases", policy: resolverPolicySingle, ip: "10.0.0.2", code: errHostsAmbiguousResolver,
+			rejected: map[string]string{"web2.ipmi": "ambiguous", "alias-web2.example.com": "ambiguous"}},
+		{name: "tld", policy: resolverPolicyTld, ip: "10.0.0.2", ptr: "web2.ipmi",
+			rejected: map[string]string{"alias-web2.example.com": "tld_mismatch"}},
+		{name: "tld without matches", policy: resolverPolicyTld, ip: "10.0.0.4", code: errHostsPtrNotMatched,
+			rejected: map[string]string{"app1.example.com": "tld_mismatch"}},
+		{name: "tld ambiguous", policy: resolverPolicyTld, ip: "10.0.0.3", code: errHostsAmbiguousResolver,
+			rejected: map[string]string{"db1.ipmi": "ambiguous", "db1-old.ipmi": "ambiguous"}},
+		{name: "regexp", policy: resolverPolicyRegexp, rexp: `^db[0-9]+\.ipmi$`, ip: "10.0.0.3", ptr: "db1.ipmi",
+			rejected: map[string]string{"db1-old.ipmi": "regexp_mismatch"}},
+		{name: "fcrdns", policy: resolverPolicyFcrdns, ip: "10.0.0.2", ptr: "web2.ipmi",
+			rejected: map[string]string{"alias-web2.example.com": "not_forward_confirmed"}},
+		{name: "fcrdns without forward records", policy: resolverPolicyFcrdns, ip: "10.0.0.4", code: errHostsPtrNotMatched,
+			rejected: map[string]string{"app1.example.com": "not_forward_confirmed"}},
+		{name: "lookup error", policy: resolverPolicySingle, ip: "10.0.0.9", code: errInternalCommonError},
+	}
+
+	var saved = globConfig.Base.Ipmi.Resolver
+	defer func() { globConfig.Base.Ipmi.Resolver = saved }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			globConfig.Base.Ipmi.Resolver.Policy, globConfig.Base.Ipmi.Resolver.HostnameRegexp = tt.policy, tt.rexp
+
+			resolver, e := newIpmiResolver(stub)
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			res, err := resolver.resolve(context.Background(), net.ParseIP(tt.ip))
+			if err != nil {
+				if err.code != tt.code {
+					t.Fatalf("resolve() has failed with the code %d, want %d", err.code, tt.code)
+				}
+			} else if tt.code != 0 {
+				t.Fatalf("resolve() must fail with the code %d", tt.code)
+			}
+
+			if res == nil {
+				if tt.code != errInternalCommonError {
+					t.Fatal("resolve() must return the resolution with the rejected names")
+				}
+				return
+			}
+
+			if res.Ptr != tt.ptr || res.Policy != tt.policy {
+				t.Errorf("resolve() = %s by %s, want %s by %s", res.Ptr, res.Policy, tt.ptr, tt.policy)
+			}
+
+			if len(res.Rejected) != len(tt.rejected) {
+				t.Fatalf("%d names are rejected, want %d", len(res.Rejected), len(tt.rejected))
+			}
+
+			for _, v := range res.Rejected {
+				if tt.rejected[v.Name] != v.Reason {
+					t.Errorf("%s is rejected with %q, want %q", v.Name, v.Reason, tt.rejected[v.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestNewIpmiResolver(t *testing.T) {
+
+	var saved = globConfig.Base.Ipmi.Resolver
+	defer func() { globConfig.Base.Ipmi.Resolver = saved }()
+
+	var tests = []struct {
+		policy, rexp string
+		valid        bool
+	}{
+		{"", "", true},
+		{resolverPolicyTld, "", true},
+		{resolverPolicyRegexp, `^[a-z]+[0-9]+\.ipmi$`, true},
+		{resolverPolicyRegexp, `(`, false},
+		{"random", "", false},
+	}
+
+	for _, tt := range tests {
+		globConfig.Base.Ipmi.Resolver.Policy, globConfig.Base.Ipmi.Resolver.HostnameRegexp = tt.policy, tt.rexp
+
+		if _, e := newIpmiResolver(new(stubResolver)); (e == nil) != tt.valid {
+			t.Errorf("newIpmiResolver() with the policy %q error = %v, valid %v", tt.policy, e, tt.valid)
+		}
+	}
+}
+
+func TestIsIpmiDomainName(t *testing.T) {
+
+	var saved = globConfig.Base.Ipmi.HostnameTLD
+	defer func() { globConfig.Base.Ipmi.HostnameTLD = saved }()
+
+	var tests = []struct {
+		tld, name string
+		valid     bool
+	}{
+		{"ipmi", "web1.ipmi", true},
+		{"ipmi", "web1.ipmi.", true},
+		{"ipmi", "WEB1.IPMI", true},
+		{"IPMI", "web1.ipmi.", true},
+		{"ipmi.example.com", "web1.Ipmi.Example.Com.", true},
+		{"ipmi.example.com.", "web1.ipmi.example.com", true},
+		{"ipmi", "ipmi", false},
+		{"ipmi", ".ipmi", false},
+		{"ipmi", "web1.ipmi..", false},
+		{"ipmi", "web1.ipmi.example.com", false},
+		{"ipmi", "web1.example.com", false},
+	}
+
+	for _, tt := range tests {
+		globConfig.Base.Ipmi.HostnameTLD = tt.tld
+
+		if valid := isIpmiDomainName(tt.name); valid != tt.valid {
+			t.Errorf("isIpmiDomainName(%q) with the TLD %q = %v, want %v", tt.name, tt.tld, valid, tt.valid)
+		}
+	}
+}
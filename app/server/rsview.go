@@ -1,6 +1,7 @@
 package server
 
 import "io"
+import "context"
 import "crypto/tls"
 
 import "net"
@@ -131,7 +132,7 @@ func (m *rsviewClient) testRsviewClient(rBody io.ReadCloser) *appError {
 	return newAppError(errRsviewAuthTestFail).log(nil, "Client test failed!")
 }
 
-func (m *rsviewClient) getPortAttributes(mac net.HardwareAddr) ([]string, *appError) {
+func (m *rsviewClient) getPortAttributes(ctx context.Context, mac net.HardwareAddr) ([]string, *appError) {
 
 	rqUrl, e := url.Parse(globConfig.Base.Rsview.Url)
 
@@ -162,6 +163,9 @@ func (m *rsviewClient) getPortAttributes(mac net.HardwareAddr) ([]string, *appEr
 		return nil, newAppError(errInternalCommonError).log(e, "Could not create new httpRequest!")
 	}
 
+	// the request is cancelled with the job:
+	rq = rq.WithContext(ctx)
+
 	// mask our request
 	rq.Header.Set("User-Agent", "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:58.0) Gecko/20100101 Firefox/58.0")
 	rq.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
@@ -0,0 +1,301 @@
+package server
+
+import "fmt"
+import "sort"
+import "time"
+import "errors"
+import "strconv"
+import "strings"
+import "github.com/satori/go.uuid"
+
+// the last fired minute of every schedule is replicated, so a new leader doesn't fire it twice:
+const schedulesBucket = "schedules"
+
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// scheduled jobs are created by the request of the scheduler:
+var scheduledJobs = map[string]func(reqId *string) *appError{
+	"server_ping":        scheduleServerPing,
+	"rsview_rescan":      scheduleRsviewRescan,
+	"requests_purge":     scheduleRequestsPurge,
+	"stale_hosts_report": scheduleStaleHostsReport,
+	"notify_digest":      scheduleNotifyDigest,
+}
+
+type (
+	// Fields are bit sets of allowed values. As in cron, the day matches by the day
+	// of the month or by the day of the week if both of them are restricted.
+	cronSchedule struct {
+		minute, hour, dom, month, dow uint64
+		domStar, dowStar              bool
+	}
+
+	jobSchedule struct {
+		name string
+		spec string
+		cron *cronSchedule
+	}
+
+	jobScheduler struct {
+		schedules []*jobSchedule
+		done      chan struct{}
+	}
+)
+
+func newJobScheduler() (*jobScheduler, error) {
+
+	var m = &jobScheduler{
+		done: make(chan struct{}, 1),
+	}
+
+	if !globConfig.Base.Scheduler.Enabled {
+		return m, nil
+	}
+
+	for name, spec := range globConfig.Base.Scheduler.Schedules {
+		if _, ok := scheduledJobs[name]; !ok {
+			return nil, errors.New("Unknown schedule " + name + " in the scheduler configuration!")
+		}
+
+		// empty specs disable the default schedules:
+		if spec == "" {
+			continue
+		}
+
+		cron, e := parseCronSchedule(spec)
+		if e != nil {
+			return nil, fmt.Errorf("Could not parse the schedule %s: %s", name, e)
+		}
+
+		m.schedules = append(m.schedules, &jobSchedule{name: name, spec: spec, cron: cron})
+	}
+
+	sort.Slice(m.schedules, func(i, j int) bool { return m.schedules[i].name < m.schedules[j].name })
+	return m, nil
+}
+
+// schedules are checked at the start of every minute:
+func (m *jobScheduler) run() {
+
+	if len(m.schedules) == 0 {
+		return
+	}
+
+	for {
+		var now = time.Now()
+		var next = now.Truncate(time.Minute).Add(time.Minute)
+
+		select {
+		case <-m.done:
+			return
+		case <-time.After(next.Sub(now)):
+			m.fire(next)
+		}
+	}
+}
+
+func (m *jobScheduler) destruct() {
+	close(m.done)
+}
+
+// only the raft leader fires schedules, all other nodes get the jobs through leases:
+func (m *jobScheduler) fire(t time.Time) {
+
+	if !globRaftStore.IsLeader() {
+		return
+	}
+
+	for _, v := range m.schedules {
+		if !v.cron.isMatched(t) || v.isFired(t) {
+			continue
+		}
+
+		globLogger.Info().Str("schedule", v.name).Str("spec", v.spec).Msg("The schedule has been fired")
+
+		reqId, err := newSchedulerRequest(v.name)
+		if err != nil {
+			continue
+		}
+
+		if err = scheduledJobs[v.name](&reqId); err != nil {
+			globLogger.Error().Str("schedule", v.name).Msg("Could not create scheduled jobs!")
+			continue
+		}
+
+		// the schedule is fired again by the next tick (or the next leader) if the jobs have not been created:
+		v.markFired(t)
+	}
+}
+
+func (m *jobSchedule) isFired(t time.Time) bool {
+
+	last, e := strconv.ParseInt(globRaftStore.Get(schedulesBucket, m.name), 10, 64)
+	return e == nil && last >= t.Unix()
+}
+
+func (m *jobSchedule) markFired(t time.Time) *appError {
+
+	if e := globRaftStore.Set(schedulesBucket, m.name, strconv.FormatInt(t.Unix(), 10)); e != nil {
+		return newAppError(errInternalRaftError).log(e, "Could not save the schedule state!")
+	}
+
+	return nil
+}
+
+// jobs must be linked with a request, so every fire is saved as a request of the scheduler:
+func newSchedulerRequest(name string) (string, *appError) {
+
+	var id = uuid.NewV4().String()
+
+	if _, e := globSqlDB.Exec("INSERT INTO requests (id,srcip,method,size,url,status,user_agent) VALUES (?,?,?,?,?,?,?)",
+		id, "127.0.0.1", "CRON", 0, "schedule:"+name, 200, "ks-installer/"+appVersion+" scheduler"); e != nil {
+		return "", newAppError(errInternalSqlError).log(e, "Could not save the scheduler request!")
+	}
+
+	return id, nil
+}
+
+// Scheduled jobs:
+func scheduleServerPing(reqId *string) *appError {
+	_, err := newQueueJob(reqId, jobActServerPing, jobPriorityLow, newEmptyJobPayload())
+	return err
+}
+
+func scheduleRsviewRescan(reqId *string) *appError {
+
+	ports, err := getLinkedPorts()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range ports {
+		if _, err = newQueueJob(reqId, jobActRsviewRescan, jobPriorityLow, newPortJobPayload(v)); err != nil {
+			return err
+		}
+	}
+
+	globLogger.Info().Int("ports", len(ports)).Msg("Known ports have been scheduled for the rsview re-scan")
+	return nil
+}
+
+func scheduleRequestsPurge(reqId *string) *appError {
+	_, err := newQueueJob(reqId, jobActRequestsPurge, jobPriorityLow, newEmptyJobPayload())
+	return err
+}
+
+func scheduleStaleHostsReport(reqId *string) *appError {
+	_, err := newQueueJob(reqId, jobActHostsReport, jobPriorityLow, newEmptyJobPayload())
+	return err
+}
+
+func scheduleNotifyDigest(reqId *string) *appError {
+	_, err := newQueueJob(reqId, jobActNotifyDigest, jobPriorityNormal, newEmptyJobPayload())
+	return err
+}
+
+// Cron schedules:
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+
+	if v, ok := cronDescriptors[spec]; ok {
+		spec = v
+	}
+
+	var fields = strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.New("the schedule must have 5 fields: minute hour day-of-month month day-of-week")
+	}
+
+	var m = new(cronSchedule)
+	var e error
+
+	if m.minute, e = parseCronField(fields[0], 0, 59); e != nil {
+		return nil, e
+	}
+	if m.hour, e = parseCronField(fields[1], 0, 23); e != nil {
+		return nil, e
+	}
+	if m.dom, e = parseCronField(fields[2], 1, 31); e != nil {
+		return nil, e
+	}
+	if m.month, e = parseCronField(fields[3], 1, 12); e != nil {
+		return nil, e
+	}
+
+	// sunday is 0 or 7:
+	if m.dow, e = parseCronField(fields[4], 0, 7); e != nil {
+		return nil, e
+	}
+	if m.dow&(1<<7) != 0 {
+		m.dow |= 1
+	}
+
+	m.domStar, m.dowStar = fields[2] == "*", fields[4] == "*"
+	return m, nil
+}
+
+// the field is a list of values, ranges and steps: "*", "5", "1-5", "*/15", "1-30/2", "1,15"
+func parseCronField(field string, min, max int) (uint64, error) {
+
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+
+		var step = 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var e error
+			if step, e = strconv.Atoi(part[i+1:]); e != nil || step <= 0 {
+				return 0, errors.New("invalid step in the field " + field)
+			}
+			part = part[:i]
+		}
+
+		var from, to = min, max
+		if part != "*" {
+			var e error
+			var bounds = strings.SplitN(part, "-", 2)
+
+			if from, e = strconv.Atoi(bounds[0]); e != nil {
+				return 0, errors.New("invalid value in the field " + field)
+			}
+
+			to = from
+			if len(bounds) == 2 {
+				if to, e = strconv.Atoi(bounds[1]); e != nil {
+					return 0, errors.New("invalid range in the field " + field)
+				}
+			} else if step != 1 {
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("the field %s is out of range %d-%d", field, min, max)
+		}
+
+		for i := from; i <= to; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+func (m *cronSchedule) isMatched(t time.Time) bool {
+
+	if m.minute&(1<<uint(t.Minute())) == 0 || m.hour&(1<<uint(t.Hour())) == 0 || m.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	var dom = m.dom&(1<<uint(t.Day())) != 0
+	var dow = m.dow&(1<<uint(t.Weekday())) != 0
+
+	if m.domStar || m.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
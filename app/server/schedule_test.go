@@ -0,0 +1,89 @@
+package server
+
+import "time"
+import "testing"
+
+func TestParseCronSchedule(t *testing.T) {
+
+	var tests = []struct {
+		spec    string
+		invalid bool
+	}{
+		{spec: "*/5 * * * *"},
+		{spec: "0 3 * * *"},
+		{spec: "0-30/10 8-18 1,15 * 1-5"},
+		{spec: "0 0 * * 7"},
+		{spec: "@daily"},
+		{spec: "* * * *", invalid: true},
+		{spec: "60 * * * *", invalid: true},
+		{spec: "*/0 * * * *", invalid: true},
+		{spec: "5-1 * * * *", invalid: true},
+		{spec: "0 0 0 * *", invalid: true},
+		{spec: "0 0 * 13 *", invalid: true},
+		{spec: "0 0 * * 8", invalid: true},
+		{spec: "a * * * *", invalid: true},
+		{spec: "@yearly", invalid: true},
+	}
+
+	for _, tt := range tests {
+		if _, e := parseCronSchedule(tt.spec); (e != nil) != tt.invalid {
+			t.Errorf("parseCronSchedule(%q) error = %v, invalid %v", tt.spec, e, tt.invalid)
+		}
+	}
+}
+
+func TestCronScheduleIsMatched(t *testing.T) {
+
+	// 2018-07-01 is sunday, 2018-07-02 is monday:
+	var at = func(s string) time.Time {
+		tm, e := time.Parse("2006-01-02 15:04", s)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return tm
+	}
+
+	var tests = []struct {
+		name    string
+		spec    string
+		time    string
+		matched bool
+	}{
+		{"step", "*/15 * * * *", "2018-07-02 10:45", true},
+		{"step miss", "*/15 * * * *", "2018-07-02 10:44", false},
+		{"range with step", "10-40/10 * * * *", "2018-07-02 10:30", true},
+		{"range with step miss", "10-40/10 * * * *", "2018-07-02 10:50", false},
+		{"value with step", "5/20 * * * *", "2018-07-02 10:45", true},
+		{"list", "0 8,12,18 * * *", "2018-07-02 12:00", true},
+		{"list miss", "0 8,12,18 * * *", "2018-07-02 13:00", false},
+		{"range", "0 9-17 * * *", "2018-07-02 17:00", true},
+		{"range miss", "0 9-17 * * *", "2018-07-02 18:00", false},
+		{"dow 7 is sunday", "0 0 * * 7", "2018-07-01 00:00", true},
+		{"dow 0 is sunday", "0 0 * * 0", "2018-07-01 00:00", true},
+		{"dow 7 is not monday", "0 0 * * 7", "2018-07-02 00:00", false},
+		{"dom or dow by dom", "0 0 15 * 1", "2018-07-15 00:00", true},
+		{"dom or dow by dow", "0 0 15 * 1", "2018-07-02 00:00", true},
+		{"dom or dow miss", "0 0 15 * 1", "2018-07-03 00:00", false},
+		{"dom with star dow", "0 0 15 * *", "2018-07-02 00:00", false},
+		{"dow with star dom", "0 0 * * 1", "2018-07-03 00:00", false},
+		{"month", "0 0 1 7 *", "2018-07-01 00:00", true},
+		{"month miss", "0 0 1 8 *", "2018-07-01 00:00", false},
+		{"daily", "@daily", "2018-07-02 00:00", true},
+		{"daily miss", "@daily", "2018-07-02 00:01", false},
+		{"weekly", "@weekly", "2018-07-01 00:00", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			cron, e := parseCronSchedule(tt.spec)
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			if matched := cron.isMatched(at(tt.time)); matched != tt.matched {
+				t.Errorf("%q is matched at %s = %v, want %v", tt.spec, tt.time, matched, tt.matched)
+			}
+		})
+	}
+}
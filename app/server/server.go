@@ -1,9 +1,11 @@
 package server
 
 import "database/sql"
+import "text/template"
 import (
 	"github.com/MindHunter86/ks-installer/core/boltdb"
 	"github.com/MindHunter86/ks-installer/core/config"
+	"github.com/MindHunter86/ks-installer/core/raft"
 	"github.com/rs/zerolog"
 )
 
@@ -17,26 +19,53 @@ var (
 	globApi       *apiController
 	globSqlDB     *sql.DB
 	globBoldDB    *boltdb.BoltDB
+	globRaftStore *raft.Store
 	globQueueChan chan *queueJob
+	globJobsDone  chan *queueJob
+	globQueue     *queueDispatcher
 	globRsview    *rsviewClient
 	globPuppet    *puppetClient
+	globIcq       *icqClient
+	globKickstart *template.Template
+	globNotifier  *notifyDispatcher
+	globResolver  *ipmiResolver
 )
 
 type App struct {
-	queueDp *queueDispatcher
+	queueDp   *queueDispatcher
+	leaser    *jobLeaser
+	scheduler *jobScheduler
 }
 
-func NewApp(log *zerolog.Logger, config *config.SysConfig, bolt *boltdb.BoltDB) *App {
+func NewApp(log *zerolog.Logger, config *config.SysConfig, bolt *boltdb.BoltDB, store *raft.Store) *App {
 	globConfig = config
 	globBoldDB = bolt
+	globRaftStore = store
 	globLogger = log
 	return new(App)
 }
 
 // Common methods:
 func (m *App) Construct() (*App, error) {
+	registerJobHandlers()
+
 	m.queueDp = newQueueDispatcher()
 	globQueueChan = m.queueDp.getQueueChan()
+	globJobsDone = m.queueDp.getDoneChan()
+	globQueue = m.queueDp
+
+	m.leaser = newJobLeaser()
+
+	var e error
+	var fwd *raftForwarder
+	if fwd, e = newRaftForwarder(); e != nil {
+		return nil, e
+	}
+	globRaftStore.SetForwarder(fwd.forward)
+
+	if m.scheduler, e = newJobScheduler(); e != nil {
+		return nil, e
+	}
 
 	var err *appError
 	globRsview, err = newRsviewClient()
@@ -44,21 +73,49 @@ func (m *App) Construct() (*App, error) {
 		globLogger.Error().Str("err", apiErrorsDetail[err.code]).Msg("RSVIEW ERROR!")
 	}
 
+	if globResolver, e = newIpmiResolver(newHostResolver()); e != nil {
+		return nil, e
+	}
+
+	if globIcq, e = newIcqClient(); e != nil {
+		return nil, e
+	}
+
 	globPuppet = newPuppetClient()
 	if e := globPuppet.parseEndpoints(); e != nil {
 		return nil, e
 	}
 
+	if globKickstart, e = parseKickstartTemplate(); e != nil {
+		return nil, e
+	}
+
+	if globNotifier, e = newNotifyDispatcher(); e != nil {
+		return nil, e
+	}
+
 	return m, nil
 }
 
 func (m *App) Bootstrap() error {
+	// the queue channel is blocked until the dispatcher is started:
+	go m.leaser.run()
+	go m.queueDp.watchdog.run()
+	go m.scheduler.run()
+
 	m.queueDp.bootstrap()
 	return nil
 }
 
+// New jobs are not claimed on shutdown, in-flight jobs are finished (or requeued) and
+// only then the node leaves the cluster:
 func (m *App) Destruct() error {
+	m.scheduler.destruct()
+	m.leaser.destruct()
 	m.queueDp.destruct()
+
+	// the error is logged, the leases will be expired by the TTL then:
+	m.leaser.deregister()
 	return nil
 }
 
@@ -0,0 +1,17 @@
+package server
+
+import "os"
+import "testing"
+import "github.com/rs/zerolog"
+import "github.com/MindHunter86/ks-installer/core/config"
+
+// tests use the default configuration and a silent logger, the database is not available:
+func TestMain(m *testing.M) {
+
+	var logger = zerolog.Nop()
+
+	globLogger = &logger
+	globConfig = config.NewSysConfigWithDefaults()
+
+	os.Exit(m.Run())
+}
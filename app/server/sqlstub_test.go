@@ -0,0 +1,205 @@
+package server
+
+import "io"
+import "fmt"
+import "sort"
+import "sync"
+import "regexp"
+import "strings"
+import "testing"
+import "context"
+import "io/ioutil"
+import "path/filepath"
+import "database/sql"
+import "database/sql/driver"
+
+// The stub database has the schema of the migrations without MySQL. It checks the column lengths
+// and the foreign keys of the inserted rows, so the queries which are rejected by MySQL fail in
+// tests too. Other queries are accepted and return no rows.
+type (
+	sqlStubDB struct {
+		sync.Mutex
+
+		// table -> column -> the length of VARCHAR and CHAR columns:
+		columns map[string]map[string]int
+		// table -> column -> the referenced table (by its id):
+		foreignKeys map[string]map[string]string
+		// table -> the ids of the inserted rows:
+		rows map[string]map[string]bool
+
+		queries []string
+
+		// the queries with the substring fail as if the database is unavailable:
+		failing string
+	}
+
+	sqlStubConn struct{ db *sqlStubDB }
+	sqlStubStmt struct {
+		db    *sqlStubDB
+		query string
+	}
+	sqlStubTx   struct{}
+	sqlStubRows struct{}
+)
+
+var (
+	sqlStubTableRegexp  = regexp.MustCompile("(?is)^(CREATE TABLE IF NOT EXISTS|ALTER TABLE|DROP TABLE IF EXISTS) `ks-installer`\\.`(\\w+)`(.*)$")
+	sqlStubColumnRegexp = regexp.MustCompile("(?i)`(\\w+)` (?:VAR)?CHAR\\((\\d+)\\)")
+	sqlStubFKRegexp     = regexp.MustCompile("(?is)FOREIGN KEY \\(`(\\w+)`\\)\\s+REFERENCES `ks-installer`\\.`(\\w+)` \\(`id`\\)")
+	sqlStubInsertRegexp = regexp.MustCompile("(?is)^INSERT (?:IGNORE )?INTO (\\w+) \\(([^)]+)\\) VALUES")
+)
+
+// the global database is replaced with the stub for the test:
+func newSqlStubDB(t *testing.T) *sqlStubDB {
+
+	files, e := filepath.Glob("../../extras/migrations/*.up.sql")
+	if e != nil || len(files) == 0 {
+		t.Fatalf("Could not find the migrations: %v", e)
+	}
+	sort.Strings(files)
+
+	var db = &sqlStubDB{
+		columns:     make(map[string]map[string]int),
+		foreignKeys: make(map[string]map[string]string),
+		rows:        make(map[string]map[string]bool),
+	}
+
+	for _, file := range files {
+		buf, e := ioutil.ReadFile(file)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		for _, stmt := range strings.Split(string(buf), ";") {
+			db.migrate(strings.TrimSpace(stmt))
+		}
+	}
+
+	var saved = globSqlDB
+	globSqlDB = sql.OpenDB(db)
+	t.Cleanup(func() { globSqlDB.Close(); globSqlDB = saved })
+
+	return db
+}
+
+func (m *sqlStubDB) migrate(stmt string) {
+
+	var match = sqlStubTableRegexp.FindStringSubmatch(stmt)
+	if match == nil {
+		return
+	}
+
+	var table, body = match[2], match[3]
+
+	if strings.HasPrefix(strings.ToUpper(match[1]), "DROP") {
+		delete(m.columns, table)
+		delete(m.foreignKeys, table)
+		return
+	}
+
+	if m.columns[table] == nil {
+		m.columns[table], m.foreignKeys[table] = make(map[string]int), make(map[string]string)
+	}
+
+	// CHANGE COLUMN has the old and the new names, the last one is matched:
+	for _, column := range sqlStubColumnRegexp.FindAllStringSubmatch(body, -1) {
+		var size int
+		fmt.Sscan(column[2], &size)
+		m.columns[table][column[1]] = size
+	}
+
+	for _, fk := range sqlStubFKRegexp.FindAllStringSubmatch(body, -1) {
+		m.foreignKeys[table][fk[1]] = fk[2]
+	}
+}
+
+func (m *sqlStubDB) exec(query string, args []driver.Value) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.queries = append(m.queries, query)
+
+	if m.failing != "" && strings.Contains(query, m.failing) {
+		return fmt.Errorf("Lock wait timeout exceeded; try restarting transaction")
+	}
+
+	var match = sqlStubInsertRegexp.FindStringSubmatch(query)
+	if match == nil {
+		return nil
+	}
+
+	var table, columns = match[1], strings.Split(match[2], ",")
+	if m.columns[table] == nil {
+		return fmt.Errorf("Table 'ks-installer.%s' doesn't exist", table)
+	}
+
+	if len(columns) != len(args) {
+		return fmt.Errorf("Column count doesn't match value count for the table %s", table)
+	}
+
+	for i, column := range columns {
+		column = strings.TrimSpace(column)
+
+		value, ok := args[i].(string)
+		if !ok {
+			continue
+		}
+
+		if size, ok := m.columns[table][column]; ok && len(value) > size {
+			return fmt.Errorf("Data too long for column '%s' at row 1", column)
+		}
+
+		if ref, ok := m.foreignKeys[table][column]; ok && !m.rows[ref][value] {
+			return fmt.Errorf("Cannot add or update a child row: a foreign key constraint fails (%s.%s)", table, column)
+		}
+	}
+
+	for i, column := range columns {
+		if id, ok := args[i].(string); ok && strings.TrimSpace(column) == "id" {
+			if m.rows[table] == nil {
+				m.rows[table] = make(map[string]bool)
+			}
+			m.rows[table][id] = true
+		}
+	}
+
+	return nil
+}
+
+func (m *sqlStubDB) setFailing(substr string) {
+	m.Lock()
+	defer m.Unlock()
+	m.failing = substr
+}
+
+func (m *sqlStubDB) hasRow(table, id string) bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.rows[table][id]
+}
+
+// driver.Connector methods:
+func (m *sqlStubDB) Connect(context.Context) (driver.Conn, error) { return &sqlStubConn{db: m}, nil }
+func (m *sqlStubDB) Driver() driver.Driver                        { return nil }
+
+func (m *sqlStubConn) Prepare(query string) (driver.Stmt, error) {
+	return &sqlStubStmt{db: m.db, query: query}, nil
+}
+func (m *sqlStubConn) Close() error              { return nil }
+func (m *sqlStubConn) Begin() (driver.Tx, error) { return sqlStubTx{}, nil }
+
+func (m *sqlStubStmt) Close() error  { return nil }
+func (m *sqlStubStmt) NumInput() int { return -1 }
+func (m *sqlStubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), m.db.exec(m.query, args)
+}
+func (m *sqlStubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return sqlStubRows{}, m.db.exec(m.query, args)
+}
+
+func (sqlStubTx) Commit() error   { return nil }
+func (sqlStubTx) Rollback() error { return nil }
+
+func (sqlStubRows) Columns() []string         { return nil }
+func (sqlStubRows) Close() error              { return nil }
+func (sqlStubRows) Next([]driver.Value) error { return io.EOF }
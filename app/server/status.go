@@ -0,0 +1,167 @@
+package server
+
+import "fmt"
+import "sort"
+import "bytes"
+import "time"
+
+// reasons why the job is not running yet:
+const (
+	queueWaitDependencies = "dependencies"
+	queueWaitActionLimit  = "concurrency_limit"
+	queueWaitNoWorker     = "no_free_worker"
+	queueWaitDispatching  = "dispatching"
+	queueWaitPaused       = "paused"
+)
+
+type queueStatus struct {
+	workers int
+	idle    int
+	control *queueControl
+	actions []*queueActionStatus
+	waiting []*queueWaitingJob
+
+	watchdog *attributesWatchdog
+}
+
+func (m *queueDispatcher) getStatus() *queueStatus {
+
+	var status = &queueStatus{
+		workers: m.size,
+		idle:    len(m.pool),
+		control: m.control,
+
+		watchdog: m.watchdog.getStatus(),
+	}
+
+	var actions = make(map[uint8]*queueActionStatus)
+	var getAction = func(action uint8) *queueActionStatus {
+		if _, ok := actions[action]; !ok {
+			actions[action] = &queueActionStatus{
+				action:  action,
+				Action:  jobActHumanDetail[action],
+				Limit:   getJobConcurrency(action),
+				Running: m.running[action],
+			}
+		}
+		return actions[action]
+	}
+
+	for action := range m.running {
+		getAction(action)
+	}
+
+	for action, jobs := range m.waiting {
+		var reason = queueWaitDispatching
+		switch {
+		case m.control.isActionPaused(action):
+			reason = queueWaitPaused
+		case m.isActionLimited(action):
+			reason = queueWaitActionLimit
+		case status.idle == 0:
+			reason = queueWaitNoWorker
+		}
+
+		getAction(action).Waiting += jobs.Len()
+		for _, v := range *jobs {
+			status.waiting = append(status.waiting, newQueueWaitingJob(v, reason))
+		}
+	}
+
+	for _, v := range m.blocked {
+		getAction(v.action).Blocked++
+		status.waiting = append(status.waiting, newQueueWaitingJob(v, queueWaitDependencies))
+	}
+
+	for _, v := range actions {
+		status.actions = append(status.actions, v)
+	}
+
+	sort.Slice(status.actions, func(i, j int) bool { return status.actions[i].Action < status.actions[j].Action })
+	sort.Slice(status.waiting, func(i, j int) bool { return status.waiting[i].jb.isBefore(status.waiting[j].jb) })
+
+	return status
+}
+
+func newQueueWaitingJob(jb *queueJob, reason string) *queueWaitingJob {
+	return &queueWaitingJob{
+		Job_Id:     jb.id,
+		Action:     jb.getHumanAction(),
+		Priority:   jb.getHumanPriority(),
+		Reason:     reason,
+		Created_At: jb.created_at.Format(time.RFC3339),
+		jb:         jb,
+	}
+}
+
+// apiResource interface implementation:
+func (m *queueStatus) getResourceType() string { return apiTypeQueue }
+func (m *queueStatus) getResourceId() string   { return "local" }
+
+func (m *queueStatus) getResourceAttributes() interface{} {
+	return &attributesQueue{
+		Workers:        m.workers,
+		Idle_Workers:   m.idle,
+		Paused:         m.control.Paused,
+		Paused_Actions: m.control.Paused_Actions,
+		Actions:        m.actions,
+		Waiting:        m.waiting,
+		Watchdog:       m.watchdog,
+	}
+}
+
+func (m *queueStatus) getResourceRelations() []*resourceRelation { return nil }
+
+// Metrics:
+func (m *queueStatus) getMetrics() []byte {
+
+	var buf bytes.Buffer
+	var gauge = func(name, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	gauge("ks_queue_workers", "Size of the worker pool.")
+	fmt.Fprintf(&buf, "ks_queue_workers %d\n", m.workers)
+
+	gauge("ks_queue_idle_workers", "Workers which wait for a job.")
+	fmt.Fprintf(&buf, "ks_queue_idle_workers %d\n", m.idle)
+
+	gauge("ks_queue_paused", "The dispatching is paused on the whole cluster.")
+	fmt.Fprintf(&buf, "ks_queue_paused %d\n", getMetricBool(m.control.Paused))
+
+	gauge("ks_queue_action_paused", "The job action is paused.")
+	var handlers []*jobHandler
+	for _, v := range jobHandlers {
+		handlers = append(handlers, v)
+	}
+	sort.Slice(handlers, func(i, j int) bool { return handlers[i].name < handlers[j].name })
+
+	for _, v := range handlers {
+		fmt.Fprintf(&buf, "ks_queue_action_paused{action=%q} %d\n", v.name, getMetricBool(m.control.isActionPaused(v.action)))
+	}
+
+	gauge("ks_queue_action_running", "Running jobs by the action.")
+	for _, v := range m.actions {
+		fmt.Fprintf(&buf, "ks_queue_action_running{action=%q} %d\n", getJobHandlerName(v.action), v.Running)
+	}
+
+	gauge("ks_queue_action_waiting", "Waiting jobs by the action.")
+	for _, v := range m.actions {
+		fmt.Fprintf(&buf, "ks_queue_action_waiting{action=%q} %d\n", getJobHandlerName(v.action), v.Waiting)
+	}
+
+	fmt.Fprint(&buf, "# HELP ks_queue_control_changes_total Queue control changes made by the node.\n# TYPE ks_queue_control_changes_total counter\n")
+	var changes = getQueueControlChanges()
+	for _, change := range []string{auditActQueuePaused, auditActQueueResumed, auditActQueueActPaused, auditActQueueActResumed, auditActQueueWorkersSized} {
+		fmt.Fprintf(&buf, "ks_queue_control_changes_total{change=%q} %d\n", change, changes[change])
+	}
+
+	return buf.Bytes()
+}
+
+func getMetricBool(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package server
+
+import "strings"
+import "testing"
+import "net/http/httptest"
+
+func TestQueueStatusMetrics(t *testing.T) {
+
+	registerJobHandlers()
+
+	var status = &queueStatus{
+		workers: 4,
+		idle:    1,
+		control: &queueControl{Paused_Actions: []string{"rsview_parse"}},
+		actions: []*queueActionStatus{
+			{action: jobActHostCreate, Running: 2, Waiting: 3},
+		},
+	}
+
+	var metrics = string(status.getMetrics())
+	for _, v := range []string{
+		"# TYPE ks_queue_workers gauge\nks_queue_workers 4\n",
+		"ks_queue_idle_workers 1\n",
+		"ks_queue_paused 0\n",
+		"ks_queue_action_paused{action=\"rsview_parse\"} 1\n",
+		"ks_queue_action_paused{action=\"host_create\"} 0\n",
+		"ks_queue_action_running{action=\"host_create\"} 2\n",
+		"ks_queue_action_waiting{action=\"host_create\"} 3\n",
+		"# TYPE ks_queue_control_changes_total counter\n",
+	} {
+		if !strings.Contains(metrics, v) {
+			t.Errorf("the metrics have no %q", v)
+		}
+	}
+
+	if metrics != string(status.getMetrics()) {
+		t.Errorf("the metrics must be rendered in the same order")
+	}
+}
+
+func TestQueueControlIsActionPaused(t *testing.T) {
+
+	registerJobHandlers()
+
+	var tests = []struct {
+		ctl    *queueControl
+		action uint8
+		paused bool
+	}{
+		{new(queueControl), jobActHostCreate, false},
+		{&queueControl{Paused: true}, jobActHostCreate, true},
+		{&queueControl{Paused_Actions: []string{"host_create"}}, jobActHostCreate, true},
+		{&queueControl{Paused_Actions: []string{"host_create"}}, jobActRsviewParse, false},
+	}
+
+	for i, tt := range tests {
+		if paused := tt.ctl.isActionPaused(tt.action); paused != tt.paused {
+			t.Errorf("test %d: isActionPaused() = %v, want %v", i, paused, tt.paused)
+		}
+	}
+}
+
+func TestIsMetricsAllowed(t *testing.T) {
+
+	var saved = globConfig.Base.Api.Metrics
+	defer func() { globConfig.Base.Api.Metrics = saved }()
+
+	var tests = []struct {
+		name       string
+		token      string
+		cidrs      []string
+		remoteAddr string
+		header     string
+		allowed    bool
+	}{
+		{"disabled", "", nil, "10.0.0.1:1234", "", false},
+		{"token", "scrape", nil, "10.0.0.1:1234", "Bearer scrape", true},
+		{"wrong token", "scrape", nil, "10.0.0.1:1234", "Bearer secret", false},
+		{"no token", "scrape", nil, "10.0.0.1:1234", "", false},
+		{"cidr", "", []string{"10.0.0.0/8"}, "10.0.0.1:1234", "", true},
+		{"ipv6 cidr", "", []string{"2001:db8::/32"}, "[2001:db8::1]:1234", "", true},
+		{"out of cidr", "", []string{"10.0.0.0/8"}, "192.168.0.1:1234", "", false},
+		{"token out of cidr", "scrape", []string{"10.0.0.0/8"}, "192.168.0.1:1234", "Bearer scrape", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			globConfig.Base.Api.Metrics.Token, globConfig.Base.Api.Metrics.Cidrs = tt.token, tt.cidrs
+
+			var r = httptest.NewRequest("GET", "/metrics", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			if allowed := isMetricsAllowed(r); allowed != tt.allowed {
+				t.Errorf("isMetricsAllowed() = %v, want %v", allowed, tt.allowed)
+			}
+		})
+	}
+}
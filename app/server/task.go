@@ -1,15 +1,99 @@
 package server
 
+import "time"
+import "io"
+import "bytes"
+import "errors"
+import "strings"
+import "net/http"
+import "crypto/rand"
+import "crypto/subtle"
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+import "database/sql"
+import "text/template"
 import "github.com/satori/go.uuid"
 
 const (
 	taskTypePuppetCertDestroy = uint8(iota)
 	taskTypeInstallerStart
 )
+const (
+	taskStateCreated = uint8(iota)
+	taskStateRunning
+	taskStateDone
+	taskStateFailed
+	taskStateExpired
+)
+
+// install statuses which are reported by hosts with the enrollment token:
+const (
+	taskStatusStarted = "started"
+	taskStatusDone    = "done"
+	taskStatusFailed  = "failed"
+
+	installStatusMaxSize = 4096
+)
+
+var taskStatusStates = map[string]uint8{
+	taskStatusStarted: taskStateRunning,
+	taskStatusDone:    taskStateDone,
+	taskStatusFailed:  taskStateFailed,
+}
+
+// the final statuses are saved in the audit log:
+var installStatusAuditActs = map[string]string{
+	taskStatusDone:   auditActHostInstalled,
+	taskStatusFailed: auditActHostInstallFail,
+}
+
+type (
+	baseTask struct {
+		id     string
+		action uint8
+		state  uint8
+
+		host         string
+		mac          string
+		requested_by string
+
+		// only the hash of the enrollment token is kept, the token is in the kickstart:
+		token_hash string
+		kickstart  string
 
-type baseTask struct {
-	id     string
-	action uint8
+		// the kickstart is given by the secret url which is known to the API clients only:
+		kickstart_nonce string
+
+		expires_at time.Time
+		updated_at time.Time
+		created_at time.Time
+	}
+
+	installStatus struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+
+	// kickstart template data:
+	kickstartData struct {
+		Hostname     string
+		Ipmi_Address string
+		Mac          string
+		Token        string
+		Status_Url   string
+	}
+)
+
+// kickstarts are rendered by the install tasks, so broken templates are found at once:
+func parseKickstartTemplate() (*template.Template, error) {
+
+	tmpl, e := template.New("kickstart").Option("missingkey=error").Parse(globConfig.Base.Installer.Kickstart)
+	if e != nil {
+		return nil, errors.New("Could not parse the kickstart template: " + e.Error())
+	}
+
+	return tmpl, nil
 }
 
 func newTask() *baseTask {
@@ -18,48 +102,223 @@ func newTask() *baseTask {
 	}
 }
 
+// The previous tasks of the host are expired with their tokens, so the host has only one valid
+// token at once. The token is returned in the rendered kickstart only.
+func newInstallTask(reqId string, host *baseHost, mac string) (*baseTask, *appError) {
+
+	token, err := newTaskSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := newTaskSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var tsk = newTask()
+	tsk.action, tsk.state = taskTypeInstallerStart, taskStateCreated
+	tsk.host, tsk.mac, tsk.requested_by = host.id, mac, reqId
+	tsk.token_hash, tsk.kickstart_nonce = getEnrollmentTokenHash(token), nonce
+	tsk.expires_at = time.Now().Add(globConfig.Base.Installer.TaskTTL)
+
+	var data = &kickstartData{
+		Hostname: host.hostname,
+		Mac:      mac,
+		Token:    token,
+		Status_Url: strings.TrimSuffix(globConfig.Base.Installer.ApiUrl, "/") +
+			"/v1/install/" + mac + "/status",
+	}
+
+	if host.ipmi_address != nil {
+		data.Ipmi_Address = host.ipmi_address.String()
+	}
+
+	if tsk.kickstart, err = renderKickstart(globKickstart, data); err != nil {
+		return nil, err
+	}
+
+	if err = expireHostTasks(host.id, mac); err != nil {
+		return nil, err
+	}
+
+	if _, err = tsk.save(); err != nil {
+		return nil, err
+	}
+
+	return tsk, nil
+}
+
+// enrollment tokens and kickstart nonces are 32 random bytes:
+func newTaskSecret() (string, *appError) {
+
+	var buf = make([]byte, 32)
+	if _, e := rand.Read(buf); e != nil {
+		return "", newAppError(errInternalCommonError).log(e, "Could not generate a new task secret!")
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func getEnrollmentTokenHash(token string) string {
+	var hash = sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (m *baseTask) getKickstartUrl() string {
+	return strings.TrimSuffix(globConfig.Base.Installer.ApiUrl, "/") + "/v1/install/" + m.mac + "/kickstart/" + m.kickstart_nonce
+}
+
+// the nonce is compared in constant time, so it could not be guessed by the response time:
+func (m *baseTask) isKickstartNonce(nonce string) bool {
+	return m.kickstart_nonce != "" && subtle.ConstantTimeCompare([]byte(nonce), []byte(m.kickstart_nonce)) == 1
+}
+
+func renderKickstart(tmpl *template.Template, data *kickstartData) (string, *appError) {
+
+	var buf bytes.Buffer
+	if e := tmpl.Execute(&buf, data); e != nil {
+		return "", newAppError(errInternalCommonError).log(e, "Could not render the kickstart template!")
+	}
+
+	return buf.String(), nil
+}
+
 func (m *baseTask) save() (bool, *appError) {
 
-	if _, e := globSqlDB.Exec("INSERT INTO tasks (id, type) VALUES (?, ?)", m.id, m.action); e != nil {
-		return false, newAppError(errInternalSqlError).log(e, "")
+	if _, e := globSqlDB.Exec(
+		"INSERT INTO tasks (id,type,state,host,mac,requested_by,token_hash,kickstart,kickstart_nonce,expires_at) VALUES (?,?,?,?,?,?,?,?,?,?)",
+		m.id, m.action, m.state, m.host, m.mac, getSqlString(m.requested_by), getSqlString(m.token_hash),
+		getSqlString(m.kickstart), getSqlString(m.kickstart_nonce), m.expires_at); e != nil {
+		return false, newAppError(errInternalSqlError).log(e, "Could not save the task into DB!")
 	}
 
 	return true, nil
 }
 
+// finished tasks lose the token and the kickstart, so the token could not be used again:
 func (m *baseTask) update() *appError {
 
-	if _, e := globSqlDB.Exec("UPDATE tasks SET id = ?, type = ?", m.id, m.action); e != nil {
-		return newAppError(errInternalSqlError).log(e, "")
+	if m.isFinished() {
+		m.token_hash, m.kickstart, m.kickstart_nonce = "", "", ""
+	}
+
+	if _, e := globSqlDB.Exec("UPDATE tasks SET state = ?, token_hash = ?, kickstart = ?, kickstart_nonce = ? WHERE id = ?",
+		m.state, getSqlString(m.token_hash), getSqlString(m.kickstart), getSqlString(m.kickstart_nonce), m.id); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	return nil
+}
+
+func (m *baseTask) isFinished() bool {
+	return m.state == taskStateDone || m.state == taskStateFailed || m.state == taskStateExpired
+}
+
+func (m *baseTask) isActive(now time.Time) bool {
+	return !m.isFinished() && m.token_hash != "" && now.Before(m.expires_at)
+}
+
+// the final statuses finish the task, so the token could not be used again:
+func (m *baseTask) setStatus(status string) *appError {
+
+	var state, ok = taskStatusStates[status]
+	if !ok {
+		return newAppError(errTasksStatusInvalid).log(nil, "Could not set the unknown install status!")
+	}
+
+	if state == m.state {
+		return nil
+	}
+
+	m.state = state
+	return m.update()
+}
+
+// the status body is small, so it's limited before the parsing:
+func parseInstallStatus(r *http.Request) (*installStatus, *appError) {
+
+	var status = new(installStatus)
+	if e := json.NewDecoder(io.LimitReader(r.Body, installStatusMaxSize)).Decode(status); e != nil {
+		return nil, newAppError(errApiUnknownApiFormat).log(e, "Could not parse the install status!")
+	}
+
+	if _, ok := taskStatusStates[status.Status]; !ok {
+		return nil, newAppError(errTasksStatusInvalid).log(nil, "The host has reported the unknown install status!")
+	}
+
+	return status, nil
+}
+
+// the MAC could be moved from another host, so its tasks are expired too:
+func expireHostTasks(hId, mac string) *appError {
+
+	if _, e := globSqlDB.Exec("UPDATE tasks SET state = ?, token_hash = NULL, kickstart = NULL, kickstart_nonce = NULL WHERE (host = ? OR mac = ?) AND state IN (?,?)",
+		taskStateExpired, hId, mac, taskStateCreated, taskStateRunning); e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
 	}
 
 	return nil
 }
 
+// tasks which have not been finished by the host in the TTL are expired with their tokens:
+func expireTasks(now time.Time) (int64, *appError) {
+
+	rs, e := globSqlDB.Exec("UPDATE tasks SET state = ?, token_hash = NULL, kickstart = NULL, kickstart_nonce = NULL WHERE state IN (?,?) AND expires_at < ?",
+		taskStateExpired, taskStateCreated, taskStateRunning, now)
+	if e != nil {
+		return 0, newAppError(errInternalSqlError).log(e, "Could not exec the database query!")
+	}
+
+	count, _ := rs.RowsAffected()
+	return count, nil
+}
+
 func getTaskByHost(hId string) (*baseTask, *appError) {
+	return getTaskByQuery("SELECT "+taskColumns+" FROM tasks WHERE host = ? AND state IN (?,?) LIMIT 2",
+		hId, taskStateCreated, taskStateRunning)
+}
+
+func getTaskByMac(mac string) (*baseTask, *appError) {
+	return getTaskByQuery("SELECT "+taskColumns+" FROM tasks WHERE mac = ? AND state IN (?,?) LIMIT 2",
+		mac, taskStateCreated, taskStateRunning)
+}
 
-	rws, e := globSqlDB.Query("SELECT id, type FROM tasks where host = ? LIMIT 2", hId)
+func getTaskByToken(token string) (*baseTask, *appError) {
+	return getTaskByQuery("SELECT "+taskColumns+" FROM tasks WHERE token_hash = ? LIMIT 2", getEnrollmentTokenHash(token))
+}
+
+const taskColumns = "id,type,state,host,mac,IFNULL(requested_by,''),IFNULL(token_hash,''),IFNULL(kickstart,''),IFNULL(kickstart_nonce,''),expires_at,updated_at,created_at"
+
+func getTaskByQuery(query string, args ...interface{}) (*baseTask, *appError) {
+
+	rws, e := globSqlDB.Query(query, args...)
 	if e != nil {
-		return nil, newAppError(errInternalSqlError).log(e, "")
+		return nil, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
 	}
 	defer rws.Close()
 
 	if !rws.Next() {
 		if rws.Err() != nil {
-			return nil, newAppError(errInternalSqlError).log(e, "")
+			return nil, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
 		}
 
 		return nil, nil
 	}
 
-	var tsk = &baseTask{}
-	if e = rws.Scan(&tsk.id, &tsk.action); e != nil {
-		return nil, newAppError(errInternalSqlError).log(e, "")
+	var tsk = new(baseTask)
+	if e = scanTask(rws, tsk); e != nil {
+		return nil, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
 	}
 
 	if rws.Next() {
-		return nil, newAppError(errInternalCommonError).log(e, "Found two or more records with same host! Database is broken!")
+		return nil, newAppError(errInternalCommonError).log(nil, "Found two or more active tasks! Database is broken!")
 	}
 
 	return tsk, nil
 }
+
+func scanTask(rws *sql.Rows, tsk *baseTask) error {
+	return rws.Scan(&tsk.id, &tsk.action, &tsk.state, &tsk.host, &tsk.mac, &tsk.requested_by, &tsk.token_hash,
+		&tsk.kickstart, &tsk.kickstart_nonce, &tsk.expires_at, &tsk.updated_at, &tsk.created_at)
+}
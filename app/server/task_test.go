@@ -0,0 +1,231 @@
+package server
+
+import "net"
+import "time"
+import "strings"
+import "testing"
+import "net/http/httptest"
+import "github.com/gorilla/mux"
+
+func TestRenderKickstart(t *testing.T) {
+
+	tmpl, e := parseKickstartTemplate()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var data = &kickstartData{
+		Hostname:   "web1",
+		Mac:        "00:11:22:33:44:55",
+		Token:      "0123456789abcdef",
+		Status_Url: "https://example.com/v1/install/00:11:22:33:44:55/status",
+	}
+
+	buf, err := renderKickstart(tmpl, data)
+	if err != nil {
+		t.Fatalf("renderKickstart() has failed with the code %d", err.code)
+	}
+
+	for _, v := range []string{"web1", "Bearer 0123456789abcdef", data.Status_Url} {
+		if !strings.Contains(buf, v) {
+			t.Errorf("the kickstart %q has no %q", buf, v)
+		}
+	}
+
+	if secret := globConfig.Base.Api.SignSecret; secret != "" && strings.Contains(buf, secret) {
+		t.Errorf("the kickstart has the sign secret")
+	}
+}
+
+func TestParseKickstartTemplate(t *testing.T) {
+
+	var saved = globConfig.Base.Installer.Kickstart
+	defer func() { globConfig.Base.Installer.Kickstart = saved }()
+
+	globConfig.Base.Installer.Kickstart = "{{.Token"
+	if _, e := parseKickstartTemplate(); e == nil {
+		t.Error("parseKickstartTemplate() must fail with the broken template")
+	}
+
+	// unknown fields are found on the first render:
+	globConfig.Base.Installer.Kickstart = "{{.Secret}}"
+	tmpl, e := parseKickstartTemplate()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if _, err := renderKickstart(tmpl, new(kickstartData)); err == nil {
+		t.Error("renderKickstart() must fail with the unknown template field")
+	}
+}
+
+func TestEnrollmentToken(t *testing.T) {
+
+	token, err := newTaskSecret()
+	if err != nil {
+		t.Fatalf("newTaskSecret() has failed with the code %d", err.code)
+	}
+
+	if other, _ := newTaskSecret(); len(token) != 64 || other == token {
+		t.Errorf("newTaskSecret() = %q, want unique 32 random bytes", token)
+	}
+
+	var hash = getEnrollmentTokenHash(token)
+	if len(hash) != 64 || hash == token || hash != getEnrollmentTokenHash(token) {
+		t.Errorf("getEnrollmentTokenHash() = %q, want a stable sha256 hash", hash)
+	}
+}
+
+func TestTaskIsActive(t *testing.T) {
+
+	var now = time.Now()
+
+	var tests = []struct {
+		name   string
+		task   *baseTask
+		active bool
+	}{
+		{"created", &baseTask{state: taskStateCreated, token_hash: "hash", expires_at: now.Add(time.Minute)}, true},
+		{"running", &baseTask{state: taskStateRunning, token_hash: "hash", expires_at: now.Add(time.Minute)}, true},
+		{"expired by ttl", &baseTask{state: taskStateRunning, token_hash: "hash", expires_at: now.Add(-time.Minute)}, false},
+		{"done", &baseTask{state: taskStateDone, token_hash: "hash", expires_at: now.Add(time.Minute)}, false},
+		{"failed", &baseTask{state: taskStateFailed, token_hash: "hash", expires_at: now.Add(time.Minute)}, false},
+		{"expired", &baseTask{state: taskStateExpired, expires_at: now.Add(time.Minute)}, false},
+		{"without token", &baseTask{state: taskStateCreated, expires_at: now.Add(time.Minute)}, false},
+	}
+
+	for _, tt := range tests {
+		if active := tt.task.isActive(now); active != tt.active {
+			t.Errorf("%s: isActive() = %v, want %v", tt.name, active, tt.active)
+		}
+	}
+}
+
+func TestKickstartNonce(t *testing.T) {
+
+	var nonce, _ = newTaskSecret()
+	var tsk = &baseTask{mac: "00:11:22:33:44:55", kickstart_nonce: nonce}
+
+	var tests = []struct {
+		nonce string
+		valid bool
+	}{
+		{nonce, true},
+		{strings.ToUpper(nonce), false},
+		{nonce[:63], false},
+		{strings.Repeat("0", 64), false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if valid := tsk.isKickstartNonce(tt.nonce); valid != tt.valid {
+			t.Errorf("isKickstartNonce(%q) = %v, want %v", tt.nonce, valid, tt.valid)
+		}
+	}
+
+	// finished tasks have no nonce, so the empty one must not be accepted:
+	if (&baseTask{}).isKickstartNonce("") {
+		t.Error("isKickstartNonce() accepts the empty nonce of the finished task")
+	}
+
+	var saved = globConfig.Base.Installer.ApiUrl
+	defer func() { globConfig.Base.Installer.ApiUrl = saved }()
+
+	globConfig.Base.Installer.ApiUrl = "https://10.0.0.1:8080/"
+	if url := tsk.getKickstartUrl(); url != "https://10.0.0.1:8080/v1/install/00:11:22:33:44:55/kickstart/"+nonce {
+		t.Errorf("getKickstartUrl() = %q", url)
+	}
+}
+
+func TestParseInstallStatus(t *testing.T) {
+
+	var tests = []struct {
+		body string
+		code uint8
+	}{
+		{`{"status":"started"}`, errNotError},
+		{`{"status":"done","message":"ok"}`, errNotError},
+		{`{"status":"failed","message":"anaconda has failed"}`, errNotError},
+		{`{"status":"rebooted"}`, errTasksStatusInvalid},
+		{`{}`, errTasksStatusInvalid},
+		{`status=done`, errApiUnknownApiFormat},
+		{`{"status":"done","message":"` + strings.Repeat("a", installStatusMaxSize) + `"}`, errApiUnknownApiFormat},
+	}
+
+	for _, tt := range tests {
+		var r = httptest.NewRequest("POST", "/v1/install/00:11:22:33:44:55/status", strings.NewReader(tt.body))
+
+		status, err := parseInstallStatus(r)
+		if err != nil {
+			if err.code != tt.code {
+				t.Errorf("parseInstallStatus(%.32q) has failed with the code %d, want %d", tt.body, err.code, tt.code)
+			}
+			continue
+		}
+
+		if tt.code != errNotError || status.Status == "" {
+			t.Errorf("parseInstallStatus(%.32q) = %+v, want the code %d", tt.body, status, tt.code)
+		}
+	}
+}
+
+func TestGetBearerToken(t *testing.T) {
+
+	var tests = map[string]string{
+		"Bearer 0123456789abcdef":  "0123456789abcdef",
+		"Bearer  0123456789abcdef": "0123456789abcdef",
+		"Basic 0123456789abcdef":   "",
+		"0123456789abcdef":         "",
+		"":                         "",
+	}
+
+	for header, token := range tests {
+		var r = httptest.NewRequest("GET", "/metrics", nil)
+		r.Header.Set("Authorization", header)
+
+		if got := getBearerToken(r); got != token {
+			t.Errorf("getBearerToken(%q) = %q, want %q", header, got, token)
+		}
+	}
+}
+
+// install routes are not signed, so they must not be matched by the /v1 subrouter:
+func TestInstallRoutes(t *testing.T) {
+
+	var router = NewApiController()
+	var mac = net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}.String()
+	var nonce = strings.Repeat("0f", 32)
+
+	var tests = []struct {
+		method, path, template string
+	}{
+		{"GET", "/v1/install/" + mac + "/kickstart/" + nonce,
+			"/v1/install/{mac:(?:[0-9A-Fa-f]{2}[:-]){5}(?:[0-9A-Fa-f]{2})}/kickstart/{nonce:[0-9a-f]{64}}"},
+		{"POST", "/v1/install/" + mac + "/status", "/v1/install/{mac:(?:[0-9A-Fa-f]{2}[:-]){5}(?:[0-9A-Fa-f]{2})}/status"},
+		{"POST", "/v1/host", "/v1/host"},
+	}
+
+	for _, tt := range tests {
+		var r = httptest.NewRequest(tt.method, tt.path, nil)
+
+		var match mux.RouteMatch
+		if !router.Match(r, &match) {
+			t.Errorf("%s %s is not matched", tt.method, tt.path)
+			continue
+		}
+
+		if tmpl, _ := match.Route.GetPathTemplate(); tmpl != tt.template {
+			t.Errorf("%s %s is matched by %q, want %q", tt.method, tt.path, tmpl, tt.template)
+		}
+	}
+
+	// the kickstart is not given without the nonce of the install task:
+	for _, path := range []string{"/v1/install/" + mac + "/kickstart", "/v1/install/" + mac + "/kickstart/" + nonce[:62]} {
+		var match mux.RouteMatch
+		if router.Match(httptest.NewRequest("GET", path, nil), &match) && match.Route != nil {
+			if tmpl, _ := match.Route.GetPathTemplate(); strings.HasSuffix(tmpl, "{nonce:[0-9a-f]{64}}") {
+				t.Errorf("GET %s is matched by the kickstart route", path)
+			}
+		}
+	}
+}
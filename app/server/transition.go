@@ -0,0 +1,228 @@
+package server
+
+import "sort"
+import "time"
+import "database/sql"
+import "github.com/satori/go.uuid"
+
+// every job state change is recorded with the node and the worker which have made it:
+type jobTransition struct {
+	id         string
+	job_id     string
+	from_state sql.NullInt64
+	to_state   uint8
+	node       string
+	worker     int
+	attempt    int
+	created_at time.Time
+}
+
+// The attempt is the number of job runs, so it's counted by transitions to the
+// pending state. New jobs have no previous state.
+func (m *queueJob) saveTransition(from *uint8, to uint8) *appError {
+	return m.insertTransition(globSqlDB, from, to)
+}
+
+func (m *queueJob) insertTransition(db sqlExecer, from *uint8, to uint8) *appError {
+
+	var fromState sql.NullInt64
+	if from != nil {
+		fromState.Int64, fromState.Valid = int64(*from), true
+	}
+
+	var worker sql.NullInt64
+	if m.worker != 0 {
+		worker.Int64, worker.Valid = int64(m.worker), true
+	}
+
+	var run int
+	if to == jobStatusPending {
+		run = 1
+	}
+
+	_, e := db.Exec(`INSERT INTO job_transitions (id,job_id,from_state,to_state,node,worker,attempt)
+		SELECT ?,?,?,?,?,?,COUNT(*) + ? FROM job_transitions WHERE job_id = ? AND to_state = ?`,
+		uuid.NewV4().String(), m.id, fromState, to, getSqlString(globRaftStore.LocalId()), worker, run, m.id, jobStatusPending)
+	if e != nil {
+		return newAppError(errInternalSqlError).log(e, "Could not save the job transition!")
+	}
+
+	return nil
+}
+
+func getJobTransitions(jobId string) ([]*jobTransition, *appError) {
+
+	var trs []*jobTransition
+
+	rws, e := globSqlDB.Query(`SELECT id,job_id,from_state,to_state,IFNULL(node, ''),IFNULL(worker, 0),attempt,created_at
+		FROM job_transitions WHERE job_id = ? ORDER BY created_at`, jobId)
+	if e != nil {
+		return trs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var tr = new(jobTransition)
+		if e = rws.Scan(&tr.id, &tr.job_id, &tr.from_state, &tr.to_state, &tr.node, &tr.worker, &tr.attempt, &tr.created_at); e != nil {
+			return trs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		trs = append(trs, tr)
+	}
+
+	if rws.Err() != nil {
+		return trs, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return trs, nil
+}
+
+// apiResource interface implementation:
+func (m *jobTransition) getResourceType() string { return apiTypeTransition }
+func (m *jobTransition) getResourceId() string   { return m.id }
+
+func (m *jobTransition) getResourceAttributes() interface{} {
+
+	var attrs = &attributesTransition{
+		To:         jobStatusHumanDetail[m.to_state],
+		Node:       m.node,
+		Worker:     m.worker,
+		Attempt:    m.attempt,
+		Created_At: m.created_at.Format(time.RFC3339Nano),
+	}
+
+	if m.from_state.Valid {
+		attrs.From = jobStatusHumanDetail[uint8(m.from_state.Int64)]
+	}
+
+	return attrs
+}
+
+func (m *jobTransition) getResourceRelations() []*resourceRelation {
+	return []*resourceRelation{
+		{name: "job", loader: m.loadJobResources, linkType: apiTypeJob, linkId: m.job_id},
+	}
+}
+
+func (m *jobTransition) loadJobResources() ([]apiResource, *appError) {
+
+	jb, err := getJobById(m.job_id)
+	if err != nil {
+		return nil, err
+	}
+
+	return []apiResource{jb}, nil
+}
+
+// Job timings:
+// The queue wait lasts from the job creation (or its retry) until a worker takes the job,
+// so it includes the time which the job has been blocked by its dependencies.
+type jobTiming struct {
+	action uint8
+	jobs   map[string]bool
+	wait   []time.Duration
+	run    []time.Duration
+}
+
+func getJobTimings(since time.Time) ([]*jobTiming, *appError) {
+
+	rws, e := globSqlDB.Query(`SELECT jobs.id,jobs.action,job_transitions.from_state,job_transitions.to_state,job_transitions.created_at
+		FROM job_transitions
+		INNER JOIN jobs
+		ON jobs.id = job_transitions.job_id
+		WHERE jobs.created_at >= ?
+		ORDER BY job_transitions.job_id, job_transitions.created_at`, since.Format("2006-01-02 15:04:05"))
+	if e != nil {
+		return nil, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	var timings = make(map[uint8]*jobTiming)
+	var queuedAt, startedAt = make(map[string]time.Time), make(map[string]time.Time)
+
+	for rws.Next() {
+
+		var jobId string
+		var action, to uint8
+		var from sql.NullInt64
+		var createdAt time.Time
+
+		if e = rws.Scan(&jobId, &action, &from, &to, &createdAt); e != nil {
+			return nil, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		if _, ok := timings[action]; !ok {
+			timings[action] = &jobTiming{action: action, jobs: make(map[string]bool)}
+		}
+
+		var timing = timings[action]
+		timing.jobs[jobId] = true
+
+		if from.Valid && uint8(from.Int64) == jobStatusPending {
+			if t, ok := startedAt[jobId]; ok {
+				timing.run = append(timing.run, createdAt.Sub(t))
+				delete(startedAt, jobId)
+			}
+		}
+
+		switch to {
+		case jobStatusCreated:
+			queuedAt[jobId] = createdAt
+		case jobStatusPending:
+			if t, ok := queuedAt[jobId]; ok {
+				timing.wait = append(timing.wait, createdAt.Sub(t))
+				delete(queuedAt, jobId)
+			}
+			startedAt[jobId] = createdAt
+		}
+	}
+
+	if rws.Err() != nil {
+		return nil, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	var rs []*jobTiming
+	for _, v := range timings {
+		rs = append(rs, v)
+	}
+
+	sort.Slice(rs, func(i, j int) bool { return rs[i].action < rs[j].action })
+	return rs, nil
+}
+
+// nearest-rank percentile, p is in (0, 100]:
+func getPercentile(durations []time.Duration, p int) time.Duration {
+
+	if len(durations) == 0 {
+		return 0
+	}
+
+	var sorted = append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var rank = (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// apiResource interface implementation:
+func (m *jobTiming) getResourceType() string { return apiTypeJobTiming }
+func (m *jobTiming) getResourceId() string   { return getJobHandlerName(m.action) }
+
+func (m *jobTiming) getResourceAttributes() interface{} {
+	return &attributesJobTiming{
+		Action:      jobActHumanDetail[m.action],
+		Jobs:        len(m.jobs),
+		Runs:        len(m.run),
+		Wait_P50_Ms: getPercentile(m.wait, 50).Nanoseconds() / int64(time.Millisecond),
+		Wait_P95_Ms: getPercentile(m.wait, 95).Nanoseconds() / int64(time.Millisecond),
+		Run_P50_Ms:  getPercentile(m.run, 50).Nanoseconds() / int64(time.Millisecond),
+		Run_P95_Ms:  getPercentile(m.run, 95).Nanoseconds() / int64(time.Millisecond),
+	}
+}
+
+func (m *jobTiming) getResourceRelations() []*resourceRelation { return nil }
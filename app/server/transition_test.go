@@ -0,0 +1,35 @@
+package server
+
+import "time"
+import "testing"
+
+func TestGetPercentile(t *testing.T) {
+
+	// the input is not sorted, so the percentile must sort its copy:
+	var durations = []time.Duration{7, 3, 10, 1, 5, 9, 2, 8, 4, 6}
+
+	var tests = []struct {
+		durations []time.Duration
+		p         int
+		want      time.Duration
+	}{
+		{durations, 1, 1},
+		{durations, 50, 5},
+		{durations, 51, 6},
+		{durations, 95, 10},
+		{durations, 100, 10},
+		{[]time.Duration{42}, 50, 42},
+		{[]time.Duration{42}, 95, 42},
+		{nil, 50, 0},
+	}
+
+	for _, tt := range tests {
+		if got := getPercentile(tt.durations, tt.p); got != tt.want {
+			t.Errorf("getPercentile(%v, %d) = %d, want %d", tt.durations, tt.p, got, tt.want)
+		}
+	}
+
+	if durations[0] != 7 || durations[9] != 6 {
+		t.Errorf("getPercentile() has changed the given durations: %v", durations)
+	}
+}
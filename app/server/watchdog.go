@@ -0,0 +1,173 @@
+package server
+
+import "sync"
+import "time"
+
+// The watchdog finds jobs which have not been updated for the configured threshold.
+// Every node reports the stuck jobs, but only the raft leader marks them as timed out.
+type queueWatchdog struct {
+	sync.RWMutex
+	last_run  time.Time
+	stuck     []*queueStuckJob
+	timed_out int
+
+	done chan struct{}
+}
+
+func newQueueWatchdog() *queueWatchdog {
+	return &queueWatchdog{
+		done: make(chan struct{}, 1),
+	}
+}
+
+func (m *queueWatchdog) run() {
+
+	var ticker = time.NewTicker(globConfig.Base.Queue.Watchdog.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *queueWatchdog) destruct() {
+	close(m.done)
+}
+
+func (m *queueWatchdog) check() {
+
+	// install tasks are expired with their enrollment tokens by the leader:
+	if globRaftStore.IsLeader() {
+		if count, err := expireTasks(time.Now()); err == nil && count != 0 {
+			globLogger.Warn().Int64("tasks", count).Msg("The install tasks have not been finished in time and have been expired!")
+		}
+	}
+
+	jbs, err := getStuckJobs(globConfig.Base.Queue.Watchdog.Threshold)
+	if err != nil {
+		return
+	}
+
+	var ctl, now = getQueueControl(), time.Now()
+
+	var stuck []*queueStuckJob
+	var timedOut int
+	for _, v := range jbs {
+		if !isJobStuck(v, ctl, getJobLease(v.id), now) {
+			continue
+		}
+
+		var sj = newQueueStuckJob(v)
+		stuck = append(stuck, sj)
+
+		if globRaftStore.IsLeader() {
+			v.setTimedOut(sj)
+			timedOut++
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.last_run, m.stuck = time.Now(), stuck
+	m.timed_out += timedOut
+}
+
+func (m *queueWatchdog) getStatus() *attributesWatchdog {
+
+	m.RLock()
+	defer m.RUnlock()
+
+	var status = &attributesWatchdog{
+		Threshold: globConfig.Base.Queue.Watchdog.Threshold.String(),
+		Timed_Out: m.timed_out,
+		Stuck:     m.stuck,
+	}
+
+	if !m.last_run.IsZero() {
+		status.Last_Run = m.last_run.Format(time.RFC3339)
+	}
+
+	return status
+}
+
+// Paused jobs wait for the operator, they are not stuck. Pending jobs are running
+// while their leases are alive, so they are stuck only when the lease has expired.
+func isJobStuck(jb *queueJob, ctl *queueControl, lease *jobLease, now time.Time) bool {
+
+	if ctl.isActionPaused(jb.action) {
+		return false
+	}
+
+	if jb.state == jobStatusPending {
+		return lease == nil || now.After(lease.Expires_At)
+	}
+
+	return true
+}
+
+// the job is failed without retries, the request author is notified through the audit log and the notifier:
+func (m *queueJob) setTimedOut(stuck *queueStuckJob) {
+
+	globLogger.Error().Str("job_id", m.id).Str("job_action", m.getHumanAction()).Str("job_state", m.getHumanStateDetails()).
+		Time("updated_at", m.updated_at).Msg("The job is stuck and will be marked as timed out!")
+
+	m.appendAppError(newAppError(errJobsStuck).log(nil, "The job has been marked as timed out by the watchdog!"))
+
+	if err := newAuditEvent(m.requested_by, auditActJobTimedOut, m.id).save(); err != nil {
+		globLogger.Error().Str("job_id", m.id).Msg("Could not save the audit event for the timed out job!")
+	}
+
+	m.notifyTimedOut(stuck)
+}
+
+// Jobs which have stayed created or pending for longer than the threshold. Blocked jobs
+// wait for their dependencies, the dependencies are checked by the watchdog instead.
+func getStuckJobs(threshold time.Duration) ([]*queueJob, *appError) {
+
+	var jbs []*queueJob
+
+	rws, e := globSqlDB.Query(`SELECT id,requested_by,action,priority,state,payload,updated_at,created_at FROM jobs
+		WHERE is_failed = 0 AND state IN (?,?) AND updated_at < CURRENT_TIMESTAMP - INTERVAL ? SECOND ORDER BY updated_at`,
+		jobStatusCreated, jobStatusPending, int(threshold.Seconds()))
+	if e != nil {
+		return jbs, newAppError(errInternalSqlError).log(e, "Could not get result from DB!")
+	}
+	defer rws.Close()
+
+	for rws.Next() {
+
+		var jb = new(queueJob)
+		var payload []byte
+
+		if e = rws.Scan(&jb.id, &jb.requested_by, &jb.action, &jb.priority, &jb.state, &payload, &jb.updated_at, &jb.created_at); e != nil {
+			return jbs, newAppError(errInternalSqlError).log(e, "Could not scan the result from DB!")
+		}
+
+		// the payload is kept in the dead-letter queue only, so broken payloads are ignored:
+		jb.payload, _ = parseJobPayload(payload)
+
+		jbs = append(jbs, jb)
+	}
+
+	if rws.Err() != nil {
+		return jbs, newAppError(errInternalSqlError).log(rws.Err(), "Could not exec rows.Next method!")
+	}
+
+	return jbs, nil
+}
+
+func newQueueStuckJob(jb *queueJob) *queueStuckJob {
+	return &queueStuckJob{
+		Job_Id:     jb.id,
+		Action:     jb.getHumanAction(),
+		State:      jb.getHumanStateDetails(),
+		Stuck_For:  time.Since(jb.updated_at).Truncate(time.Second).String(),
+		Updated_At: jb.updated_at.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import "time"
+import "testing"
+
+func TestIsJobStuck(t *testing.T) {
+
+	var now = time.Now()
+	var alive = &jobLease{Expires_At: now.Add(time.Minute)}
+	var expired = &jobLease{Expires_At: now.Add(-time.Minute)}
+
+	var tests = []struct {
+		name  string
+		state uint8
+		ctl   *queueControl
+		lease *jobLease
+		stuck bool
+	}{
+		{"created", jobStatusCreated, new(queueControl), nil, true},
+		{"pending with alive lease", jobStatusPending, new(queueControl), alive, false},
+		{"pending with expired lease", jobStatusPending, new(queueControl), expired, true},
+		{"pending without lease", jobStatusPending, new(queueControl), nil, true},
+		{"paused queue", jobStatusCreated, &queueControl{Paused: true}, nil, false},
+		{"paused action", jobStatusPending, &queueControl{Paused_Actions: []string{"host_create"}}, expired, false},
+		{"another action paused", jobStatusCreated, &queueControl{Paused_Actions: []string{"rsview_parse"}}, nil, true},
+	}
+
+	registerJobHandlers()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var jb = &queueJob{action: jobActHostCreate, state: tt.state}
+			if stuck := isJobStuck(jb, tt.ctl, tt.lease, now); stuck != tt.stuck {
+				t.Errorf("isJobStuck() = %v, want %v", stuck, tt.stuck)
+			}
+		})
+	}
+}
@@ -12,6 +12,16 @@ type SysConfig struct {
 			Listen, Host string
 			ReadTimeout  time.Duration `viper:"read_timeout"`
 			WriteTimeout time.Duration `viper:"write_timeout"`
+			TLS          struct {
+				Enabled    bool
+				CertFile   string `viper:"cert_file"`
+				KeyFile    string `viper:"key_file"`
+				MinVersion string `viper:"min_version"`
+
+				// client certificates are verified if the CA bundle is set:
+				ClientCAFile      string `viper:"client_ca_file"`
+				RequireClientCert bool   `viper:"require_client_cert"`
+			}
 		}
 		Mysql struct {
 			SqlDebug           bool `viper:"sql_debug"`
@@ -21,10 +31,42 @@ type SysConfig struct {
 		}
 		Api struct {
 			SignSecret string `viper:"sign_secret"`
+			Signature  struct {
+				AllowLegacy bool          `viper:"allow_legacy"`
+				ClockSkew   time.Duration `viper:"clock_skew"`
+			}
+			KeyRotationGrace time.Duration `viper:"key_rotation_grace"`
+
+			// verified client certificate subject (CN) => api key id:
+			ClientCerts map[string]string `viper:"client_certs"`
+
+			// /metrics is given to scrapers with the bearer token or from the CIDRs,
+			// it's disabled if neither of them is defined:
+			Metrics struct {
+				Token string
+				Cidrs []string
+			}
 		}
 		Ipmi struct {
+			// resolved PTR records must be <hostname>.<hostname_tld>, the hostname must
+			// match one of base/puppet/projects regexps:
 			HostnameTLD string `viper:"hostname_tld"`
 			CIDRBlock   string `viper:"cidr_block"`
+
+			// the policy for addresses with several PTR records: single (fail on aliases),
+			// tld (the name in HostnameTLD), regexp (the name matches HostnameRegexp)
+			// or fcrdns (the name is resolved back to the address):
+			Resolver struct {
+				Policy         string
+				HostnameRegexp string `viper:"hostname_regexp"`
+			}
+
+			// server_ping dials every host IPMI address on the port (443 - the BMC web interface):
+			Ping struct {
+				Port        int
+				Timeout     time.Duration
+				Concurrency int
+			}
 		}
 		Queue struct {
 			Workers          int
@@ -32,6 +74,37 @@ type SysConfig struct {
 			JobChanBuffer    int           `viper:"job_chain_buffer"`
 			JobRetryMaxFails int           `viper:"job_retry_max_fails"`
 			JobRetryInterval time.Duration `viper:"job_retry_interval"`
+
+			JobRetryMaxInterval time.Duration `viper:"job_retry_max_interval"`
+
+			// jobs of nodes which have not been seen for the TTL are given to other nodes:
+			JobLeaseTTL time.Duration `viper:"job_lease_ttl"`
+
+			// in-flight jobs are awaited on shutdown for the grace period, it should be shorter
+			// than the lease TTL, otherwise the jobs could be given to another node meanwhile:
+			ShutdownGrace time.Duration `viper:"shutdown_grace"`
+
+			// action name => max running jobs, e.g. rsview_parse: 2
+			ActionConcurrency map[string]int `viper:"action_concurrency"`
+
+			// action name => execution timeout, e.g. rsview_parse: 5m
+			ActionTimeouts map[string]time.Duration `viper:"action_timeouts"`
+
+			// action name => retry policy, overrides the policy of the action handler:
+			ActionRetries map[string]RetryPolicy `viper:"action_retries"`
+
+			// unfinished jobs which have not been updated for the threshold are marked as timed out:
+			Watchdog struct {
+				Interval  time.Duration
+				Threshold time.Duration
+			}
+		}
+		// schedule name => cron spec (minute hour day-of-month month day-of-week), e.g. server_ping: "*/5 * * * *"
+		Scheduler struct {
+			Enabled           bool
+			Schedules         map[string]string
+			RequestsRetention time.Duration `viper:"requests_retention"`
+			StaleHostAge      time.Duration `viper:"stale_host_age"`
 		}
 		Rsview struct {
 			Url    string
@@ -49,6 +122,25 @@ type SysConfig struct {
 				JunNames  []string `viper:"jun_names"`
 			}
 		}
+		// ICQ (VK Teams) bot API, messages are rendered by text/template:
+		Icq struct {
+			Enabled   bool
+			BaseUrl   string `viper:"base_url"`
+			Token     string
+			ChatIds   []string `viper:"chat_ids"`
+			Timeout   time.Duration
+			Templates struct {
+				JobFailed       string `viper:"job_failed"`
+				HostProvisioned string `viper:"host_provisioned"`
+			}
+		}
+		// notifications are routed by the event, the project and the minimal severity
+		// (info, warning, critical) to the named channels:
+		Notify struct {
+			Channels  map[string]NotifyChannel
+			Routes    []NotifyRoute
+			Templates map[string]string
+		}
 		Raft struct {
 			Nodes          map[string]string
 			InMemoryStore  bool `viper:"in_memory_store"`
@@ -63,11 +155,25 @@ type SysConfig struct {
 				Path        string
 				RetainCount int `viper:"retain_count"`
 			}
+
+			// followers forward store changes to the leader API (node id => url, e.g.
+			// node1: https://10.0.0.1:8080), the requests are signed with the forward secret:
+			ApiUrls       map[string]string `viper:"api_urls"`
+			ForwardSecret string            `viper:"forward_secret"`
 		}
 		Puppet struct {
 			Projects  map[string]string
 			Endpoints map[string]map[string]string
 		}
+		// Kickstarts are rendered by text/template with a single-use enrollment token of the host
+		// install task, so the sign secret is never given to the boot network. The token is
+		// accepted by the host status endpoint until the install is done or the task TTL expires.
+		Installer struct {
+			// the API url which is reachable from the boot network, e.g. https://10.0.0.1:8080:
+			ApiUrl    string        `viper:"api_url"`
+			TaskTTL   time.Duration `viper:"task_ttl"`
+			Kickstart string
+		}
 		BoltDB struct {
 			Path        string
 			Mode        uint32
@@ -78,6 +184,48 @@ type SysConfig struct {
 	}
 }
 
+type (
+	// the channel type is smtp, webhook (Slack and Mattermost incoming webhooks) or telegram:
+	NotifyChannel struct {
+		Type    string
+		Timeout time.Duration
+
+		// webhook url or telegram bot api url:
+		Url string
+
+		// telegram:
+		Token   string
+		ChatIds []string `viper:"chat_ids"`
+
+		Smtp struct {
+			Addr, From         string
+			Username, Password string
+			To                 []string
+			StartTLS           bool `viper:"start_tls"`
+		}
+
+		// messages beyond the limit for the period are sent with the next digest:
+		RateLimit  int           `viper:"rate_limit"`
+		RatePeriod time.Duration `viper:"rate_period"`
+
+		// all messages are collected and sent by the notify_digest schedule:
+		Digest bool
+	}
+	// the delay grows twice from the interval up to the max interval on every fail:
+	RetryPolicy struct {
+		MaxFails    int `viper:"max_fails"`
+		Interval    time.Duration
+		MaxInterval time.Duration `viper:"max_interval"`
+	}
+	// empty projects and events match everything:
+	NotifyRoute struct {
+		Channels []string
+		Projects []string
+		Events   []string
+		Severity string
+	}
+)
+
 func NewSysConfig() *SysConfig {
 	return &SysConfig{}
 }
@@ -94,17 +242,43 @@ func (m *SysConfig) SetDefaults() *SysConfig {
 	m.Base.Http.Host = "ks-installer.example.com"
 	m.Base.Http.ReadTimeout = 10000 * time.Millisecond
 	m.Base.Http.WriteTimeout = 10000 * time.Millisecond
+	m.Base.Http.TLS.Enabled = false
+	m.Base.Http.TLS.MinVersion = "1.2"
+	m.Base.Http.TLS.RequireClientCert = false
 
 	m.Base.Api.SignSecret = "secret"
+	m.Base.Api.Signature.AllowLegacy = false
+	m.Base.Api.Signature.ClockSkew = 300 * time.Second
+	m.Base.Api.KeyRotationGrace = 24 * time.Hour
 
 	m.Base.Ipmi.HostnameTLD = "ipmi"
 	m.Base.Ipmi.CIDRBlock = "10.0.0.0/8"
+	m.Base.Ipmi.Resolver.Policy = "single"
+	m.Base.Ipmi.Ping.Port = 443
+	m.Base.Ipmi.Ping.Timeout = 3 * time.Second
+	m.Base.Ipmi.Ping.Concurrency = 16
 
 	m.Base.Queue.Workers = 1
 	m.Base.Queue.WorkersCapacity = 10
 	m.Base.Queue.JobChanBuffer = 10
 	m.Base.Queue.JobRetryMaxFails = 1
-	m.Base.Queue.JobRetryInterval = 5
+	m.Base.Queue.JobRetryInterval = 5 * time.Second
+	m.Base.Queue.JobRetryMaxInterval = 5 * time.Minute
+	m.Base.Queue.JobLeaseTTL = 30 * time.Second
+	m.Base.Queue.ShutdownGrace = 20 * time.Second
+	m.Base.Queue.Watchdog.Interval = time.Minute
+	m.Base.Queue.Watchdog.Threshold = time.Hour
+
+	m.Base.Scheduler.Enabled = true
+	m.Base.Scheduler.Schedules = map[string]string{
+		"server_ping":        "*/5 * * * *",
+		"rsview_rescan":      "0 3 * * *",
+		"requests_purge":     "30 4 * * *",
+		"stale_hosts_report": "0 9 * * 1",
+		"notify_digest":      "0 9 * * *",
+	}
+	m.Base.Scheduler.RequestsRetention = 30 * 24 * time.Hour
+	m.Base.Scheduler.StaleHostAge = 90 * 24 * time.Hour
 
 	m.Base.Rsview.Url = "https://example.com"
 	m.Base.Rsview.Client.Timeout = 1 * time.Second
@@ -116,7 +290,23 @@ func (m *SysConfig) SetDefaults() *SysConfig {
 	m.Base.Rsview.AllowRules.PortNames = []string{}
 	m.Base.Rsview.AllowRules.JunNames = []string{}
 
+	m.Base.Icq.Enabled = false
+	m.Base.Icq.BaseUrl = "https://api.icq.net/bot/v1"
+	m.Base.Icq.ChatIds = []string{}
+	m.Base.Icq.Timeout = 10 * time.Second
+	m.Base.Icq.Templates.JobFailed = "ks-installer: the job {{.Job_Id}} ({{.Action}}) has failed after {{.Fails}} attempt(s): {{.Error}}"
+	m.Base.Icq.Templates.HostProvisioned = "ks-installer: the host {{.Hostname}} ({{.Ipmi_Address}}) has been provisioned"
+
+	m.Base.Notify.Channels = map[string]NotifyChannel{}
+	m.Base.Notify.Routes = []NotifyRoute{}
+	m.Base.Notify.Templates = map[string]string{
+		"job_failed":       "The job {{.Job_Id}} ({{.Action}}) has failed after {{.Fails}} attempt(s): {{.Error}}",
+		"host_provisioned": "The host {{.Hostname}} ({{.Ipmi_Address}}) has been provisioned",
+		"job_timed_out":    "The job {{.Job_Id}} ({{.Action}}) has been stuck in the {{.State}} state for {{.Stuck_For}} and has been marked as timed out",
+	}
+
 	m.Base.Raft.Nodes = map[string]string{}
+	m.Base.Raft.ApiUrls = map[string]string{}
 	m.Base.Raft.InMemoryStore = false
 	m.Base.Raft.MaxPoolSize = 9
 	m.Base.Raft.SkipJoinErrors = false
@@ -135,5 +325,12 @@ func (m *SysConfig) SetDefaults() *SysConfig {
 	m.Base.Puppet.Endpoints = map[string]map[string]string{}
 	m.Base.Puppet.Projects = map[string]string{}
 
+	m.Base.Installer.ApiUrl = "https://example.com"
+	m.Base.Installer.TaskTTL = 2 * time.Hour
+	m.Base.Installer.Kickstart = "# ks-installer: {{.Hostname}} ({{.Mac}})\n" +
+		"%post\n" +
+		"curl -sf -X POST -H 'Authorization: Bearer {{.Token}}' -d '{\"status\":\"done\"}' {{.Status_Url}}\n" +
+		"%end\n"
+
 	return m
 }
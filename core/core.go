@@ -50,13 +50,6 @@ func (m *Core) Construct() (*Core, error) {
 	}
 	m.log.Info().Msg("boltdb has been successfully initialized")
 
-	// application initialization:
-	m.log.Debug().Msg("trying to initialize app")
-	if m.app, e = server.NewApp(m.log, m.cfg, m.bolt).Construct(); e != nil {
-		return nil, e
-	}
-	m.log.Info().Msg("app has been successfully initialized")
-
 	// raft consensus proto initialization:
 	m.log.Debug().Msg("trying to initialize raft consensus proto")
 	m.raft = raft.NewService(m.log)
@@ -65,50 +58,66 @@ func (m *Core) Construct() (*Core, error) {
 	}
 	m.log.Info().Msg("raft consensus proto has been successfully initialized")
 
+	// sql database initialization (the migrations are applied before the app start):
+	m.log.Debug().Msg("trying to initialize sql database")
+	if m.sql, e = sql.NewMysqlDriver(m.cfg).Construct(); e != nil {
+		return nil, e
+	}
+	m.log.Info().Msg("sql database has been successfully initialized")
+
+	// application initialization:
+	m.log.Debug().Msg("trying to initialize app")
+	if m.app, e = server.NewApp(m.log, m.cfg, m.bolt, m.raft.GetStore()).SetSqlDb(m.sql.GetRawDBSession()).Construct(); e != nil {
+		return nil, e
+	}
+	m.log.Info().Msg("app has been successfully initialized")
+
 	// http service initialization:
 	m.log.Debug().Msg("trying to initialize http service")
-	m.http = http.NewHTTPService(m.log, m.cfg).Construct(server.NewApiController())
+	if m.http, e = http.NewHTTPService(m.log, m.cfg).Construct(server.NewApiController()); e != nil {
+		return nil, e
+	}
 	m.log.Info().Msg("http service has been successfully initialized")
 
-	// todo: 2DELETE
-	//	if m.sql, e = new(sql.MysqlDriver).SetConfig(m.cfg).Construct(); e != nil {
-	//		return nil, e
-	//	}
-	//	m.app.SetSqlDb(m.sql.GetRawDBSession())
-
 	return m, nil
 }
 
 func (m *Core) Bootstrap(tmpFlag bool) error {
 
 	// define kernel signal catcher:
-	var kernSignal = make(chan os.Signal)
-	signal.Notify(kernSignal, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGQUIT)
+	// signal.Notify does not block, so the channel must be buffered (SIGKILL could not be caught):
+	var kernSignal = make(chan os.Signal, 1)
+	signal.Notify(kernSignal, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	// SIGHUP reloads TLS certificates without the listener restart:
+	var reloadSignal = make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
 
 	// define global error variables:
 	var e error
-	var epipe = make(chan error)
+	// services report their exit after the event loop too, so the pipe is buffered for all of them:
+	var epipe = make(chan error, 3)
 
 	// raft service bootstrap:
-	go func(e chan error, wg sync.WaitGroup, t bool) {
-		wg.Add(1)
+	m.appWg.Add(1)
+	go func(e chan error, wg *sync.WaitGroup, t bool) {
 		defer wg.Done()
 		e <- m.raft.Bootstrap(t)
-	}(epipe, m.appWg, tmpFlag)
+	}(epipe, &m.appWg, tmpFlag)
 
 	// http service bootstrap:
-	//	go func(e chan error, wg sync.WaitGroup) {
-	//		wg.Add(1)
-	//		defer wg.Done()
-	//		e <- m.http.Bootstrap()
-	//	}(epipe, m.appWg)
+	m.appWg.Add(1)
+	go func(e chan error, wg *sync.WaitGroup) {
+		defer wg.Done()
+		e <- m.http.Bootstrap()
+	}(epipe, &m.appWg)
 
 	// application bootstrap:
-	//	go func(e chan error, wg sync.WaitGroup) {
-	//		wg.Add(1)
-	//		defer wg.Done()
-	//		e <- m.app.Bootstrap()
-	//	}(epipe, m.appWg)
+	m.appWg.Add(1)
+	go func(e chan error, wg *sync.WaitGroup) {
+		defer wg.Done()
+		e <- m.app.Bootstrap()
+	}(epipe, &m.appWg)
 
 	// main application event loop:
 LOOP:
@@ -120,6 +129,13 @@ LOOP:
 			m.log.Warn().Msg("Syscall.SIG* has been detected! Closing application...")
 			break LOOP
 
+		case <-reloadSignal:
+			if err := m.http.ReloadCertificates(); err != nil {
+				m.log.Error().Err(err).Msg("Could not reload TLS certificates! The previous ones are still in use.")
+				continue
+			}
+			m.log.Info().Msg("TLS certificates have been successfully reloaded")
+
 		// application error catcher:
 		case e = <-epipe:
 			if e != nil {
@@ -137,24 +153,26 @@ LOOP:
 func (m *Core) Destruct(e *error) error {
 	var err error
 
-	// application destruct:
+	// new requests are not accepted while the application is stopping:
+	if err = m.http.Destruct(); err != nil {
+		m.log.Warn().Err(err).Msg("abnormal http exit")
+	}
+
+	// application destruct (the job queue is drained before the internal resources are closed):
 	if err = m.app.Destruct(); err != nil {
 		m.log.Warn().Err(err).Msg("abnormal app exit")
 	}
 
 	// internal resources destruct:
+	if err = m.sql.Destruct(); err != nil {
+		m.log.Warn().Err(err).Msg("abnormal sql exit")
+	}
 	if err = m.raft.DeInit(); err != nil {
 		m.log.Warn().Err(err).Msg("abnormal raft.DeInit() exit")
 	}
 	if err = m.bolt.DeInit(); err != nil {
 		m.log.Warn().Err(err).Msg("abnormal bolt.DeInit() exit")
 	}
-	//	if err = m.http.Destruct(); err != nil {
-	//		m.log.Warn().Err(err).Msg("abnormal http exit")
-	//	}
-	//	if err = m.sql.Destruct(); err != nil {
-	//		m.log.Warn().Err(err).Msg("abnormal sql exit")
-	//	}
 
 	m.appWg.Wait()
 	return *e
@@ -2,6 +2,7 @@ package http
 
 import "sync"
 import "time"
+import "context"
 import "net/http"
 
 import "github.com/gorilla/mux"
@@ -18,10 +19,16 @@ type HttpService struct {
 	conf *config.SysConfig
 
 	httpServer *http.Server
+	tls        *tlsReloader
 
-	done chan struct{}
+	done     chan struct{}
+	doneOnce sync.Once
+	stopped  chan struct{}
 }
 
+// in-flight requests are finished on shutdown, the hung ones are closed after the timeout:
+const httpShutdownTimeout = 30 * time.Second
+
 // http package - Public API:
 func NewHTTPService(log *zerolog.Logger, config *config.SysConfig) *HttpService {
 	return &HttpService{
@@ -30,8 +37,8 @@ func NewHTTPService(log *zerolog.Logger, config *config.SysConfig) *HttpService
 	}
 }
 
-func (m *HttpService) Construct(router *mux.Router) *HttpService {
-	m.done = make(chan struct{}, 1)
+func (m *HttpService) Construct(router *mux.Router) (*HttpService, error) {
+	m.done, m.stopped = make(chan struct{}), make(chan struct{})
 
 	var chain = alice.New().Append(
 		hlog.NewHandler(*m.log),
@@ -52,53 +59,81 @@ func (m *HttpService) Construct(router *mux.Router) *HttpService {
 		ReadTimeout:  m.conf.Base.Http.ReadTimeout,
 		WriteTimeout: m.conf.Base.Http.WriteTimeout}
 
+	if m.conf.Base.Http.TLS.Enabled {
+		var e error
+		if m.tls, e = newTlsReloader(m.conf); e != nil {
+			return nil, e
+		}
+		m.httpServer.TLSConfig = m.tls.getServerConfig()
+	}
+
 	m.log.Debug().Msg("Http Service has been successfully configured!")
-	return m
+	return m, nil
 }
 
 func (m *HttpService) Bootstrap() error {
+	defer close(m.stopped)
+
 	var e error
 	var wg sync.WaitGroup
 
+	wg.Add(1)
 	go m.httpServe(&wg, &e)
 
-LOOP:
-	for {
-		select {
-		case <-m.done:
-			m.log.Info().Msg("HttpService has caught DONE signal. Http Shutdown in progress ...")
-			if e == nil {
-				if e = m.httpServer.Shutdown(nil); e != nil {
-					m.log.Error().Err(e).Msg("Could not shutdown http server correctly! Abnormal exit of http.Shutdown()!")
-				}
-			}
-			break LOOP
-		}
+	<-m.done
+	m.log.Info().Msg("HttpService has caught DONE signal. Http Shutdown in progress ...")
+
+	var ctx, cancel = context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+
+	if err := m.httpServer.Shutdown(ctx); err != nil {
+		m.log.Error().Err(err).Msg("Could not shutdown http server correctly! Abnormal exit of http.Shutdown()!")
+	}
+
+	// the listener error is written by httpServe, so it's read after the exit only:
+	wg.Wait()
+	if e == http.ErrServerClosed {
+		e = nil
 	}
 
 	m.log.Debug().Msg("Http Service has been successfully bootstrapped!")
 	return e
 }
 
+// The application is destructed after the http service, so the shutdown is waited here.
 func (m *HttpService) Destruct() error {
+	m.stop()
+	<-m.stopped
+	return nil
+}
 
-	defer func(l *zerolog.Logger) {
-		if r := recover(); r != nil {
-			l.Error().Interface("panic_error", r).Msg("PANIC! The function is recovered!")
-		}
-	}(m.log)
+// ReloadCertificates rereads the TLS certificate and the client CA bundle (SIGHUP):
+func (m *HttpService) ReloadCertificates() error {
+	if m.tls == nil {
+		return nil
+	}
 
-	close(m.done)
-	return nil
+	return m.tls.reload()
 }
 
 // http package - Internal API:
+func (m *HttpService) stop() {
+	m.doneOnce.Do(func() { close(m.done) })
+}
+
 func (m *HttpService) httpServe(wg *sync.WaitGroup, e *error) {
-	wg.Add(1)
 	defer wg.Done()
 	m.log.Debug().Msg("http.ListenAndServe executing ...")
-	if *e = m.httpServer.ListenAndServe(); *e != nil && *e != http.ErrServerClosed {
+
+	if m.tls != nil {
+		// the certificates are given by TLSConfig.GetCertificate:
+		*e = m.httpServer.ListenAndServeTLS("", "")
+	} else {
+		*e = m.httpServer.ListenAndServe()
+	}
+
+	if *e != nil && *e != http.ErrServerClosed {
 		m.log.Error().Err(*e).Msg("Http.ListenAndServe abnormal exit!")
-		close(m.done)
+		m.stop()
 	}
 }
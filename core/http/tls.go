@@ -0,0 +1,104 @@
+package http
+
+import "sync"
+import "errors"
+import "io/ioutil"
+import "crypto/tls"
+import "crypto/x509"
+
+import "github.com/MindHunter86/ks-installer/core/config"
+
+var (
+	errTlsUnknownVersion = errors.New("Unknown TLS version in the configuration file! Use 1.0, 1.1, 1.2 or 1.3.")
+	errTlsInvalidCA      = errors.New("Could not find any certificate in the client CA bundle!")
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// the certificate and the CA bundle are swapped on reload, established
+// connections keep the previous config until they are closed
+type tlsReloader struct {
+	sync.RWMutex
+
+	conf   *config.SysConfig
+	config *tls.Config
+}
+
+func newTlsReloader(conf *config.SysConfig) (*tlsReloader, error) {
+	var m = &tlsReloader{
+		conf: conf,
+	}
+
+	return m, m.reload()
+}
+
+func (m *tlsReloader) reload() error {
+
+	var tlsConf = m.conf.Base.Http.TLS
+
+	minVersion, ok := tlsVersions[tlsConf.MinVersion]
+	if !ok {
+		return errTlsUnknownVersion
+	}
+
+	cert, e := tls.LoadX509KeyPair(tlsConf.CertFile, tlsConf.KeyFile)
+	if e != nil {
+		return e
+	}
+
+	var config = &tls.Config{
+		MinVersion:   minVersion,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if tlsConf.ClientCAFile != "" {
+		buf, e := ioutil.ReadFile(tlsConf.ClientCAFile)
+		if e != nil {
+			return e
+		}
+
+		var pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return errTlsInvalidCA
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if tlsConf.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	m.Lock()
+	m.config = config
+	m.Unlock()
+
+	return nil
+}
+
+func (m *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.config, nil
+}
+
+func (m *tlsReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return &m.config.Certificates[0], nil
+}
+
+// the listener config delegates everything to the current reloaded config:
+func (m *tlsReloader) getServerConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate:     m.getCertificate,
+		GetConfigForClient: m.getConfigForClient,
+	}
+}
@@ -124,6 +124,8 @@ func (m *RaftService) Init(c *config.SysConfig, b *bolt.DB) error {
 	}
 
 	m.store = newStore(b, c.Base.Raft.Timeouts.Commit)
+	m.store.localId = m.localId
+	m.store.nodes = m.nodes
 
 	m.skipJoinErrs = c.Base.Raft.SkipJoinErrors
 	return nil
@@ -135,6 +137,7 @@ func (m *RaftService) Bootstrap(forceBootstrap bool) error {
 	if m.raft, e = hraft.NewRaft(m.config, (*raftFSM)(m.store), m.logStore, m.stableStore, m.snapStore, m.transport); e != nil {
 		return e
 	}
+	m.store.setRaft(m.raft)
 
 	if ft := m.raft.BootstrapCluster(*m.configuration); ft.Error() != nil {
 		if ft.Error() != hraft.ErrCantBootstrap {
@@ -180,6 +183,10 @@ func (m *RaftService) Bootstrap(forceBootstrap bool) error {
 	return nil
 }
 
+func (m *RaftService) GetStore() *Store {
+	return m.store
+}
+
 func (m *RaftService) DeInit() error {
 
 	close(m.donePipe)
@@ -190,7 +197,13 @@ func (m *RaftService) DeInit() error {
 		}
 	}(m.logger)
 
-	return m.raft.Shutdown().Error()
+	// the raft is created by the bootstrap goroutine, so it's taken from the store:
+	var rft = m.store.getRaft()
+	if rft == nil {
+		return nil
+	}
+
+	return rft.Shutdown().Error()
 }
 
 func (m *RaftService) join(nodeId, nodeAddr string) error {
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,15 +21,24 @@ const (
 var (
 	errRaftIsNotLeader     = errors.New("the current node is not leader")
 	errRaftAbnormalCommand = errors.New("abnormal command has been received")
+	errRaftUnknownLeader   = errors.New("the cluster has no known leader")
 )
 
 type (
 	Store struct {
 		bdb *bolt.DB
-		rft *hraft.Raft
 
+		// the raft is set by the bootstrap goroutine, but it's used by the API and queue goroutines:
+		rftMu sync.RWMutex
+		rft   *hraft.Raft
+
+		localId     string
+		nodes       map[string]*net.TCPAddr
 		commTimeout time.Duration
 
+		// followers give their commands to the leader with the forwarder:
+		forward func(leaderId string, cmd []byte) error
+
 		sync.RWMutex
 		m map[string]string
 	}
@@ -53,17 +64,71 @@ func newStore(b *bolt.DB, t time.Duration) *Store {
 	}
 }
 
-func (m *Store) Get(key string) string {
+func (m *Store) Get(bucket, key string) string {
 	m.RLock()
 	defer m.RUnlock()
-	return m.m[key]
+	return m.m[storeKey(bucket, key)]
 }
 
-func (m *Store) Set(bucket, key, value string) error {
-	if m.rft.State() != hraft.Leader {
-		return errRaftIsNotLeader // todo: add leader-forwarding (masterhost:port/internal/master_request?req=%s)
+func (m *Store) List(bucket string) map[string]string {
+	m.RLock()
+	defer m.RUnlock()
+
+	var prefix = storeKey(bucket, "")
+	var values = make(map[string]string)
+
+	for k, v := range m.m {
+		if strings.HasPrefix(k, prefix) {
+			values[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+
+	return values
+}
+
+func (m *Store) LocalId() string {
+	return m.localId
+}
+
+func (m *Store) setRaft(r *hraft.Raft) {
+	m.rftMu.Lock()
+	defer m.rftMu.Unlock()
+	m.rft = r
+}
+
+func (m *Store) getRaft() *hraft.Raft {
+	m.rftMu.RLock()
+	defer m.rftMu.RUnlock()
+	return m.rft
+}
+
+func (m *Store) IsLeader() bool {
+	var rft = m.getRaft()
+	return rft != nil && rft.State() == hraft.Leader
+}
+
+// the leader is found by its raft address in the configured node list:
+func (m *Store) LeaderId() string {
+	var rft = m.getRaft()
+	if rft == nil {
+		return ""
+	}
+
+	var leader = string(rft.Leader())
+	for id, addr := range m.nodes {
+		if addr.String() == leader {
+			return id
+		}
 	}
 
+	return ""
+}
+
+func (m *Store) SetForwarder(f func(leaderId string, cmd []byte) error) {
+	m.forward = f
+}
+
+func (m *Store) Set(bucket, key, value string) error {
 	buf, e := json.Marshal(&raftCmd{
 		Act:    raftActSet,
 		Bucket: bucket,
@@ -74,15 +139,10 @@ func (m *Store) Set(bucket, key, value string) error {
 		return e
 	}
 
-	fut := m.rft.Apply(buf, m.commTimeout)
-	return fut.Error()
+	return m.apply(buf)
 }
 
 func (m *Store) Del(bucket, key string) error {
-	if m.rft.State() != hraft.Leader {
-		return errRaftIsNotLeader // todo: add leader-forwarding (masterhost:port/internal/master_request?req=%s)
-	}
-
 	buf, e := json.Marshal(&raftCmd{
 		Act:    raftActDel,
 		Bucket: bucket,
@@ -92,8 +152,43 @@ func (m *Store) Del(bucket, key string) error {
 		return e
 	}
 
-	fut := m.rft.Apply(buf, m.commTimeout)
-	return fut.Error()
+	return m.apply(buf)
+}
+
+// Apply is used by the leader for the commands forwarded by followers. The command
+// is checked here, because the FSM panics on abnormal commands.
+func (m *Store) Apply(buf []byte) error {
+	if !m.IsLeader() {
+		return errRaftIsNotLeader
+	}
+
+	var cmd *raftCmd
+	if e := json.Unmarshal(buf, &cmd); e != nil {
+		return e
+	}
+
+	if cmd == nil || (cmd.Act != raftActSet && cmd.Act != raftActDel) {
+		return errRaftAbnormalCommand
+	}
+
+	return m.getRaft().Apply(buf, m.commTimeout).Error()
+}
+
+func (m *Store) apply(buf []byte) error {
+	if m.IsLeader() {
+		return m.getRaft().Apply(buf, m.commTimeout).Error()
+	}
+
+	if m.forward == nil {
+		return errRaftIsNotLeader
+	}
+
+	var leaderId = m.LeaderId()
+	if leaderId == "" {
+		return errRaftUnknownLeader
+	}
+
+	return m.forward(leaderId, buf)
 }
 
 // raftFSM methods:
@@ -143,17 +238,21 @@ func (m *raftFSM) Restore(rc io.ReadCloser) error {
 func (m *raftFSM) applySet(bucket, key, value string) interface{} {
 	m.Lock()
 	defer m.Unlock()
-	m.m[key] = value // todo: replce in mem map with boldb
+	m.m[storeKey(bucket, key)] = value // todo: replce in mem map with boldb
 	return nil
 }
 
 func (m *raftFSM) applyDel(bucket, key string) interface{} {
 	m.Lock()
 	defer m.Unlock()
-	delete(m.m, key)
+	delete(m.m, storeKey(bucket, key))
 	return nil
 }
 
+func storeKey(bucket, key string) string {
+	return bucket + "/" + key
+}
+
 // fsmSnapshot methods:
 func (m *fsmSnapshot) Persist(sink hraft.SnapshotSink) error {
 	err := func() error {
@@ -0,0 +1,27 @@
+-- MySQL Workbench Synchronization
+
+SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
+SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;
+SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='TRADITIONAL';
+
+CREATE TABLE IF NOT EXISTS `ks-installer`.`events` (
+  `id` VARCHAR(36) NOT NULL,
+  `request_id` VARCHAR(36) NULL DEFAULT NULL,
+  `action` VARCHAR(32) NOT NULL,
+  `subject` VARCHAR(64) NOT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  INDEX `fk_events_request_id_idx` (`request_id` ASC),
+  INDEX `events_subject_idx` (`subject` ASC),
+  CONSTRAINT `fk_events_request_id`
+    FOREIGN KEY (`request_id`)
+    REFERENCES `ks-installer`.`requests` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE RESTRICT)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8;
+
+
+SET SQL_MODE=@OLD_SQL_MODE;
+SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
+SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
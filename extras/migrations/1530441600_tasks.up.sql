@@ -0,0 +1,35 @@
+-- MySQL Workbench Synchronization
+
+SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
+SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;
+SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='TRADITIONAL';
+
+CREATE TABLE IF NOT EXISTS `ks-installer`.`tasks` (
+  `id` VARCHAR(36) NOT NULL,
+  `type` TINYINT(1) UNSIGNED NOT NULL,
+  `state` TINYINT(1) UNSIGNED NOT NULL DEFAULT 0,
+  `host` VARCHAR(36) NOT NULL,
+  `mac` VARCHAR(17) NOT NULL,
+  `requested_by` VARCHAR(36) NULL DEFAULT NULL,
+  `token_hash` CHAR(64) NULL DEFAULT NULL,
+  `kickstart` TEXT NULL DEFAULT NULL,
+  `expires_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `tasks_token_hash_UNIQUE` (`token_hash` ASC),
+  INDEX `tasks_host_idx` (`host` ASC, `state` ASC),
+  INDEX `tasks_mac_idx` (`mac` ASC, `state` ASC),
+  INDEX `tasks_expires_at_idx` (`state` ASC, `expires_at` ASC),
+  CONSTRAINT `fk_tasks_host`
+    FOREIGN KEY (`host`)
+    REFERENCES `ks-installer`.`hosts` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE RESTRICT)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8;
+
+
+SET SQL_MODE=@OLD_SQL_MODE;
+SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
+SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
@@ -0,0 +1,15 @@
+-- MySQL Workbench Synchronization
+
+SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
+SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;
+SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='TRADITIONAL';
+
+ALTER TABLE `ks-installer`.`jobs` 
+ADD COLUMN `payload` TEXT NULL DEFAULT NULL AFTER `is_failed`,
+ADD COLUMN `interrupted` TINYINT(1) UNSIGNED NOT NULL DEFAULT 0 AFTER `payload`,
+ADD INDEX `jobs_state_idx` (`state` ASC, `is_failed` ASC);
+
+
+SET SQL_MODE=@OLD_SQL_MODE;
+SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
+SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
@@ -0,0 +1,28 @@
+-- MySQL Workbench Synchronization
+
+SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
+SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;
+SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='TRADITIONAL';
+
+CREATE TABLE IF NOT EXISTS `ks-installer`.`job_dependencies` (
+  `job_id` VARCHAR(36) NOT NULL,
+  `depends_on` VARCHAR(36) NOT NULL,
+  PRIMARY KEY (`job_id`, `depends_on`),
+  INDEX `fk_job_dependencies_depends_on_idx` (`depends_on` ASC),
+  CONSTRAINT `fk_job_dependencies_job_id`
+    FOREIGN KEY (`job_id`)
+    REFERENCES `ks-installer`.`jobs` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE RESTRICT,
+  CONSTRAINT `fk_job_dependencies_depends_on`
+    FOREIGN KEY (`depends_on`)
+    REFERENCES `ks-installer`.`jobs` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE RESTRICT)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8;
+
+
+SET SQL_MODE=@OLD_SQL_MODE;
+SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
+SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
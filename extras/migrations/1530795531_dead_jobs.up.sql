@@ -0,0 +1,30 @@
+-- MySQL Workbench Synchronization
+
+SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
+SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;
+SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='TRADITIONAL';
+
+CREATE TABLE IF NOT EXISTS `ks-installer`.`dead_jobs` (
+  `job_id` VARCHAR(36) NOT NULL,
+  `action` TINYINT(1) UNSIGNED NOT NULL,
+  `error_code` TINYINT(1) UNSIGNED NOT NULL,
+  `payload` TEXT NULL DEFAULT NULL,
+  `attempts` TEXT NOT NULL,
+  `failed_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `requeued_at` TIMESTAMP NULL DEFAULT NULL,
+  `requeued_by` VARCHAR(36) NULL DEFAULT NULL,
+  PRIMARY KEY (`job_id`),
+  INDEX `dead_jobs_action_idx` (`action` ASC, `error_code` ASC),
+  INDEX `dead_jobs_failed_at_idx` (`failed_at` ASC),
+  CONSTRAINT `fk_dead_jobs_job_id`
+    FOREIGN KEY (`job_id`)
+    REFERENCES `ks-installer`.`jobs` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE RESTRICT)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8;
+
+
+SET SQL_MODE=@OLD_SQL_MODE;
+SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
+SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
@@ -0,0 +1,29 @@
+-- MySQL Workbench Synchronization
+
+SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
+SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;
+SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='TRADITIONAL';
+
+CREATE TABLE IF NOT EXISTS `ks-installer`.`job_transitions` (
+  `id` VARCHAR(36) NOT NULL,
+  `job_id` VARCHAR(36) NOT NULL,
+  `from_state` TINYINT(1) UNSIGNED NULL DEFAULT NULL,
+  `to_state` TINYINT(1) UNSIGNED NOT NULL,
+  `node` VARCHAR(255) NULL DEFAULT NULL,
+  `worker` SMALLINT(5) UNSIGNED NULL DEFAULT NULL,
+  `attempt` SMALLINT(5) UNSIGNED NOT NULL DEFAULT 0,
+  `created_at` TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  PRIMARY KEY (`id`),
+  INDEX `job_transitions_job_id_idx` (`job_id` ASC, `created_at` ASC),
+  CONSTRAINT `fk_job_transitions_job_id`
+    FOREIGN KEY (`job_id`)
+    REFERENCES `ks-installer`.`jobs` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE RESTRICT)
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8;
+
+
+SET SQL_MODE=@OLD_SQL_MODE;
+SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
+SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
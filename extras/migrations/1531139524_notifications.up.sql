@@ -0,0 +1,25 @@
+-- MySQL Workbench Synchronization
+
+SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0;
+SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;
+SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='TRADITIONAL';
+
+CREATE TABLE IF NOT EXISTS `ks-installer`.`notifications` (
+  `id` VARCHAR(36) NOT NULL,
+  `channel` VARCHAR(64) NOT NULL,
+  `event` VARCHAR(32) NOT NULL,
+  `severity` TINYINT(1) UNSIGNED NOT NULL DEFAULT 0,
+  `text` TEXT NOT NULL,
+  `digest` TINYINT(1) UNSIGNED NOT NULL DEFAULT 0,
+  `sent_at` TIMESTAMP NULL DEFAULT NULL,
+  `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  INDEX `notifications_channel_idx` (`channel` ASC, `created_at` ASC),
+  INDEX `notifications_digest_idx` (`digest` ASC, `sent_at` ASC))
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8;
+
+
+SET SQL_MODE=@OLD_SQL_MODE;
+SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;
+SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS;
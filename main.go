@@ -3,8 +3,12 @@ package main
 import "os"
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"time"
 
+	"github.com/MindHunter86/ks-installer/app/client"
 	"github.com/MindHunter86/ks-installer/core"
 	"github.com/MindHunter86/ks-installer/core/config"
 	"github.com/mitchellh/mapstructure"
@@ -17,6 +21,47 @@ import (
 
 var log zerolog.Logger
 
+// filters for the dead-letter commands:
+var deadLetterFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "action",
+		Usage: "job action: server_ping, host_create or rsview_parse",
+	},
+	cli.StringFlag{
+		Name:  "code",
+		Usage: "internal code of the last job error",
+	},
+	cli.StringFlag{
+		Name:  "job-id",
+		Usage: "job id",
+	},
+	cli.IntFlag{
+		Name:  "limit",
+		Usage: "max number of jobs (1..1000)",
+	},
+}
+
+// common flags for the commands which work through the API:
+var apiFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "api-url",
+		Usage:  "API endpoint of the cluster leader",
+		Value:  "http://localhost:8080",
+		EnvVar: "KS_API_URL",
+	},
+	cli.StringFlag{
+		Name:   "key-id",
+		Usage:  "API key id which is used for the request signature",
+		Value:  "default",
+		EnvVar: "KS_API_KEY_ID",
+	},
+	cli.StringFlag{
+		Name:   "secret",
+		Usage:  "API key secret which is used for the request signature",
+		EnvVar: "KS_API_SECRET",
+	},
+}
+
 func main() {
 
 	// log initialization:
@@ -120,6 +165,181 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:    "key",
+			Aliases: []string{"k"},
+			Usage:   "command for API keys management",
+			Subcommands: []cli.Command{
+				{
+					Name:      "issue",
+					Aliases:   []string{"i"},
+					Usage:     "issue a new API key; the secret is shown only once",
+					ArgsUsage: "KEY_ID",
+					Flags: append([]cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "scope",
+							Usage: "key scope: host:create, host:read, job:retry or cluster:admin",
+						},
+						cli.StringSliceFlag{
+							Name:  "cidr",
+							Usage: "allow the key only from the given source `CIDR`",
+						},
+					}, apiFlags...),
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return errors.New("The key id argument is required!")
+						}
+
+						return apiCall(c, "POST", "/v1/keys", nil, map[string]interface{}{
+							"id":     c.Args().First(),
+							"scopes": c.StringSlice("scope"),
+							"cidrs":  c.StringSlice("cidr"),
+						})
+					},
+				},
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "list all API keys",
+					Flags:   apiFlags,
+					Action: func(c *cli.Context) error {
+						return apiCall(c, "GET", "/v1/keys", nil, nil)
+					},
+				},
+				{
+					Name:      "rotate",
+					Aliases:   []string{"r"},
+					Usage:     "generate a new secret; the previous one is valid during the grace period",
+					ArgsUsage: "KEY_ID",
+					Flags: append([]cli.Flag{
+						cli.DurationFlag{
+							Name:  "grace",
+							Usage: "grace period for the previous secret (the server default if not set)",
+						},
+					}, apiFlags...),
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return errors.New("The key id argument is required!")
+						}
+
+						var query = url.Values{}
+						if c.IsSet("grace") {
+							query.Set("grace", c.Duration("grace").String())
+						}
+
+						return apiCall(c, "POST", "/v1/keys/"+url.PathEscape(c.Args().First())+"/rotate", query, nil)
+					},
+				},
+				{
+					Name:      "revoke",
+					Aliases:   []string{"d"},
+					Usage:     "revoke the API key on all cluster nodes",
+					ArgsUsage: "KEY_ID",
+					Flags:     apiFlags,
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return errors.New("The key id argument is required!")
+						}
+
+						return apiCall(c, "DELETE", "/v1/keys/"+url.PathEscape(c.Args().First()), nil, nil)
+					},
+				},
+			},
+		},
+		{
+			Name:    "dead-letter",
+			Aliases: []string{"dl"},
+			Usage:   "command for failed jobs management",
+			Subcommands: []cli.Command{
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "list failed jobs with their payloads and attempts",
+					Flags:   append(deadLetterFlags, apiFlags...),
+					Action: func(c *cli.Context) error {
+						return apiCall(c, "GET", "/v1/dead-jobs", deadLetterQuery(c), nil)
+					},
+				},
+				{
+					Name:    "requeue",
+					Aliases: []string{"r"},
+					Usage:   "requeue all failed jobs which match the given filters",
+					Flags:   append(deadLetterFlags, apiFlags...),
+					Action: func(c *cli.Context) error {
+						return apiCall(c, "POST", "/v1/dead-jobs/requeue", deadLetterQuery(c), nil)
+					},
+				},
+			},
+		},
+		{
+			Name:    "queue",
+			Aliases: []string{"q"},
+			Usage:   "command for job queue control",
+			Subcommands: []cli.Command{
+				{
+					Name:    "status",
+					Aliases: []string{"s"},
+					Usage:   "show workers, waiting jobs and the control state of the node",
+					Flags:   apiFlags,
+					Action: func(c *cli.Context) error {
+						return apiCall(c, "GET", "/v1/queue", nil, nil)
+					},
+				},
+				{
+					Name:      "pause",
+					Aliases:   []string{"p"},
+					Usage:     "pause the dispatching on the whole cluster or only the given job action",
+					ArgsUsage: "[ACTION]",
+					Flags:     apiFlags,
+					Action: func(c *cli.Context) error {
+						return apiCall(c, "POST", queueControlPath(c, "pause"), nil, nil)
+					},
+				},
+				{
+					Name:      "resume",
+					Aliases:   []string{"r"},
+					Usage:     "resume the dispatching on the whole cluster or only the given job action",
+					ArgsUsage: "[ACTION]",
+					Flags:     apiFlags,
+					Action: func(c *cli.Context) error {
+						return apiCall(c, "POST", queueControlPath(c, "resume"), nil, nil)
+					},
+				},
+				{
+					Name:      "resize",
+					Usage:     "resize the worker pool of the node live",
+					ArgsUsage: "COUNT",
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name:  "node",
+							Usage: "raft node `ID` (the node which serves the request if not set)",
+						},
+					}, apiFlags...),
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return errors.New("The workers count argument is required!")
+						}
+
+						var query = url.Values{}
+						query.Set("count", c.Args().First())
+						if c.String("node") != "" {
+							query.Set("node", c.String("node"))
+						}
+
+						return apiCall(c, "POST", "/v1/queue/workers", query, nil)
+					},
+				},
+				{
+					Name:    "metrics",
+					Aliases: []string{"m"},
+					Usage:   "show queue metrics in the prometheus format",
+					Flags:   apiFlags,
+					Action: func(c *cli.Context) error {
+						return apiCall(c, "GET", "/v1/metrics", nil, nil)
+					},
+				},
+			},
+		},
 		{
 			Name:    "host",
 			Aliases: []string{"ho"},
@@ -161,3 +381,45 @@ func main() {
 		log.Fatal().Err(e).Msg("Could not run the App!")
 	}
 }
+
+func deadLetterQuery(c *cli.Context) url.Values {
+
+	var query = url.Values{}
+	for flag, filter := range map[string]string{"action": "action", "code": "code", "job-id": "job_id"} {
+		if c.String(flag) != "" {
+			query.Set("filter["+filter+"]", c.String(flag))
+		}
+	}
+
+	if c.IsSet("limit") {
+		query.Set("page[limit]", fmt.Sprint(c.Int("limit")))
+	}
+
+	return query
+}
+
+func queueControlPath(c *cli.Context, change string) string {
+
+	if c.NArg() == 0 {
+		return "/v1/queue/" + change
+	}
+
+	return "/v1/queue/actions/" + url.PathEscape(c.Args().First()) + "/" + change
+}
+
+func apiCall(c *cli.Context, method, path string, query url.Values, payload interface{}) error {
+
+	if c.String("secret") == "" {
+		return errors.New("The API key secret is required! Use --secret or KS_API_SECRET.")
+	}
+
+	var api = client.NewApiClient(c.String("api-url"), c.String("key-id"), c.String("secret"))
+
+	rsp, e := api.Do(method, path, query, payload)
+	if e != nil {
+		return e
+	}
+
+	fmt.Println(string(rsp))
+	return nil
+}